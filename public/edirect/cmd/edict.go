@@ -31,14 +31,30 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"eutils"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"io"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // network server for EDirect local PubMed archive and search system
@@ -53,6 +69,27 @@ import (
 // export NQUIRE_EDICT_SERVER to override nquire -edict address when
 // connecting to a remote server instance
 
+// edict polls the Postings/GENERATION marker written by rchive -advance
+// after all postings of an update are published (every 30 seconds, or as
+// set by -reload, with 0 to disable), lets searches and citation matches in
+// flight finish on the old generation, then discards cached journal, MeSH,
+// and citation data before running new ones
+
+// each search is limited by -timeout seconds (default 60) and by -memory
+// megabytes of postings data (default unlimited), and is abandoned if the
+// client disconnects
+
+// search results are kept in an LRU cache keyed by normalized query, limited
+// by -qsize entries (default 1000) and -qttl seconds (default 3600), emptied
+// on generation change, and saved to and restored from -qfile if given
+
+// with -fill URL, PubMed records missing from the local archive are requested
+// in batches from an efetch-compatible server, saved in the archive, and then
+// returned by /fetch and /extract
+
+// SIGTERM or SIGINT stops accepting connections and waits for requests
+// in progress to complete before exiting
+
 var edictHelp = `
 PubMed Local Archive Term Queries
 
@@ -84,6 +121,11 @@ Retrieval of Compressed Records for faster Network Transfer
 
   nquire -edict stream -id 6275390 13970600 | gunzip -c
 
+ Records can instead be sent as zstd frames, using the archive dictionary if it has one:
+
+  nquire -get "localhost:8080/stream/dictionary" > pubmed.dict
+  nquire -get "localhost:8080/stream" -id 6275390,13970600 -codec zstd | zstd -d -D pubmed.dict
+
 Combined Query and Retrieval
 
   nquire -edict search -query "PNAS [JOUR]" |
@@ -108,6 +150,22 @@ PubmedArticleSet Wrappers
     nquire -edict stream tail
   ) | gunzip -c
 
+Server-Side Extraction
+
+  nquire -edict extract -id 6275390 13970600 \
+    -arg "-pattern" -arg "PubmedArticle" \
+    -arg "-element" -arg "MedlineCitation/PMID" -arg "ArticleTitle"
+
+  nquire -edict extract -query "catabolite repress* [TIAB]" \
+    -arg "-pattern" -arg "PubmedArticle" -arg "-element" -arg "Journal/ISOAbbreviation"
+
+ Reusing a Search by the History Key in its X-History-Key Header:
+
+  key=$( curl -s -D - -o /dev/null "localhost:8080/search?query=tn3+transposition+immunity+%5BTIAB%5D" |
+         tr -d '\r' | sed -n 's/^X-History-Key: //p' )
+  nquire -edict extract -key "$key" \
+    -arg "-pattern" -arg "PubmedArticle" -arg "-element" -arg "MedlineCitation/PMID"
+
 Citation Matching
 
  From Command-Line Arguments:
@@ -132,6 +190,17 @@ Citation Matching
   grep CITATION | tr '\n' '\0' |
   xargs -0 -n 50 nquire -edict match -citation
 
+Batch Citation Matching
+
+ POST body of CITATION XML, JSON lines, or GenBank or EMBL flatfiles,
+ results are streamed as JSON lines in input order:
+
+  efetch -db nuccore -id J01714 -format gb |
+  curl -s -X POST -H "Content-Type: text/plain" --data-binary @- "localhost:8080/match?format=gbf"
+
+  printf '{"author": ["Kans JA"], "journal": "J Bacteriol", "year": 1989, "page": "1904"}\n' |
+  curl -s -X POST -H "Content-Type: application/x-ndjson" --data-binary @- "localhost:8080/match"
+
 Journal Name Lookup
 
   nquire -edict journal -query "biorxiv"
@@ -154,6 +223,178 @@ var pmaSetTail = `</PubmedArticleSet>
 
 var streamContentType = "application/octet-stream"
 
+// limits on server-side xtract requests
+const (
+	maxExtractArgs   = 200
+	maxExtractArgLen = 1024
+	maxExtractIDs    = 10000
+)
+
+// checkExtractArgs parses xtract arguments, returning an error message instead of exiting
+func checkExtractArgs(xargs []string) (*eutils.Block, string, string) {
+
+	if len(xargs) < 2 || (xargs[0] != "-pattern" && xargs[0] != "-Pattern") {
+		return nil, "", "No -pattern in xtract arguments"
+	}
+
+	// look for -pattern Parent/* construct for heterogeneous data
+	topPattern, star := eutils.SplitInTwoLeft(xargs[1], "/")
+	if topPattern == "" || strings.HasPrefix(topPattern, "-") {
+		return nil, "", "Item missing after -pattern command"
+	}
+	parent := ""
+	if star == "*" {
+		parent = topPattern
+	} else if star != "" {
+		return nil, "", "-pattern Parent/Child construct is not supported"
+	}
+
+	cmds, err := eutils.CheckArguments(xargs, topPattern)
+	if err != nil {
+		return nil, "", err.Error()
+	}
+	if cmds == nil {
+		return nil, "", "Problem parsing xtract arguments"
+	}
+
+	return cmds, parent, ""
+}
+
+// historyKey derives a short key for a search result set from its normalized query
+func historyKey(key string) string {
+
+	sum := sha1.Sum([]byte(key))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// buildCitation makes CITATION XML from individual -author, -title, -journal, -year, -volume, -issue, and -page arguments
+func buildCitation(params map[string][]string) string {
+
+	var arry []string
+
+	arry = append(arry, "<CITATION>")
+
+	addItems := func(names []string, tags []string) {
+		for _, name := range names {
+			vals, ok := params[name]
+			if ok {
+				for n, tag := range tags {
+					if len(vals) > n {
+						// only keep first page
+						if tag == "PAGE" {
+							vals[n], _ = eutils.SplitInTwoLeft(vals[n], "-")
+						}
+						arry = append(arry, "<"+tag+">"+vals[n]+"</"+tag+">")
+					}
+				}
+			}
+		}
+	}
+
+	// allow flexibility in argument names
+	addItems([]string{"author", "auth"}, []string{"FAUT", "LAUT"})
+	addItems([]string{"faut"}, []string{"FAUT"})
+	addItems([]string{"laut"}, []string{"LAUT"})
+	addItems([]string{"title", "titl"}, []string{"TITL"})
+	addItems([]string{"journal", "jour"}, []string{"JOUR"})
+	addItems([]string{"volume", "vol"}, []string{"VOL"})
+	addItems([]string{"issue", "iss"}, []string{"ISS"})
+	addItems([]string{"pages", "page"}, []string{"PAGE"})
+	addItems([]string{"year"}, []string{"YEAR"})
+
+	arry = append(arry, "</CITATION>")
+
+	cit := strings.Join(arry, "")
+
+	return cit
+}
+
+// citationParams are the argument names that buildCitation reads
+var citationParams = []string{
+	"citation", "author", "auth", "faut", "laut", "title", "titl", "journal",
+	"jour", "volume", "vol", "issue", "iss", "pages", "page", "year",
+}
+
+// isCitationForm reports whether form arguments describe a single citation
+func isCitationForm(params url.Values) bool {
+
+	for _, name := range citationParams {
+		if params.Has(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BATCH CITATION MATCHING
+
+// maximum size of POST body for batch citation matching
+const maxMatchBody = 64 << 20
+
+// number of citations matched under one hold of the generation read lock
+const matchChunkSize = 100
+
+// matchStatus is one line of NDJSON output from batch citation matching
+type matchStatus struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	PMID   string `json:"pmid,omitempty"`
+	Note   string `json:"note,omitempty"`
+	Accn   string `json:"accn,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// jsonToCitation converts one JSON object, with the same names as /match query
+// parameters, into CITATION XML, a string value or array of strings per name
+func jsonToCitation(line string) (string, error) {
+
+	var obj map[string]any
+
+	err := json.Unmarshal([]byte(line), &obj)
+	if err != nil {
+		return "", err
+	}
+
+	params := make(map[string][]string)
+
+	for name, val := range obj {
+		name = strings.ToLower(name)
+		switch v := val.(type) {
+		case string:
+			params[name] = append(params[name], xmlEscaper.Replace(v))
+		case float64:
+			params[name] = append(params[name], strconv.FormatFloat(v, 'f', -1, 64))
+		case []any:
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return "", fmt.Errorf("array value of '%s' must contain strings", name)
+				}
+				params[name] = append(params[name], xmlEscaper.Replace(str))
+			}
+		default:
+			return "", fmt.Errorf("unexpected value type for '%s'", name)
+		}
+	}
+
+	// a "citation" member holds CITATION XML directly
+	ctn, ok := obj["citation"].(string)
+	if ok && ctn != "" {
+		return ctn, nil
+	}
+
+	if len(params) < 1 {
+		return "", errors.New("no citation fields")
+	}
+
+	return buildCitation(params), nil
+}
+
 func main() {
 
 	// skip past executable name
@@ -199,6 +440,21 @@ func main() {
 	numProcs := 0
 	serverRatio := 4
 
+	// seconds between checks of postings generation marker
+	reloadSecs := 30
+
+	// per-request search limits
+	timeoutSecs := 60
+	memoryMB := 0
+
+	// query result cache limits and persistence file
+	qcacheSize := 1000
+	qcacheTTL := 3600
+	qcacheFile := ""
+
+	// efetch-compatible source for records missing from the archive
+	fillURL := ""
+
 	// process any arguments on the command line
 	if len(args) > 0 {
 
@@ -217,6 +473,27 @@ func main() {
 			case "-port":
 				port = eutils.GetStringArg(args, "Port number")
 				args = args[1:]
+			case "-reload":
+				reloadSecs = eutils.GetNumericArg(args, "Generation poll interval", 0, 1, 3600)
+				args = args[1:]
+			case "-timeout":
+				timeoutSecs = eutils.GetNumericArg(args, "Search time limit", 0, 1, 3600)
+				args = args[1:]
+			case "-memory":
+				memoryMB = eutils.GetNumericArg(args, "Search memory limit", 0, 1, 65536)
+				args = args[1:]
+			case "-qsize":
+				qcacheSize = eutils.GetNumericArg(args, "Query cache size", 0, 1, 1000000)
+				args = args[1:]
+			case "-qttl":
+				qcacheTTL = eutils.GetNumericArg(args, "Query cache lifetime", 0, 1, 604800)
+				args = args[1:]
+			case "-qfile":
+				qcacheFile = eutils.GetStringArg(args, "Query cache file")
+				args = args[1:]
+			case "-fill":
+				fillURL = eutils.GetStringArg(args, "Efetch URL")
+				args = args[1:]
 
 			// concurrency arguments
 			case "-maxcpu":
@@ -324,6 +601,15 @@ func main() {
 	// create gin router with default middleware
 	r := gin.Default()
 
+	// POSTINGS GENERATION GATE
+
+	// searches, citation matches, and journal lookups hold a read lock, so a
+	// generation switch (which takes the write lock) waits for them to finish,
+	// fetching archived records does not depend on postings and is not blocked
+	var genLock sync.RWMutex
+
+	currentGen, currentWhen := eutils.ReadGeneration("pubmed")
+
 	// PRINT HELP TEXT
 
 	// nquire -get "localhost:8080/help"
@@ -368,26 +654,59 @@ func main() {
 
 	// STREAM COMPRESSED PUBMED ARTICLE SET WRAPPERS
 
-	// make gzip-compressed byte arrays of pmaSetHead and pmaSetTail
-	gzipHead := eutils.GzipString(pmaSetHead)
-	gzipTail := eutils.GzipString(pmaSetTail)
+	// make gzip- and zstd-compressed byte arrays of pmaSetHead and pmaSetTail
+	wrappers := map[string][2][]byte{
+		eutils.CodecGzip: {eutils.GzipString(pmaSetHead), eutils.GzipString(pmaSetTail)},
+		eutils.CodecZstd: {eutils.CompressString(archiveBase, eutils.CodecZstd, pmaSetHead), eutils.CompressString(archiveBase, eutils.CodecZstd, pmaSetTail)},
+	}
+
+	// streamCodec reads the optional codec argument, gzip by default
+	streamCodec := func(c *gin.Context) (string, bool) {
+
+		codec := c.Query("codec")
+		if codec == "" {
+			codec = c.PostForm("codec")
+		}
+		if codec == "" {
+			return eutils.CodecGzip, true
+		}
+		if codec != eutils.CodecGzip && codec != eutils.CodecZstd {
+			c.String(http.StatusBadRequest, "codec must be gzip or zstd\n")
+			return "", false
+		}
+
+		return codec, true
+	}
+
+	streamWrapper := func(c *gin.Context, which int) {
+
+		codec, ok := streamCodec(c)
+		if ok {
+			c.Data(http.StatusOK, streamContentType, wrappers[codec][which])
+		}
+	}
 
 	// nquire -get "localhost:8080/stream/head"
 	r.GET("/stream/head", func(c *gin.Context) {
-		c.Data(http.StatusOK, streamContentType, gzipHead)
+		streamWrapper(c, 0)
 	})
 	// nquire -url "localhost:8080/stream/head"
 	r.POST("/stream/head", func(c *gin.Context) {
-		c.Data(http.StatusOK, streamContentType, gzipHead)
+		streamWrapper(c, 0)
 	})
 
 	// nquire -get "localhost:8080/stream/tail"
 	r.GET("/stream/tail", func(c *gin.Context) {
-		c.Data(http.StatusOK, streamContentType, gzipTail)
+		streamWrapper(c, 1)
 	})
 	// nquire -url "localhost:8080/stream/tail"
 	r.POST("/stream/tail", func(c *gin.Context) {
-		c.Data(http.StatusOK, streamContentType, gzipTail)
+		streamWrapper(c, 1)
+	})
+
+	// nquire -get "localhost:8080/stream/dictionary" > pubmed.dict
+	r.GET("/stream/dictionary", func(c *gin.Context) {
+		c.File(filepath.Join(archiveBase, eutils.ZstdDictionary))
 	})
 
 	// PUBMED XML RECORD RETRIEVAL BY PMID
@@ -397,7 +716,7 @@ func main() {
 
 		// concurrent fetching by multiple goroutines
 		uidq := eutils.ReadsUIDsFromString(uids)
-		strq := eutils.CreateFetchers(archiveBase, "pubmed", "", ".xml", "PubmedArticle", true, uidq)
+		strq := eutils.CreateFetchers(c.Request.Context(), archiveBase, "pubmed", "", ".xml", "PubmedArticle", true, uidq)
 		unsq := eutils.CreateXMLUnshuffler(strq)
 
 		if uidq == nil || strq == nil || unsq == nil {
@@ -475,9 +794,14 @@ func main() {
 	// common stream function
 	pubmedStream := func(c *gin.Context, uids string) {
 
+		codec, ok := streamCodec(c)
+		if !ok {
+			return
+		}
+
 		// concurrent fetching by multiple goroutines
 		uidq := eutils.ReadsUIDsFromString(uids)
-		strq := eutils.CreateCacheStreamers(archiveBase, "pubmed", "", ".xml", 0, uidq)
+		strq := eutils.CreateCacheStreamers(c.Request.Context(), archiveBase, "pubmed", "", ".xml", 0, codec, uidq)
 		unsq := eutils.CreateXMLUnshuffler(strq)
 
 		if uidq == nil || strq == nil || unsq == nil {
@@ -522,10 +846,164 @@ func main() {
 
 	// PMID LOOKUP FROM PUBMED PHRASE AND INDEXED FIELD SEARCH
 
+	// apply per-request time and memory limits to query context
+	searchContext := func(c *gin.Context) (context.Context, context.CancelFunc) {
+
+		ctx := c.Request.Context()
+		cancel := func() {}
+		if timeoutSecs > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSecs)*time.Second)
+		}
+		ctx = eutils.WithMemoryBudget(ctx, int64(memoryMB)*1024*1024)
+
+		return ctx, cancel
+	}
+
+	// report search abandoned for exceeding a limit or for a malformed query
+	searchFailed := func(c *gin.Context, err error) {
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			c.String(http.StatusGatewayTimeout, "ERROR: "+err.Error()+"\n")
+		case errors.Is(err, eutils.ErrMemoryBudget):
+			// the query is well-formed, but needs more server memory than a search may use
+			c.String(http.StatusUnprocessableEntity, "ERROR: "+err.Error()+"\n")
+		case errors.As(err, new(*eutils.QueryError)):
+			// malformed term, e.g. an ORCID with the wrong number of digits, or bad syntax, e.g. "foo AND"
+			c.String(http.StatusBadRequest, "ERROR: "+err.Error()+"\n")
+		case errors.Is(err, context.Canceled):
+			// client disconnected, nobody to tell
+		default:
+			c.String(http.StatusInternalServerError, "ERROR: "+err.Error()+"\n")
+		}
+	}
+
+	// READ-THROUGH ARCHIVE FILL
+
+	if fillURL != "" {
+		eutils.SetArchiveFill("pubmed", &eutils.ArchiveFill{URL: fillURL, Codec: eutils.CodecGzip})
+	}
+
+	// QUERY RESULT CACHE
+
+	qcache := eutils.NewQueryCache(qcacheSize, 0, time.Duration(qcacheTTL)*time.Second, currentGen)
+
+	if qcache == nil {
+		eutils.DisplayError("Unable to create query result cache")
+		os.Exit(1)
+	}
+
+	if qcacheFile != "" {
+		err = eutils.LoadQueryCache(qcacheFile, qcache)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+	}
+
+	// HISTORY KEYS
+
+	// each search reports a history key for its result set in an X-History-Key
+	// header, which /extract accepts in place of an id list, the key is derived
+	// from the normalized query, so an evicted result is simply searched again
+	type historyEntry struct {
+		query string
+		lang  string
+	}
+
+	var histLock sync.Mutex
+	history := make(map[string]historyEntry)
+	maxHistory := qcacheSize * 10
+
+	rememberQuery := func(key, query, lang string) string {
+
+		hkey := historyKey(key)
+
+		histLock.Lock()
+		defer histLock.Unlock()
+
+		if _, ok := history[hkey]; !ok && len(history) >= maxHistory {
+			// drop an arbitrary entry to stay within limit
+			for old := range history {
+				delete(history, old)
+				break
+			}
+		}
+		history[hkey] = historyEntry{query: query, lang: lang}
+
+		return hkey
+	}
+
+	recallQuery := func(hkey string) (historyEntry, bool) {
+
+		histLock.Lock()
+		defer histLock.Unlock()
+
+		ent, ok := history[hkey]
+
+		return ent, ok
+	}
+
+	// optional language for vernacular title stop words and stemming, returns false if unrecognized
+	requestLang := func(c *gin.Context) (string, bool) {
+
+		lang := c.Query("lang")
+		if lang == "" {
+			lang = c.PostForm("lang")
+		}
+		if lang != "" && eutils.LanguageAnalyzer(lang) == nil {
+			c.String(http.StatusBadRequest, "ERROR: Unrecognized language '%s'\n", lang)
+			return "", false
+		}
+
+		return lang, true
+	}
+
+	// search through query result cache, returns false if search failed and error was reported
+	searchUIDs := func(c *gin.Context, query, lang string) ([]int32, bool) {
+
+		genLock.RLock()
+		defer genLock.RUnlock()
+
+		key, err := eutils.NormalizeQuery("pubmed", query, false, false, deStop, lang)
+		if err != nil {
+			searchFailed(c, err)
+			return nil, false
+		}
+
+		c.Header("X-History-Key", rememberQuery(key, query, lang))
+
+		uids, ok := qcache.Get(key)
+		if ok {
+			// records may have been deleted after the result was cached
+			return eutils.SubtractDeleted("pubmed", uids), true
+		}
+
+		ctx, cancel := searchContext(c)
+		defer cancel()
+
+		uids, err = eutils.ProcessQueryContext(ctx, "pubmed", query, false, false, false, deStop, lang)
+		if err != nil {
+			searchFailed(c, err)
+			return nil, false
+		}
+
+		qcache.Put(key, uids)
+
+		return uids, true
+	}
+
 	// common search function
 	pubmedSearch := func(c *gin.Context, query string) {
 
-		uids := eutils.ProcessQuery("pubmed", query, false, false, false, deStop)
+		lang, ok := requestLang(c)
+		if !ok {
+			return
+		}
+
+		uids, ok := searchUIDs(c, query, lang)
+		if !ok {
+			return
+		}
 
 		// use buffer to speed up uid printing
 		var buffer strings.Builder
@@ -544,6 +1022,7 @@ func main() {
 	}
 
 	// nquire -get "localhost:8080/search" -query "tn3 transposition immunity [TIAB] AND 1988:1993 [YEAR]"
+	// nquire -get "localhost:8080/search" -query "chirurgie de la main [VSTM]" -lang fre
 	r.GET("/search", func(c *gin.Context) {
 		query := c.Query("query")
 		pubmedSearch(c, query)
@@ -554,6 +1033,133 @@ func main() {
 		pubmedSearch(c, query)
 	})
 
+	// SERVER-SIDE XTRACT ON FETCHED RECORDS
+
+	// common extract function
+	pubmedExtract := func(c *gin.Context, uids, hkey, query string, xargs []string) {
+
+		if len(xargs) > maxExtractArgs {
+			c.String(http.StatusBadRequest, "ERROR: Too many xtract arguments\n")
+			return
+		}
+		for _, str := range xargs {
+			if len(str) > maxExtractArgLen {
+				c.String(http.StatusBadRequest, "ERROR: xtract argument is too long\n")
+				return
+			}
+		}
+
+		// check arguments before spending time on a search
+		cmds, parent, msg := checkExtractArgs(xargs)
+		if msg != "" {
+			c.String(http.StatusBadRequest, "ERROR: "+msg+"\n")
+			return
+		}
+
+		// records come from explicit identifiers, or from the (cached) results of
+		// an earlier search named by its history key, or of a new query
+		var uidq <-chan eutils.XMLRecord
+
+		if uids != "" {
+			if strings.Count(uids, ",") >= maxExtractIDs {
+				c.String(http.StatusBadRequest, "ERROR: More than %d records requested\n", maxExtractIDs)
+				return
+			}
+			uidq = eutils.ReadsUIDsFromString(uids)
+		} else if hkey != "" || query != "" {
+			lang := ""
+			if hkey != "" {
+				ent, ok := recallQuery(hkey)
+				if !ok {
+					c.String(http.StatusNotFound, "ERROR: Unknown history key '%s', repeat the search\n", hkey)
+					return
+				}
+				query = ent.query
+				lang = ent.lang
+			} else {
+				var ok bool
+				lang, ok = requestLang(c)
+				if !ok {
+					return
+				}
+			}
+			arry, ok := searchUIDs(c, query, lang)
+			if !ok {
+				return
+			}
+			if len(arry) > maxExtractIDs {
+				c.String(http.StatusBadRequest, "ERROR: More than %d records requested\n", maxExtractIDs)
+				return
+			}
+			uidq = eutils.ReadsUIDsFromArray(arry)
+		}
+		if uidq == nil {
+			return
+		}
+
+		ctx := c.Request.Context()
+		if timeoutSecs > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSecs)*time.Second)
+			defer cancel()
+		}
+
+		transform := make(map[string]string)
+		histogram := make(map[string]int)
+
+		// fetch records, run extraction commands concurrently, restore original order
+		strq := eutils.CreateFetchers(ctx, archiveBase, "pubmed", "", ".xml", "PubmedArticle", true, uidq)
+		tblq := eutils.CreateXMLConsumers(cmds, parent, "", "", transform, false, histogram, strq)
+		unsq := eutils.CreateXMLUnshuffler(tblq)
+
+		if uidq == nil || strq == nil || tblq == nil || unsq == nil {
+			eutils.DisplayError("Unable to create archive extractor")
+			os.Exit(1)
+		}
+
+		// drain output channel
+		for curr := range unsq {
+
+			str := curr.Text
+
+			if str == "" {
+				continue
+			}
+
+			c.String(http.StatusOK, str)
+		}
+
+		// print -histogram results, if populated
+		keys := slices.SortedFunc(maps.Keys(histogram), eutils.CompareAlphaOrNumericKeys)
+
+		for _, str := range keys {
+			c.String(http.StatusOK, strconv.Itoa(histogram[str])+"\t"+str+"\n")
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.String(http.StatusOK, "ERROR: Extraction exceeded time limit, results are incomplete\n")
+		}
+	}
+
+	// nquire -get "localhost:8080/extract" -id "2539356,1937004" \
+	//   -arg "-pattern" -arg "PubmedArticle" -arg "-element" -arg "MedlineCitation/PMID" -arg "ArticleTitle"
+	r.GET("/extract", func(c *gin.Context) {
+		uids := c.Query("id")
+		hkey := c.Query("key")
+		query := c.Query("query")
+		xargs := c.QueryArray("arg")
+		pubmedExtract(c, uids, hkey, query, xargs)
+	})
+	// nquire -url "localhost:8080/extract" -key "$key" \
+	//   -arg "-pattern" -arg "PubmedArticle" -arg "-element" -arg "MedlineCitation/PMID" -arg "ArticleTitle"
+	r.POST("/extract", func(c *gin.Context) {
+		uids := c.PostForm("id")
+		hkey := c.PostForm("key")
+		query := c.PostForm("query")
+		xargs := c.PostFormArray("arg")
+		pubmedExtract(c, uids, hkey, query, xargs)
+	})
+
 	// POPULATE JOURNAL TITLE LOOKUP MAP
 
 	jtaMap := make(map[string]string)
@@ -578,7 +1184,9 @@ func main() {
 			return
 		}
 
+		genLock.RLock()
 		eutils.PreloadCitCache(fileName, cache)
+		genLock.RUnlock()
 
 		c.String(http.StatusOK, "")
 	}
@@ -603,46 +1211,8 @@ func main() {
 			return
 		}
 
-		buildCitation := func(params map[string][]string) string {
-
-			var arry []string
-
-			arry = append(arry, "<CITATION>")
-
-			addItems := func(names []string, tags []string) {
-				for _, name := range names {
-					vals, ok := params[name]
-					if ok {
-						for n, tag := range tags {
-							if len(vals) > n {
-								// only keep first page
-								if tag == "PAGE" {
-									vals[n], _ = eutils.SplitInTwoLeft(vals[n], "-")
-								}
-								arry = append(arry, "<"+tag+">"+vals[n]+"</"+tag+">")
-							}
-						}
-					}
-				}
-			}
-
-			// allow flexibility in argument names
-			addItems([]string{"author", "auth"}, []string{"FAUT", "LAUT"})
-			addItems([]string{"faut"}, []string{"FAUT"})
-			addItems([]string{"laut"}, []string{"LAUT"})
-			addItems([]string{"title", "titl"}, []string{"TITL"})
-			addItems([]string{"journal", "jour"}, []string{"JOUR"})
-			addItems([]string{"volume", "vol"}, []string{"VOL"})
-			addItems([]string{"issue", "iss"}, []string{"ISS"})
-			addItems([]string{"pages", "page"}, []string{"PAGE"})
-			addItems([]string{"year"}, []string{"YEAR"})
-
-			arry = append(arry, "</CITATION>")
-
-			cit := strings.Join(arry, "")
-
-			return cit
-		}
+		genLock.RLock()
+		defer genLock.RUnlock()
 
 		cit := ""
 		isCitationXML := false
@@ -704,10 +1274,226 @@ func main() {
 		paramPairs := c.Request.URL.Query()
 		citMatch(c, paramPairs)
 	})
+	// batch match function, reads many citations from POST body, streams NDJSON results in input order
+	batchMatch := func(c *gin.Context, format string) {
+
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, maxMatchBody)
+		brd := bufio.NewReader(body)
+
+		// auto-detect format from first non-blank character
+		if format == "" {
+			format = "gbf"
+			for {
+				ch, _, err := brd.ReadRune()
+				if err != nil {
+					break
+				}
+				if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' {
+					continue
+				}
+				brd.UnreadRune()
+				if ch == '<' {
+					format = "xml"
+				} else if ch == '{' {
+					format = "json"
+				}
+				break
+			}
+		}
+
+		// JSON lines that cannot be parsed are reported in place, indexed by line
+		failed := make(map[int]string)
+		var valid []int
+		lines := 0
+
+		var rdr <-chan eutils.XMLBlock
+
+		switch format {
+		case "xml":
+			rdr = eutils.CreateXMLStreamer(brd, nil)
+		case "json":
+			var cits []string
+			scanr := bufio.NewScanner(brd)
+			scanr.Buffer(make([]byte, 65536), 1<<20)
+			idx := 0
+			for scanr.Scan() {
+				line := strings.TrimSpace(scanr.Text())
+				if line == "" {
+					continue
+				}
+				idx++
+				cit, err := jsonToCitation(line)
+				if err != nil {
+					failed[idx] = err.Error()
+					continue
+				}
+				cits = append(cits, cit)
+				valid = append(valid, idx)
+			}
+			if scanr.Err() != nil {
+				c.String(http.StatusBadRequest, "ERROR: %s\n", scanr.Err().Error())
+				return
+			}
+			lines = idx
+			if len(cits) > 0 {
+				rdr = eutils.CreateXMLStreamer(nil, eutils.StringToChan(strings.Join(cits, "\n")))
+			}
+		case "gbf", "embl":
+			gbq := eutils.GenBankRefIndex(brd, deStop, doStem)
+			if gbq == nil {
+				c.String(http.StatusBadRequest, "ERROR: Unrecognized citation format, expected CITATION XML, JSON lines, or GenBank or EMBL flatfile\n")
+				return
+			}
+			rdr = eutils.CreateXMLStreamer(nil, gbq)
+		default:
+			c.String(http.StatusBadRequest, "ERROR: Unrecognized -format '%s'\n", format)
+			return
+		}
+
+		send := func(res matchStatus) {
+			line, err := json.Marshal(res)
+			if err != nil {
+				return
+			}
+			c.Data(http.StatusOK, "application/x-ndjson", append(line, '\n'))
+			c.Writer.Flush()
+		}
+
+		// report unparseable JSON lines that precede the next matched citation
+		next := 1
+		sendFailures := func(upto int) {
+			for ; next < upto; next++ {
+				msg, ok := failed[next]
+				if ok {
+					send(matchStatus{Index: next, Status: "error", Error: msg})
+				}
+			}
+		}
+
+		// matchChunk matches one group of citations under the generation read lock, which is
+		// released before results are sent so a slow client cannot hold up an index switch
+		matchChunk := func(recs []eutils.XMLRecord) {
+
+			if len(recs) < 1 {
+				return
+			}
+
+			// unshuffler expects indices starting at 1
+			offset := recs[0].Index - 1
+
+			inq := make(chan eutils.XMLRecord, len(recs))
+			for _, rec := range recs {
+				rec.Index -= offset
+				inq <- rec
+			}
+			close(inq)
+
+			var results []matchStatus
+
+			genLock.RLock()
+
+			ctmq := eutils.CreateCitMatchers(inq, []string{"strict,remote,verify"}, deStop, doStem, cache, jtaMap)
+			unsq := eutils.CreateXMLUnshuffler(ctmq)
+
+			if ctmq == nil || unsq == nil {
+				eutils.DisplayError("Unable to create citation matcher")
+				os.Exit(1)
+			}
+
+			// drain output channel
+			for curr := range unsq {
+
+				// map position among valid JSON lines back to input line
+				idx := curr.Index + offset
+				if format == "json" && idx > 0 && idx <= len(valid) {
+					idx = valid[idx-1]
+				}
+
+				res := matchStatus{Index: idx}
+
+				eutils.StreamValues(curr.Text, "CITATION", func(tag, attr, content string) {
+					switch tag {
+					case "PMID":
+						res.PMID = content
+					case "NOTE":
+						res.Note = content
+					case "ACCN":
+						res.Accn = content
+					case "REF":
+						res.Ref = content
+					}
+				})
+
+				if res.PMID != "" {
+					res.Status = "matched"
+				} else {
+					res.Status = "unmatched"
+				}
+
+				results = append(results, res)
+			}
+
+			genLock.RUnlock()
+
+			for _, res := range results {
+				sendFailures(res.Index)
+				next = res.Index + 1
+				send(res)
+			}
+		}
+
+		if rdr != nil {
+
+			xmlq := eutils.CreateXMLProducer("CITATION", "", false, rdr)
+			if xmlq == nil {
+				eutils.DisplayError("Unable to create citation matcher")
+				os.Exit(1)
+			}
+
+			var recs []eutils.XMLRecord
+
+			for rec := range xmlq {
+				recs = append(recs, rec)
+				if len(recs) >= matchChunkSize {
+					matchChunk(recs)
+					recs = nil
+				}
+			}
+
+			matchChunk(recs)
+		}
+
+		sendFailures(lines + 1)
+	}
+
 	// nquire -url "localhost:8080/match" -author fst -author lst -title ttl -journal jta -year yr
 	r.POST("/match", func(c *gin.Context) {
-		c.MultipartForm()
-		citMatch(c, c.Request.PostForm)
+		// form arguments naming citation fields are a single citation, any other body is a batch
+		switch c.ContentType() {
+		case "multipart/form-data":
+			c.MultipartForm()
+			citMatch(c, c.Request.PostForm)
+		case "application/x-www-form-urlencoded":
+			// curl --data-binary also sends this type, so look at the body before choosing
+			data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxMatchBody))
+			if err != nil {
+				c.String(http.StatusBadRequest, "ERROR: %s\n", err.Error())
+				return
+			}
+			params, err := url.ParseQuery(string(data))
+			if err == nil && isCitationForm(params) {
+				citMatch(c, params)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(data))
+			batchMatch(c, c.Query("format"))
+		case "application/json", "application/x-ndjson", "application/jsonl":
+			batchMatch(c, "json")
+		case "application/xml", "text/xml":
+			batchMatch(c, "xml")
+		default:
+			batchMatch(c, c.Query("format"))
+		}
 	})
 
 	// JOURNAL LOOKUP FROM JOURNAL TO INDEX MAP
@@ -718,7 +1504,9 @@ func main() {
 		query = eutils.NormalizeJournal(query)
 		if query != "" {
 			query = strings.ToLower(query)
+			genLock.RLock()
 			jta, ok := jtaMap[query]
+			genLock.RUnlock()
 			if ok && jta != "" {
 				c.String(http.StatusOK, jta+"\n")
 			}
@@ -736,8 +1524,108 @@ func main() {
 		lookupJournal(c, query)
 	})
 
+	// REPORT POSTINGS GENERATION
+
+	// nquire -get "localhost:8080/generation"
+	r.GET("/generation", func(c *gin.Context) {
+		genLock.RLock()
+		defer genLock.RUnlock()
+		c.String(http.StatusOK, strconv.Itoa(currentGen)+"\t"+currentWhen+"\n")
+	})
+	// nquire -url "localhost:8080/generation"
+	r.POST("/generation", func(c *gin.Context) {
+		genLock.RLock()
+		defer genLock.RUnlock()
+		c.String(http.StatusOK, strconv.Itoa(currentGen)+"\t"+currentWhen+"\n")
+	})
+
+	// SWITCH TO NEW POSTINGS GENERATION
+
+	switchGeneration := func(gen int, when string) {
+
+		// blocks until all postings reads on the old generation have finished
+		genLock.Lock()
+		defer genLock.Unlock()
+
+		// reload journal map in place, since route closures hold a reference to it
+		clear(jtaMap)
+		eutils.TableToMap(jpath, jtaMap)
+
+		// MeSH alias tables are reread on next use
+		eutils.ResetQueryTables()
+
+		// drop search results from the old postings
+		qcache.Invalidate(gen)
+
+		// drop citation matches that may refer to the old postings
+		cache = eutils.NewCitCache(500)
+		if cache == nil {
+			eutils.DisplayError("Unable to create citation matcher cache")
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Switched from postings generation %d to %d\n", currentGen, gen)
+
+		currentGen = gen
+		currentWhen = when
+	}
+
+	if reloadSecs > 0 {
+
+		go func() {
+
+			ticker := time.NewTicker(time.Duration(reloadSecs) * time.Second)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				// only this goroutine changes currentGen, so it can be read without the lock
+				gen, when := eutils.ReadGeneration("pubmed")
+				if gen != currentGen || when != currentWhen {
+					switchGeneration(gen, when)
+				}
+			}
+		}()
+	}
+
 	// START LISTENING ON PORT
 
+	srv := &http.Server{
+		Addr:    host + ":" + port,
+		Handler: r,
+	}
+
 	// listen for requests
-	r.Run(host + ":" + port)
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			eutils.DisplayError("Unable to start server: %s", err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	// wait for termination signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	fmt.Fprintf(os.Stderr, "Shutting down server\n")
+
+	// stop accepting connections, allow requests in progress to complete
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		eutils.DisplayError("Server shutdown incomplete: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// save query results for next server instance
+	if qcacheFile != "" {
+		err = eutils.SaveQueryCache(qcacheFile, qcache)
+		if err != nil {
+			eutils.DisplayError("Unable to save query cache: %s", err.Error())
+			os.Exit(1)
+		}
+	}
 }
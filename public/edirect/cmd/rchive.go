@@ -33,13 +33,17 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"eutils"
 	"fmt"
 	"github.com/klauspost/pgzip"
 	"hash/crc32"
 	"html"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"maps"
 	"os"
 	"os/user"
@@ -50,6 +54,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -80,6 +85,18 @@ func main() {
 		return
 	}
 
+	// drop identifiers that rebuilt postings leave out from the deleted set
+	if args[0] == "-fold" && len(args) > 1 {
+		db := args[1]
+		count, err := eutils.ClearDeletedSet(db)
+		if err != nil {
+			eutils.DisplayError("Unable to clear deleted set: %s", err.Error())
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Folded %d deleted records into postings\n", count)
+		return
+	}
+
 	origArgs := args
 
 	// performance arguments
@@ -169,9 +186,19 @@ func main() {
 	// file with indexing argument lines
 	idxargs := ""
 
+	// declarative index schema, alternative to idxargs
+	schm := ""
+	var schema *eutils.IndexSchema
+
 	// rolling count limit for printing progress dot
 	dotmax := 0
 
+	// merge open file limit, memory budget in megabytes, spill folder, and field restriction
+	maxOpen := 0
+	mergeMem := 0
+	spill := ""
+	mergeFlds := ""
+
 	// path for local data indexed as trie
 	stsh := ""
 	dlet := ""
@@ -205,6 +232,10 @@ func main() {
 	// fields for promoting inverted index files
 	fild := ""
 
+	// stage promoted files until -advance publishes them as one postings generation
+	stge := false
+	advn := false
+
 	// base for queries
 	base := ""
 
@@ -216,6 +247,9 @@ func main() {
 	mock := false
 	btch := false
 
+	// language for vernacular field stop words and stemming
+	lang := ""
+
 	// print term list with counts
 	trms := ""
 	plrl := false
@@ -227,6 +261,15 @@ func main() {
 	// use gzip compression on local data files
 	zipp := false
 
+	// use zstd compression instead of gzip for stashed or streamed records
+	zstd := false
+
+	// train zstd dictionary for archive from sample records
+	dctn := ""
+
+	// storage backend for new archive (trie or packed)
+	strg := ""
+
 	// create Pubmed-entry ASN.1 file from PubmedArticle XML
 	pma2pme := false
 
@@ -255,9 +298,42 @@ func main() {
 	// flag missing identifiers
 	msng := false
 
+	// keep prior revisions when stashing, with name of update file
+	vrsn := false
+	srce := ""
+
+	// select archived revision by number or arrival date
+	rvsn := 0
+	asof := ""
+	rvto := 0
+
+	// report or compare archived revisions
+	hstr := ""
+	diff := ""
+
+	// verify archive checksums, optionally restoring damaged records from -input
+	scrb := ""
+	rpair := false
+
+	// retrieve missing -fetch records from efetch-compatible server
+	fill := ""
+	fsiz := 0
+	frat := 0
+
+	// ingestion ledger of source file checksums and completed pipeline stages
+	ldgr := ""
+
+	// move archive between machines as sharded bundles
+	xprt := ""
+	mprt := ""
+	bndl := ""
+	xrng := ""
+	xsiz := 1024
+
 	// flag records with damaged embedded HTML tags
 	dmgd := false
 	dmgdType := ""
+	sctn := false
 
 	// kludge to use non-threaded fetching for windows
 	windows := false
@@ -353,10 +429,16 @@ func main() {
 		case "-idxargs":
 			idxargs = eutils.GetStringArg(args, "File with local archive indexing argument lines")
 			args = args[1:]
+		case "-schema":
+			schm = eutils.GetStringArg(args, "Index schema file")
+			args = args[1:]
 
 		case "-dotmax":
 			dotmax = eutils.GetNumericArg(args, "Progress dot printing frequency", 0, 0, 10000)
 			args = args[1:]
+		case "-progress":
+			// JSON lines on stderr instead of progress dots
+			eutils.SetProgressEvents(true)
 
 		// local directory path for indexing
 		case "-archive", "-stash":
@@ -448,6 +530,18 @@ func main() {
 		case "-merge":
 			merg = eutils.GetStringArg(args, "Merge field")
 			args = args[1:]
+		case "-maxopen":
+			maxOpen = eutils.GetNumericArg(args, "Maximum open files for -merge", 0, 2, 100000)
+			args = args[1:]
+		case "-mergemem":
+			mergeMem = eutils.GetNumericArg(args, "Memory budget in megabytes for -merge", 0, 0, 1000000)
+			args = args[1:]
+		case "-spill":
+			spill = eutils.GetStringArg(args, "Spill folder for -merge")
+			args = args[1:]
+		case "-mergeonly":
+			mergeFlds = eutils.GetStringArg(args, "Fields for -merge")
+			args = args[1:]
 
 		case "-promotelink":
 			isLink = true
@@ -463,6 +557,11 @@ func main() {
 			// skip past first and second arguments
 			args = args[2:]
 
+		case "-stage":
+			stge = true
+		case "-advance":
+			advn = true
+
 		case "-path":
 			base = eutils.GetStringArg(args, "Postings path")
 			args = args[1:]
@@ -490,6 +589,14 @@ func main() {
 		case "-batch":
 			btch = true
 
+		case "-lang", "-language":
+			lang = eutils.GetStringArg(args, "Query language")
+			if eutils.LanguageAnalyzer(lang) == nil {
+				eutils.DisplayError("Unrecognized -lang '%s', use eng, fre, ger, spa, por, or ita", lang)
+				os.Exit(1)
+			}
+			args = args[1:]
+
 		case "-mockt":
 			titl = true
 			fallthrough
@@ -514,6 +621,15 @@ func main() {
 
 		case "-gzip":
 			zipp = true
+		case "-zstd":
+			zstd = true
+			zipp = true
+		case "-dictionary":
+			dctn = eutils.GetStringArg(args, "Dictionary archive path")
+			args = args[1:]
+		case "-storage":
+			strg = eutils.GetStringArg(args, "Archive storage type")
+			args = args[1:]
 		case "-asn":
 			pma2pme = true
 		case "-xml":
@@ -549,6 +665,75 @@ func main() {
 		case "-missing":
 			msng = true
 
+		// record version history
+		case "-versioned":
+			vrsn = true
+		case "-source":
+			srce = eutils.GetStringArg(args, "Update source name")
+			args = args[1:]
+		case "-version":
+			rvsn = eutils.GetNumericArg(args, "Record version", 0, 1, 100000)
+			args = args[1:]
+		case "-as-of":
+			asof = eutils.GetStringArg(args, "Archive date")
+			args = args[1:]
+		case "-against":
+			rvto = eutils.GetNumericArg(args, "Record version", 0, 1, 100000)
+			args = args[1:]
+		case "-history":
+			hstr = eutils.GetStringArg(args, "History path")
+			args = args[1:]
+		case "-diff":
+			diff = eutils.GetStringArg(args, "Diff path")
+			args = args[1:]
+
+		// archive integrity check
+		case "-scrub":
+			scrb = eutils.GetStringArg(args, "Scrub path")
+			if scrb != "" && !strings.HasSuffix(scrb, "/") {
+				scrb += "/"
+			}
+			args = args[1:]
+		case "-repair":
+			rpair = true
+
+		// read-through fill of missing records
+		case "-fill":
+			fill = eutils.GetStringArg(args, "Efetch URL")
+			args = args[1:]
+		case "-fillsize":
+			fsiz = eutils.GetNumericArg(args, "Identifiers per fill request", 200, 1, 10000)
+			args = args[1:]
+		case "-fillrate":
+			frat = eutils.GetNumericArg(args, "Fill requests per second", 3, 1, 100)
+			args = args[1:]
+
+		// ingestion ledger, followed by status, record, advance, reset, or pending
+		case "-ledger":
+			ldgr = eutils.GetStringArg(args, "Ledger archive path")
+			args = args[1:]
+
+		// archive snapshot bundles
+		case "-export", "-import":
+			if len(args) < 3 {
+				eutils.DisplayError("Archive and bundle paths needed")
+				os.Exit(1)
+			}
+			if args[0] == "-export" {
+				xprt = eutils.GetStringArg(args, "Archive path")
+			} else {
+				mprt = eutils.GetStringArg(args, "Archive path")
+			}
+			args = args[1:]
+			bndl = eutils.GetStringArg(args, "Bundle path")
+			args = args[1:]
+		case "-range":
+			xrng = eutils.GetStringArg(args, "Identifier range")
+			args = args[1:]
+		case "-size":
+			xsiz = eutils.GetNumericArg(args, "Bundle size in megabytes", 0, 1, 1000000)
+			args = args[1:]
+
 		// use non-threaded fetch function for windows (undocumented)
 		case "-windows":
 			windows = true
@@ -605,6 +790,9 @@ func main() {
 					args = args[1:]
 				}
 			}
+		// structured abstract section positions
+		case "-sections":
+			sctn = true
 		case "-prepare":
 			cmpr = true
 			if len(args) > 1 {
@@ -717,9 +905,15 @@ func main() {
 		args = append(args, "-dummy")
 	} else if ftch != "" || strm != "" || smmn != "" {
 		args = append(args, "-dummy")
+	} else if hstr != "" || diff != "" {
+		args = append(args, "-dummy")
+	} else if scrb != "" && !rpair {
+		args = append(args, "-dummy")
+	} else if xprt != "" || mprt != "" {
+		args = append(args, "-dummy")
 	} else if base != "" {
 		args = append(args, "-dummy")
-	} else if trei || padz || dmgd || cmpr {
+	} else if trei || padz || dmgd || sctn || cmpr {
 		args = append(args, "-dummy")
 	}
 
@@ -789,6 +983,22 @@ func main() {
 		}
 	}
 
+	// read and validate index schema before it is used by -e2index or -promote
+	if schm != "" {
+
+		if idxargs != "" {
+			eutils.DisplayError("-schema and -idxargs cannot be used together")
+			os.Exit(1)
+		}
+
+		var err error
+		schema, err = eutils.ReadIndexSchema(schm)
+		if err != nil {
+			eutils.DisplayError("Unable to read index schema: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// expand -promote ~/ to home directory path
 	if prom != "" {
 
@@ -813,6 +1023,23 @@ func main() {
 		}
 	}
 
+	// SELECT ARCHIVE STORAGE BACKEND
+
+	// -storage records backend in archive STORAGE file, must precede first use of archive
+	if strg != "" {
+
+		if stsh == "" {
+			eutils.DisplayError("-storage requires -archive")
+			os.Exit(1)
+		}
+
+		err := eutils.InitArchiveStore(stsh, strg)
+		if err != nil {
+			eutils.DisplayError("%s", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// DOCUMENTATION COMMANDS
 
 	if len(args) > 0 {
@@ -1096,6 +1323,10 @@ func main() {
 
 		pfx := ""
 
+		if recname == "" && schema != nil {
+			recname = schema.Pattern
+		}
+
 		if recname == "" {
 			if db == "pubmed" {
 				recname = "PubmedArticle"
@@ -1108,6 +1339,9 @@ func main() {
 		}
 
 		res := eutils.MakeE2Commands(tform, idxargs)
+		if schema != nil {
+			res = eutils.CompileIndexSchema(schema)
+		}
 
 		// data in pipe, so replace arguments, execute dynamically
 		args = res
@@ -1282,6 +1516,10 @@ func main() {
 			}
 		}
 
+		if recname == "" && schema != nil {
+			recname = schema.Pattern
+		}
+
 		if recname == "" {
 			if db == "pubmed" {
 				recname = "PubmedArticle"
@@ -1293,6 +1531,9 @@ func main() {
 		}
 
 		res := eutils.MakeE2Commands(tform, idxargs)
+		if schema != nil {
+			res = eutils.CompileIndexSchema(schema)
+		}
 
 		if !isPipe && !usingFile {
 			// no piped input, so write output instructions
@@ -1540,11 +1781,17 @@ func main() {
 			}
 		}
 
-		chns := eutils.CreatePresenters(args)
+		// join groups of files into temporary spill files if there are too many to open at once
+		eutils.SetMergeLimits(maxOpen, int64(mergeMem)*1024*1024)
+		eutils.SetMergeFields(mergeFlds)
+		files, cleanup := eutils.SpillMergeInputs(args, db, spill)
+		defer cleanup()
+
+		chns := eutils.CreatePresenters(files)
 		mfld := eutils.CreateManifold(chns)
 		mrgr := eutils.CreateMergers(mfld)
 		unsq := eutils.CreateXMLUnshuffler(mrgr)
-		sptr := eutils.CreateSplitter(merg, db, zipp, isLink, unsq)
+		sptr := eutils.CreateSplitter(merg, db, zipp, isLink, args, unsq)
 
 		if chns == nil || mfld == nil || mrgr == nil || unsq == nil || sptr == nil {
 			eutils.DisplayError("Unable to create inverted index merger")
@@ -1608,11 +1855,125 @@ func main() {
 		return
 	}
 
+	// INGESTION LEDGER
+
+	// -ledger records source file checksums and stage completion for resumable updates
+	if ldgr != "" {
+
+		if len(args) < 1 {
+			eutils.DisplayError("-ledger requires status, record, advance, reset, or pending")
+			os.Exit(1)
+		}
+
+		verb := args[0]
+		args = args[1:]
+
+		stage := ""
+		if verb != "status" {
+			if len(args) < 1 {
+				eutils.DisplayError("-ledger %s requires stage name", verb)
+				os.Exit(1)
+			}
+			stage = args[0]
+			args = args[1:]
+		}
+
+		var err error
+
+		switch verb {
+		case "status":
+			// summary by stage, then files still moving through the pipeline
+			var ents []eutils.LedgerEntry
+			ents, err = eutils.ReadLedger(ldgr)
+			if err != nil {
+				break
+			}
+			counts := make(map[string]int)
+			for _, ent := range ents {
+				counts[ent.Stage]++
+			}
+			for _, stg := range eutils.IngestStages {
+				fmt.Fprintf(os.Stdout, "%s\t%d\n", stg, counts[stg])
+			}
+			last := eutils.IngestStages[len(eutils.IngestStages)-1]
+			for _, ent := range ents {
+				if ent.Stage != last {
+					fmt.Fprintf(os.Stdout, "%s\t%s\t%s\n", ent.File, ent.Stage, ent.Time.Local().Format("2006-01-02 15:04:05"))
+				}
+			}
+			recordCount = len(ents)
+		case "record":
+			for _, fl := range args {
+				changed := false
+				changed, err = eutils.RecordIngest(ldgr, fl, stage)
+				if err != nil {
+					break
+				}
+				if changed {
+					fmt.Fprintf(os.Stderr, "Contents of %s changed since last recorded\n", filepath.Base(fl))
+				}
+				recordCount++
+			}
+		case "advance":
+			recordCount, err = eutils.AdvanceLedger(ldgr, stage)
+		case "reset":
+			recordCount, err = eutils.ResetLedger(ldgr, stage)
+		case "pending":
+			// exit status tells script whether stage has work to do
+			var files []string
+			files, err = eutils.PendingFiles(ldgr, stage)
+			if err != nil {
+				break
+			}
+			for _, fl := range files {
+				fmt.Fprintf(os.Stdout, "%s\n", fl)
+			}
+			if len(files) < 1 {
+				os.Exit(1)
+			}
+		default:
+			eutils.DisplayError("Unrecognized -ledger command '%s'", verb)
+			os.Exit(1)
+		}
+
+		if err != nil {
+			eutils.DisplayError("%s", err.Error())
+			os.Exit(1)
+		}
+
+		if timr {
+			printDuration("files")
+		}
+
+		return
+	}
+
+	// PUBLISH STAGED POSTINGS FILES AS NEW GENERATION
+
+	if advn {
+
+		if db == "" {
+			eutils.DisplayError("-advance requires -db")
+			os.Exit(1)
+		}
+
+		// rename staged files into place, then advance the marker that running servers poll
+		count, gen, err := eutils.PublishPostings(db)
+		if err != nil {
+			eutils.DisplayError("Unable to publish postings: %s", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Published %d postings files as generation %d\n", count, gen)
+
+		return
+	}
+
 	// PROMOTE MERGED INVERTED INDEX TO TERM LIST AND POSTINGS FILES
 
 	if prom != "" && fild != "" {
 
-		prmq := eutils.CreatePromoters(prom, db, fild, isLink, args)
+		prmq := eutils.CreatePromoters(prom, db, fild, isLink, stge, args)
 
 		if prmq == nil {
 			eutils.DisplayError("Unable to create new postings file generator")
@@ -1644,6 +2005,13 @@ func main() {
 			fmt.Fprintf(os.Stdout, "\n")
 		}
 
+		// record schema with postings, so queries treat fields the way they were indexed
+		err := eutils.SaveIndexSchema(schema, prom)
+		if err != nil {
+			eutils.DisplayError("Unable to save index schema: %s", err.Error())
+			os.Exit(1)
+		}
+
 		debug.FreeOSMemory()
 
 		if timr {
@@ -1664,7 +2032,7 @@ func main() {
 			txt := scanr.Text()
 
 			// deStop should match value used in building the indices
-			recordCount += eutils.ProcessSearch(db, txt, true, false, false, deStop)
+			recordCount += eutils.ProcessSearch(db, txt, true, false, false, deStop, lang)
 		}
 
 		debug.FreeOSMemory()
@@ -1680,11 +2048,11 @@ func main() {
 
 		// deStop should match value used in building the indices
 		if mock {
-			recordCount = eutils.ProcessMock(db, phrs, xact, titl, deStop)
+			recordCount = eutils.ProcessMock(db, phrs, xact, titl, deStop, lang)
 		} else if mtch {
 			eutils.ProcessMatch(db, phrs, deStop)
 		} else {
-			recordCount = eutils.ProcessSearch(db, phrs, xact, titl, false, deStop)
+			recordCount = eutils.ProcessSearch(db, phrs, xact, titl, false, deStop, lang)
 		}
 
 		debug.FreeOSMemory()
@@ -1712,7 +2080,7 @@ func main() {
 	if trms != "" {
 
 		// deStop should match value used in building the indices
-		recordCount = eutils.ProcessCount(db, trms, plrl, psns, deStop)
+		recordCount = eutils.ProcessCount(db, trms, plrl, psns, deStop, lang)
 
 		debug.FreeOSMemory()
 
@@ -1723,6 +2091,175 @@ func main() {
 		return
 	}
 
+	// CHECK ARCHIVE INTEGRITY AGAINST CHECKSUM MANIFESTS
+
+	// scrubArchive writes a JSON lines report of damaged, unlisted, missing, and orphaned
+	// files, followed by a summary, and with -repair adds unlisted records to the manifest
+	// and passes damaged records to the restore function, which returns those it replaced
+	scrubArchive := func(restore func(damaged map[string]string) map[string]bool) {
+
+		issq, count := eutils.CreateScrubbers(scrb)
+		if issq == nil {
+			eutils.DisplayError("Unable to create archive scrubber")
+			os.Exit(1)
+		}
+
+		type scrubLine struct {
+			eutils.ScrubIssue
+			Repaired bool `json:"repaired,omitempty"`
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+
+		problems := make(map[string]int)
+		repaired := 0
+
+		var held []eutils.ScrubIssue
+		// damaged record identifier to its key, which gives the codec to restore with
+		damaged := make(map[string]string)
+
+		for issue := range issq {
+
+			problems[issue.Problem]++
+
+			switch issue.Problem {
+			case eutils.ScrubUnlisted:
+				// gzip contents were verified, so accept record as is
+				if rpair && eutils.RefreshChecksum(scrb, issue.Key) == nil {
+					enc.Encode(scrubLine{issue, true})
+					repaired++
+					continue
+				}
+			case eutils.ScrubTruncated, eutils.ScrubMismatch, eutils.ScrubMissing, eutils.ScrubUnreadable:
+				if rpair && issue.ID != "" {
+					// report after attempting to replace from source
+					held = append(held, issue)
+					damaged[issue.ID] = issue.Key
+					continue
+				}
+			}
+
+			enc.Encode(scrubLine{issue, false})
+		}
+
+		fixed := make(map[string]bool)
+		if len(damaged) > 0 && restore != nil {
+			fixed = restore(damaged)
+		}
+
+		for _, issue := range held {
+			// only count a replaced record that now passes the same checks
+			ok := fixed[issue.ID] && eutils.VerifyRecord(scrb, issue.Key) == nil
+			if ok {
+				repaired++
+			}
+			enc.Encode(scrubLine{issue, ok})
+		}
+
+		var summary struct {
+			Summary struct {
+				Records  int64          `json:"records"`
+				Problems map[string]int `json:"problems"`
+				Repaired int            `json:"repaired"`
+			} `json:"summary"`
+		}
+
+		summary.Summary.Records = count.Load()
+		summary.Summary.Problems = problems
+		summary.Summary.Repaired = repaired
+
+		enc.Encode(summary)
+
+		recordCount = int(count.Load())
+	}
+
+	// -scrub without -repair needs no input
+	if scrb != "" && !rpair {
+
+		scrubArchive(nil)
+
+		if timr {
+			printDuration("records")
+		}
+
+		return
+	}
+
+	// EXPORT OR IMPORT ARCHIVE SNAPSHOT BUNDLES
+
+	// -export writes all records, a -range of PMIDs, or identifiers piped to stdin
+	if xprt != "" {
+
+		if !strings.HasSuffix(xprt, "/") {
+			xprt += "/"
+		}
+
+		lo, hi := 0, 0
+		if xrng != "" {
+			first, last, _ := strings.Cut(xrng, "-")
+			var err error
+			lo, err = strconv.Atoi(first)
+			if err == nil && last != "" {
+				hi, err = strconv.Atoi(last)
+			}
+			if err != nil || lo < 1 || (hi > 0 && hi < lo) {
+				eutils.DisplayError("Improper -range '%s', expected LOW-HIGH", xrng)
+				os.Exit(1)
+			}
+		}
+
+		var uidq <-chan eutils.XMLRecord
+		if usingFile || isPipe {
+			uidq = eutils.CreateUIDReader(in)
+		}
+
+		keyq := eutils.ExportKeys(xprt, uidq, lo, hi)
+
+		manifest, err := eutils.ExportArchive(xprt, bndl, keyq, int64(xsiz)*1024*1024)
+		if err != nil {
+			eutils.DisplayError("%s", err.Error())
+			os.Exit(1)
+		}
+
+		recordCount = manifest.Records
+
+		fmt.Fprintf(os.Stderr, "Exported %d records to %d bundles\n", manifest.Records, len(manifest.Bundles))
+
+		if timr {
+			printDuration("records")
+		}
+
+		return
+	}
+
+	// -import verifies bundle checksums and keeps local records unless incoming version is newer
+	if mprt != "" {
+
+		if !strings.HasSuffix(mprt, "/") {
+			mprt += "/"
+		}
+
+		stats, err := eutils.ImportArchive(mprt, bndl)
+		if err != nil {
+			eutils.DisplayError("%s", err.Error())
+			os.Exit(1)
+		}
+
+		recordCount = stats.Added + stats.Replaced + stats.Skipped + stats.Damaged
+
+		fmt.Fprintf(os.Stderr, "Added %d, replaced %d, kept %d, damaged %d\n", stats.Added, stats.Replaced, stats.Skipped, stats.Damaged)
+
+		if timr {
+			printDuration("records")
+		}
+
+		if stats.Damaged > 0 {
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	// CONFIRM INPUT DATA AVAILABILITY AFTER RUNNING COMMAND GENERATORS
 
 	if fileName == "" && runtime.GOOS != "windows" {
@@ -1842,6 +2379,8 @@ func main() {
 
 		scanr := bufio.NewScanner(in)
 
+		store := eutils.OpenArchiveStore(stsh)
+
 		sfx := ".xml"
 		if zipp {
 			sfx += ".gz"
@@ -1858,29 +2397,22 @@ func main() {
 				id = id[:pos]
 			}
 
-			dir, file := eutils.ArchiveTrie(id)
-
-			if dir == "" || file == "" {
-				continue
-			}
-
-			fpath := filepath.Join(stsh, dir, file+sfx)
-			if fpath == "" {
+			key := eutils.ArchiveKey(id, "", sfx)
+			if key == "" {
 				continue
 			}
 
-			_, err := os.Stat(fpath)
+			found := store.Has(key)
 
-			// if failed to find ".xml" file, try ".xml.gz" without requiring -gzip
-			if err != nil && os.IsNotExist(err) && !zipp {
-				fpath := filepath.Join(stsh, dir, file+".xml.gz")
-				if fpath == "" {
-					continue
+			// if failed to find ".xml" or ".xml.gz" file, try any other compression
+			for _, ext := range []string{".xml.gz", ".xml.zst", ".xml"} {
+				if !found {
+					found = store.Has(eutils.ArchiveKey(id, "", ext))
 				}
-				_, err = os.Stat(fpath)
 			}
-			if err != nil && os.IsNotExist(err) {
+			if !found {
 				// record is missing from local file cache
+				_, file := eutils.ArchiveTrie(id)
 				os.Stdout.WriteString(file)
 				os.Stdout.WriteString("\n")
 			}
@@ -1889,18 +2421,196 @@ func main() {
 		return
 	}
 
-	// RETRIEVE XML COMPONENT RECORDS FROM LOCAL DIRECTORY INDEXED BY TRIE ON IDENTIFIER
+	// REPORT OR COMPARE ARCHIVED VERSIONS OF RECORDS
 
-	// alternative windows version limits memory by not using goroutines
-	if ftch != "" && indx == "" && runtime.GOOS == "windows" && windows {
+	// archive -versioned keeps prior revisions of updated records
+	pickDate := func() time.Time {
 
-		scanr := bufio.NewScanner(in)
-		if scanr == nil {
-			eutils.DisplayError("Unable to create UID scanner")
-			os.Exit(1)
+		if asof == "" {
+			return time.Time{}
+		}
+
+		when, err := eutils.ParseArchiveDate(asof)
+		if err != nil {
+			eutils.DisplayError("Unrecognized -as-of date '%s'", asof)
+			os.Exit(1)
+		}
+
+		return when
+	}
+
+	revisionPrefix := func() (string, string, string) {
+
+		pfx := ""
+		sfx := ".xml"
+		ptrn := "PubmedArticle"
+
+		if db == "pmc" {
+			pfx = "PMC"
+			ptrn = "PMCInfo"
+		} else if db == "taxonomy" {
+			ptrn = "TaxonInfo"
 		}
 
-		sfx := ".xml"
+		if pma2pme {
+			sfx = ".asn"
+			ptrn = ""
+		}
+
+		if recname != "" {
+			ptrn = recname
+		}
+
+		return pfx, sfx, ptrn
+	}
+
+	formatRevision := func(rev eutils.ArchiveRevision) string {
+
+		when := "-"
+		if !rev.Arrived.IsZero() {
+			when = rev.Arrived.UTC().Format(time.RFC3339)
+		}
+
+		src := rev.Source
+		if src == "" {
+			src = "-"
+		}
+
+		return "v" + strconv.Itoa(rev.Version) + "\t" + when + "\t" + src
+	}
+
+	// -history prints the version, arrival date, and source file of each revision
+	if hstr != "" {
+
+		pfx, sfx, _ := revisionPrefix()
+
+		scanr := bufio.NewScanner(in)
+
+		for scanr.Scan() {
+
+			id := scanr.Text()
+
+			for _, rev := range eutils.ArchiveHistory(hstr, id, pfx, sfx) {
+				os.Stdout.WriteString(id + "\t" + formatRevision(rev) + "\n")
+			}
+		}
+
+		return
+	}
+
+	// -diff compares -version or -as-of (default previous) against -against (default current)
+	if diff != "" {
+
+		pfx, sfx, ptrn := revisionPrefix()
+		when := pickDate()
+
+		scanr := bufio.NewScanner(in)
+
+		for scanr.Scan() {
+
+			id := scanr.Text()
+
+			revs := eutils.ArchiveHistory(diff, id, pfx, sfx)
+			if len(revs) < 1 {
+				continue
+			}
+
+			newer, ok := eutils.SelectRevision(revs, rvto, time.Time{})
+			if !ok {
+				continue
+			}
+
+			var older eutils.ArchiveRevision
+			if rvsn > 0 || !when.IsZero() {
+				older, ok = eutils.SelectRevision(revs, rvsn, when)
+			} else {
+				older, ok = eutils.SelectRevision(revs, newer.Version-1, time.Time{})
+			}
+			if !ok {
+				continue
+			}
+
+			prev := eutils.FetchArchiveRevision(diff, id, pfx, sfx, ptrn, older.Version)
+			curr := eutils.FetchArchiveRevision(diff, id, pfx, sfx, ptrn, newer.Version)
+
+			txt := eutils.DiffRevisions(prev, curr)
+			if txt == "" {
+				continue
+			}
+
+			os.Stdout.WriteString("--- " + id + "\t" + formatRevision(older) + "\n")
+			os.Stdout.WriteString("+++ " + id + "\t" + formatRevision(newer) + "\n")
+			os.Stdout.WriteString(txt)
+		}
+
+		return
+	}
+
+	// -fetch plus -version or -as-of retrieves earlier revisions of records
+	if ftch != "" && indx == "" && (rvsn > 0 || asof != "") {
+
+		pfx, sfx, ptrn := revisionPrefix()
+		when := pickDate()
+
+		if head != "" {
+			os.Stdout.WriteString(head)
+			os.Stdout.WriteString("\n")
+		}
+
+		scanr := bufio.NewScanner(in)
+
+		for scanr.Scan() {
+
+			id := scanr.Text()
+
+			rev, ok := eutils.SelectRevision(eutils.ArchiveHistory(ftch, id, pfx, sfx), rvsn, when)
+			if !ok {
+				continue
+			}
+
+			str := eutils.FetchArchiveRevision(ftch, id, pfx, sfx, ptrn, rev.Version)
+			if str == "" {
+				continue
+			}
+
+			if hd != "" {
+				os.Stdout.WriteString(hd)
+				os.Stdout.WriteString("\n")
+			}
+
+			os.Stdout.WriteString(str)
+			if !strings.HasSuffix(str, "\n") {
+				os.Stdout.WriteString("\n")
+			}
+
+			if tl != "" {
+				os.Stdout.WriteString(tl)
+				os.Stdout.WriteString("\n")
+			}
+
+			recordCount++
+		}
+
+		if tail != "" {
+			os.Stdout.WriteString(tail)
+			os.Stdout.WriteString("\n")
+		}
+
+		return
+	}
+
+	// RETRIEVE XML COMPONENT RECORDS FROM LOCAL DIRECTORY INDEXED BY TRIE ON IDENTIFIER
+
+	// alternative windows version limits memory by not using goroutines
+	if ftch != "" && indx == "" && runtime.GOOS == "windows" && windows {
+
+		scanr := bufio.NewScanner(in)
+		if scanr == nil {
+			eutils.DisplayError("Unable to create UID scanner")
+			os.Exit(1)
+		}
+
+		sfx := ".xml"
 		if pma2pme {
 			sfx = ".asn"
 		}
@@ -1913,6 +2623,8 @@ func main() {
 			os.Stdout.WriteString("\n")
 		}
 
+		store := eutils.OpenArchiveStore(ftch)
+
 		var buf bytes.Buffer
 
 		for scanr.Scan() {
@@ -1926,29 +2638,20 @@ func main() {
 				id = id[:pos]
 			}
 
-			dir, file := eutils.ArchiveTrie(id)
+			_, file := eutils.ArchiveTrie(id)
 
-			if dir == "" || file == "" {
+			key := eutils.ArchiveKey(id, "", sfx)
+			if key == "" {
 				continue
 			}
 
-			fpath := filepath.Join(ftch, dir, file+sfx)
-			if fpath == "" {
-				continue
-			}
-
-			iszip := zipp
-
-			inFile, err := os.Open(fpath)
+			data, err := store.Get(key)
 
-			// if failed to find ".xml" file, try ".xml.gz" without requiring -gzip
-			if err != nil && os.IsNotExist(err) && !zipp {
-				iszip = true
-				fpath := filepath.Join(ftch, dir, file+".xml.gz")
-				if fpath == "" {
-					continue
+			// if failed to find ".xml" file, try ".xml.gz" or ".xml.zst" without requiring -gzip
+			for _, ext := range []string{".xml.gz", ".xml.zst"} {
+				if err != nil && errors.Is(err, fs.ErrNotExist) {
+					data, err = store.Get(eutils.ArchiveKey(id, "", ext))
 				}
-				inFile, err = os.Open(fpath)
 			}
 			if err != nil {
 				continue
@@ -1956,26 +2659,12 @@ func main() {
 
 			buf.Reset()
 
-			brd := bufio.NewReader(inFile)
-
-			if iszip {
-
-				zpr, err := pgzip.NewReader(brd)
-
-				if err == nil {
-					// copy and decompress cached file contents
-					buf.ReadFrom(zpr)
-				}
-
-				zpr.Close()
-
-			} else {
-
-				// copy cached file contents
-				buf.ReadFrom(brd)
+			// copy and decompress cached file contents
+			data, err = eutils.DecompressRecord(ftch, data)
+			if err != nil {
+				continue
 			}
-
-			inFile.Close()
+			buf.Write(data)
 
 			str := buf.String()
 
@@ -2063,8 +2752,17 @@ func main() {
 			}
 		}
 
+		if fill != "" && !pma2pme {
+			// missing records are retrieved and saved in the archive
+			fcdc := eutils.CodecGzip
+			if zstd {
+				fcdc = eutils.CodecZstd
+			}
+			eutils.SetArchiveFill(db, &eutils.ArchiveFill{URL: fill, Batch: fsiz, Rate: frat, Codec: fcdc})
+		}
+
 		uidq := eutils.CreateUIDReader(in)
-		strq := eutils.CreateFetchers(ftch, db, pfx, sfx, recname, zipp, uidq)
+		strq := eutils.CreateFetchers(context.Background(), ftch, db, pfx, sfx, recname, zipp, uidq)
 		unsq := eutils.CreateXMLUnshuffler(strq)
 
 		if uidq == nil || strq == nil || unsq == nil {
@@ -2163,7 +2861,12 @@ func main() {
 		}
 
 		uidq := eutils.CreateUIDReader(in)
-		strq := eutils.CreateCacheStreamers(strm, db, pfx, sfx, recskip, uidq)
+		codec := eutils.CodecGzip
+		if zstd {
+			codec = eutils.CodecZstd
+		}
+
+		strq := eutils.CreateCacheStreamers(context.Background(), strm, db, pfx, sfx, recskip, codec, uidq)
 		unsq := eutils.CreateXMLUnshuffler(strq)
 
 		if uidq == nil || strq == nil || unsq == nil {
@@ -2212,7 +2915,7 @@ func main() {
 		}
 
 		uidq := eutils.CreateUIDReader(in)
-		strq := eutils.CreateFetchers(smmn, db, "", ".e2x", recname, zipp, uidq)
+		strq := eutils.CreateFetchers(context.Background(), smmn, db, "", ".e2x", recname, zipp, uidq)
 		unsq := eutils.CreateXMLUnshuffler(strq)
 
 		if uidq == nil || strq == nil || unsq == nil {
@@ -2748,21 +3451,85 @@ func main() {
 		return
 	}
 
+	// REPORT STRUCTURED ABSTRACT SECTIONS WITH THEIR TIAB POSITION RANGES
+
+	// -sections plus -index plus -pattern prints identifier, field, first and last positions, and label
+	if sctn && indx != "" {
+
+		find := eutils.ParseIndex(indx)
+
+		eutils.PartitionXML(topPattern, star, false, rdr,
+			func(str string) {
+				recordCount++
+
+				id := eutils.FindIdentifier(str[:], parent, find)
+				if id == "" {
+					return
+				}
+
+				for _, sect := range eutils.AbstractSections(str) {
+					fmt.Fprintf(os.Stdout, "%s\t%s\t%d\t%d\t%s\n", id, sect.Field, sect.First, sect.Last, sect.Label)
+				}
+			})
+
+		if timr {
+			printDuration("records")
+		}
+
+		return
+	}
+
 	// COMPARE XML UPDATES TO LOCAL DIRECTORY, RETAIN NEW OR SUBSTANTIVELY CHANGED RECORDS
 
 	// -prepare plus -archive plus -index plus -pattern compares XML files against stash
 	if stsh != "" && indx != "" && cmpr {
 
 		doReport := false
+		doChanges := ""
 		if cmprType == "" || cmprType == "report" {
 			doReport = true
+		} else if cmprType == "xml" || cmprType == "json" {
+			// structured report of changed elements
+			doChanges = cmprType
 		} else if cmprType != "release" {
-			eutils.DisplayError("-prepare argument must be release or report")
+			eutils.DisplayError("-prepare argument must be release, report, xml, or json")
 			os.Exit(1)
 		}
 
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+
+		// printChanges writes one entry of the structured change report
+		printChanges := func(id, prev, next string) {
+
+			rc := eutils.CompareArticles(id, prev, next, ignr)
+
+			if doChanges == "json" {
+				enc.Encode(rc)
+				return
+			}
+
+			data, err := xml.MarshalIndent(rc, "  ", "  ")
+			if err != nil {
+				return
+			}
+			os.Stdout.Write(data)
+			os.Stdout.WriteString("\n")
+		}
+
 		find := eutils.ParseIndex(indx)
 
+		store := eutils.OpenArchiveStore(stsh)
+
+		if doChanges == "xml" {
+			if head == "" {
+				head = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<PubmedChangeSet>"
+			}
+			if tail == "" {
+				tail = "</PubmedChangeSet>"
+			}
+		}
+
 		if head != "" {
 			os.Stdout.WriteString(head)
 			os.Stdout.WriteString("\n")
@@ -2783,14 +3550,8 @@ func main() {
 					id = id[:pos]
 				}
 
-				dir, file := eutils.ArchiveTrie(id)
-
-				if dir == "" || file == "" {
-					return
-				}
-
-				fpath := filepath.Join(stsh, dir, file+".xml")
-				if fpath == "" {
+				key := eutils.ArchiveKey(id, "", ".xml")
+				if key == "" {
 					return
 				}
 
@@ -2828,9 +3589,13 @@ func main() {
 					}
 				}
 
-				_, err := os.Stat(fpath)
-				if err != nil && os.IsNotExist(err) {
+				buf, err := store.Get(key)
+				if err != nil && errors.Is(err, fs.ErrNotExist) {
 					// new record
+					if doChanges != "" {
+						printChanges(id, "", str)
+						return
+					}
 					printRecord(str, true)
 					return
 				}
@@ -2838,11 +3603,6 @@ func main() {
 					return
 				}
 
-				buf, err := ioutil.ReadFile(fpath)
-				if err != nil {
-					return
-				}
-
 				txt := string(buf[:])
 				txt = strings.TrimSuffix(txt, "\n")
 
@@ -2881,6 +3641,10 @@ func main() {
 				}
 
 				// substantively modified record
+				if doChanges != "" {
+					printChanges(id, txt, str)
+					return
+				}
 				printRecord(str, false)
 			})
 
@@ -2907,6 +3671,48 @@ func main() {
 	pmcSetHead := `<?xml version="1.0" encoding="UTF-8"?>
 `
 
+	// compression for stashed records
+	codec := eutils.CodecNone
+	if zstd {
+		codec = eutils.CodecZstd
+	} else if zipp {
+		codec = eutils.CodecGzip
+	}
+
+	// -dictionary plus -pattern trains zstd dictionary on sample records
+	if dctn != "" {
+
+		// a few thousand records are ample for training
+		maxSamples := 20000
+
+		var samples [][]byte
+
+		eutils.PartitionXML(topPattern, star, false, rdr,
+			func(str string) {
+				if len(samples) < maxSamples {
+					if !strings.HasSuffix(str, "\n") {
+						str += "\n"
+					}
+					samples = append(samples, []byte(str))
+				}
+				recordCount++
+			})
+
+		err := eutils.TrainZstdDictionary(dctn, samples)
+		if err != nil {
+			eutils.DisplayError("Unable to create zstd dictionary: %s", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Saved zstd dictionary %d from %d records\n", eutils.ZstdDictionaryID(dctn), len(samples))
+
+		if timr {
+			printDuration("records")
+		}
+
+		return
+	}
+
 	// -archive plus -index plus -pattern saves XML files in trie-based directory structure
 	if stsh != "" && indx != "" {
 
@@ -2930,8 +3736,18 @@ func main() {
 			sfx = ".asn"
 		}
 
+		var src *eutils.StashSource
+
+		if vrsn {
+			// keep prior revisions, noting arrival time and update file
+			src = &eutils.StashSource{Name: srce, Arrived: time.Now()}
+			if src.Name == "" && fileName != "" {
+				src.Name = filepath.Base(fileName)
+			}
+		}
+
 		xmlq := eutils.CreateXMLProducer(topPattern, star, false, rdr)
-		stsq := eutils.CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString, transform, hshv, zipp, asn, report, xmlq)
+		stsq := eutils.CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString, transform, hshv, asn, codec, report, src, xmlq)
 		clrq := eutils.CreateClearer(idcs, incr, db, stsq)
 
 		if xmlq == nil || stsq == nil || clrq == nil {
@@ -2969,6 +3785,110 @@ func main() {
 		return
 	}
 
+	// -scrub plus -repair plus -index plus -pattern replaces damaged records from -input file
+	if scrb != "" && rpair && indx != "" {
+
+		asn := false
+		pfx := ""
+		sfx := ".xml"
+		xmlString := ""
+
+		if db == "pmc" {
+			pfx = "PMC"
+			xmlString = pmcSetHead
+		} else if db == "pubmed" {
+			xmlString = pmaSetHead
+		}
+
+		if pma2pme {
+			asn = true
+			sfx = ".asn"
+		}
+
+		find := eutils.ParseIndex(indx)
+
+		restore := func(damaged map[string]string) map[string]bool {
+
+			xmlq := eutils.CreateXMLProducer(topPattern, star, false, rdr)
+			if xmlq == nil {
+				eutils.DisplayError("Unable to create repair producer")
+				os.Exit(1)
+			}
+
+			// records are restored with the codec of the damaged key, so the new
+			// contents replace it rather than being written beside it
+			codecs := []string{eutils.CodecNone, eutils.CodecGzip, eutils.CodecZstd}
+
+			fltqs := make(map[string]chan eutils.XMLRecord)
+			for _, cdc := range codecs {
+				fltqs[cdc] = make(chan eutils.XMLRecord, 16)
+			}
+
+			// only pass records flagged by the scrub
+			go func() {
+				defer func() {
+					for _, fltq := range fltqs {
+						close(fltq)
+					}
+				}()
+				for ext := range xmlq {
+					id := eutils.FindIdentifier(ext.Text, parent, find)
+					id, _, _ = strings.Cut(id, ".")
+					key, ok := damaged[id]
+					if ok {
+						fltqs[eutils.KeyCodec(key)] <- ext
+					}
+				}
+			}()
+
+			var mlock sync.Mutex
+			var wg sync.WaitGroup
+
+			fixed := make(map[string]bool)
+
+			for _, cdc := range codecs {
+
+				// hash column is empty if the record could not be saved
+				stsq := eutils.CreateStashers(scrb, parent, indx, pfx, sfx, db, xmlString, transform, true, asn, cdc, 1000, nil, fltqs[cdc])
+				if stsq == nil {
+					eutils.DisplayError("Unable to create repair stasher")
+					os.Exit(1)
+				}
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for str := range stsq {
+						id, hsh, _ := strings.Cut(strings.TrimSuffix(str, "\n"), "\t")
+						id, _, _ = strings.Cut(id, ".")
+						if hsh != "" {
+							mlock.Lock()
+							fixed[id] = true
+							mlock.Unlock()
+						}
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			return fixed
+		}
+
+		scrubArchive(restore)
+
+		if timr {
+			printDuration("records")
+		}
+
+		return
+	}
+
+	if scrb != "" && rpair {
+		eutils.DisplayError("-scrub -repair requires -input, -index, and -pattern")
+		os.Exit(1)
+	}
+
 	// READ FILE OF IDENTIFIERS AND EXTRACT SELECTED RECORDS FROM XML INPUT FILE
 
 	// -index plus -unique [plus -head/-tail/-hd/-tl] plus -pattern with no other extraction arguments
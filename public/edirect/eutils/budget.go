@@ -0,0 +1,120 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  budget.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// QUERY CANCELLATION AND RESOURCE BUDGETS
+
+// A query carries a context.Context from the caller (e.g., an edict request,
+// which is cancelled when the client disconnects). Evaluation checks it between
+// phrases and before each postings read, so a runaway truncation such as
+// "a* [TIAB]" stops promptly. A time budget is simply a context deadline.
+
+// A memory budget limits the bytes of postings data (UIDs and positions) that
+// a single query may load. Sizes are charged before the data is read, so an
+// oversized request is refused without allocating it.
+
+// Wildcard truncation also fuses the postings of all matching terms in a map,
+// which is charged per entry, using approximate in-memory sizes of a map
+// element (key, value, and bucket overhead) plus any position slice header.
+
+const (
+	mergedEntrySize = 16
+	comboEntrySize  = 40
+)
+
+// ErrMemoryBudget is returned when a query would exceed its memory budget
+var ErrMemoryBudget = errors.New("query exceeded memory budget")
+
+type budgetKey struct{}
+
+type memoryBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// WithMemoryBudget returns a context that limits postings data loaded by a query to the given number of bytes
+func WithMemoryBudget(ctx context.Context, limit int64) context.Context {
+
+	if limit < 1 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, budgetKey{}, &memoryBudget{limit: limit})
+}
+
+// chargeBudget records an allocation against the query's memory budget, returning an error if it is exceeded
+func chargeBudget(ctx context.Context, size int64) error {
+
+	if ctx == nil {
+		return nil
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return queryError(err)
+	}
+
+	mb, ok := ctx.Value(budgetKey{}).(*memoryBudget)
+	if !ok || mb == nil {
+		return nil
+	}
+
+	used := mb.used.Add(size)
+	if used > mb.limit {
+		return fmt.Errorf("%w (%d bytes requested, limit %d)", ErrMemoryBudget, used, mb.limit)
+	}
+
+	return nil
+}
+
+// checkQuery returns a non-nil error if the query has been cancelled, timed out, or used up its memory budget
+func checkQuery(ctx context.Context) error {
+
+	return chargeBudget(ctx, 0)
+}
+
+// queryError converts a context error to a descriptive message
+func queryError(err error) error {
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("query exceeded time budget: %w", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("query cancelled: %w", err)
+	}
+
+	return err
+}
@@ -0,0 +1,646 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  bundle.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ARCHIVE SNAPSHOT BUNDLES
+
+// ExportArchive writes archived records, in their stored compressed form, to a series of
+// tar files, bundle-00001.tar and onward, each limited to approximately maxSize bytes.
+// Each tar entry is named by its storage key and carries a CRC32 of its contents in the
+// EDIRECT.crc32 PAX header. The bundle directory also receives manifest.json, which lists
+// every bundle with its SHA-256, size, and record count. An archive zstd dictionary is
+// copied alongside, since records cannot be read without it. ImportArchive verifies both
+// levels of checksums before storing records in another archive.
+
+// BundleManifest is the manifest.json file describing a set of exported bundles
+type BundleManifest struct {
+	Format     int          `json:"format"`
+	Created    string       `json:"created"`
+	Records    int          `json:"records"`
+	Dictionary string       `json:"dictionary,omitempty"`
+	Bundles    []BundleFile `json:"bundles"`
+}
+
+// BundleFile describes one tar file in a bundle set
+type BundleFile struct {
+	File    string `json:"file"`
+	SHA256  string `json:"sha256"`
+	Bytes   int64  `json:"bytes"`
+	Records int    `json:"records"`
+}
+
+// BundleManifestName is the name of the manifest in a bundle directory
+const BundleManifestName = "manifest.json"
+
+const bundleCRC = "EDIRECT.crc32"
+
+// ImportStats counts the outcome of ImportArchive
+type ImportStats struct {
+	Added    int
+	Replaced int
+	Skipped  int
+	Damaged  int
+}
+
+// ExportKeys returns the record keys to export, either those for the identifiers read
+// from uidq, or all records in the archive, optionally limited to a numeric range
+func ExportKeys(stsh string, uidq <-chan XMLRecord, lo, hi int) <-chan string {
+
+	store := OpenArchiveStore(stsh)
+
+	out := make(chan string, chanDepth)
+	if out == nil {
+		DisplayError("Unable to create export channel")
+		os.Exit(1)
+	}
+
+	inRange := func(id string) bool {
+		if lo < 1 && hi < 1 {
+			return true
+		}
+		num, err := strconv.Atoi(id)
+		if err != nil {
+			return false
+		}
+		return num >= lo && (hi < 1 || num <= hi)
+	}
+
+	go func() {
+
+		defer close(out)
+
+		if uidq != nil {
+			for uid := range uidq {
+				id := strings.TrimSpace(uid.Text)
+				if id == "" || !inRange(id) {
+					continue
+				}
+				pfx := ""
+				if strings.HasPrefix(id, "PMC") {
+					pfx = "PMC"
+				}
+				key := recordKey(store, id, pfx, ".xml")
+				if key != "" {
+					out <- key
+				}
+			}
+			return
+		}
+
+		err := store.Walk(func(key string) {
+			_, name := path.Split(key)
+			if isRecordFile(name) && inRange(recordIdentifier(name)) {
+				out <- key
+			}
+		})
+		if err != nil {
+			DisplayError("Unable to list archive: %s", err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	return out
+}
+
+// bundleWriter appends records to the current tar file, starting a new one when full
+type bundleWriter struct {
+	dir      string
+	maxSize  int64
+	manifest BundleManifest
+	file     *os.File
+	tw       *tar.Writer
+	sum      func() []byte
+	count    *countingWriter
+	current  BundleFile
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+
+	cw.n += int64(len(p))
+
+	return len(p), nil
+}
+
+func (bw *bundleWriter) finish() error {
+
+	if bw.tw == nil {
+		return nil
+	}
+
+	err := bw.tw.Close()
+	if err == nil {
+		err = bw.file.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	bw.current.SHA256 = hex.EncodeToString(bw.sum())
+	bw.current.Bytes = bw.count.n
+	bw.manifest.Bundles = append(bw.manifest.Bundles, bw.current)
+
+	bw.tw = nil
+	bw.file = nil
+
+	return nil
+}
+
+func (bw *bundleWriter) add(key string, data []byte) error {
+
+	// start new bundle if this record would exceed the size limit
+	if bw.tw != nil && bw.current.Records > 0 && bw.count.n+int64(len(data))+1024 > bw.maxSize {
+		err := bw.finish()
+		if err != nil {
+			return err
+		}
+	}
+
+	if bw.tw == nil {
+		name := fmt.Sprintf("bundle-%05d.tar", len(bw.manifest.Bundles)+1)
+		fl, err := os.Create(filepath.Join(bw.dir, name))
+		if err != nil {
+			return err
+		}
+		hsh := sha256.New()
+		bw.count = &countingWriter{}
+		bw.file = fl
+		bw.sum = func() []byte { return hsh.Sum(nil) }
+		bw.tw = tar.NewWriter(io.MultiWriter(fl, hsh, bw.count))
+		bw.current = BundleFile{File: name}
+	}
+
+	hdr := &tar.Header{
+		Name:       key,
+		Mode:       0644,
+		ModTime:    time.Now(),
+		Size:       int64(len(data)),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{bundleCRC: strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 10)},
+	}
+
+	err := bw.tw.WriteHeader(hdr)
+	if err == nil {
+		_, err = bw.tw.Write(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	bw.current.Records++
+	bw.manifest.Records++
+
+	return nil
+}
+
+// ExportArchive reads records for the given keys in parallel and writes them to bundles
+func ExportArchive(stsh, dir string, keys <-chan string, maxSize int64) (BundleManifest, error) {
+
+	store := OpenArchiveStore(stsh)
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return BundleManifest{}, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, BundleManifestName)); err == nil {
+		return BundleManifest{}, fmt.Errorf("%s already contains an export", dir)
+	}
+
+	bw := &bundleWriter{
+		dir:      dir,
+		maxSize:  maxSize,
+		manifest: BundleManifest{Format: 1, Created: time.Now().UTC().Format(time.RFC3339)},
+	}
+
+	// zstd records need the dictionary they were compressed with
+	dct, err := os.ReadFile(filepath.Join(stsh, ZstdDictionary))
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, ZstdDictionary), dct, 0644)
+		if err != nil {
+			return BundleManifest{}, err
+		}
+		sum := sha256.Sum256(dct)
+		bw.manifest.Dictionary = hex.EncodeToString(sum[:])
+	} else if !os.IsNotExist(err) {
+		return BundleManifest{}, err
+	}
+	err = nil
+
+	type exportRecord struct {
+		key  string
+		data []byte
+	}
+
+	recq := make(chan exportRecord, chanDepth)
+
+	var wg sync.WaitGroup
+
+	// launch multiple reader goroutines
+	for range max(numServe, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				data, err := store.Get(key)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+					continue
+				}
+				recq <- exportRecord{key, data}
+			}
+		}()
+	}
+
+	// launch separate anonymous goroutine to wait until all readers are done
+	go func() {
+		wg.Wait()
+		close(recq)
+	}()
+
+	// single writer goroutine keeps each tar file sequential
+	for rec := range recq {
+		if err == nil {
+			err = bw.add(rec.key, rec.data)
+		}
+	}
+
+	if err == nil {
+		err = bw.finish()
+	}
+	if err != nil {
+		return bw.manifest, err
+	}
+
+	data, err := json.MarshalIndent(bw.manifest, "", "  ")
+	if err != nil {
+		return bw.manifest, err
+	}
+
+	return bw.manifest, os.WriteFile(filepath.Join(dir, BundleManifestName), append(data, '\n'), 0644)
+}
+
+// recordVersion returns the PMID Version attribute and DateRevised (as YYYYMMDD) of a
+// PubmedArticle record, or zero values if it has neither
+func recordVersion(text string) (int, string) {
+
+	vers := 0
+
+	pos := strings.Index(text, "<PMID Version=\"")
+	if pos >= 0 {
+		str := text[pos+len("<PMID Version=\""):]
+		str, _, _ = strings.Cut(str, "\"")
+		vers, _ = strconv.Atoi(str)
+	}
+
+	revd := ""
+
+	_, blk, found := strings.Cut(text, "<DateRevised>")
+	if found {
+		blk, _, _ = strings.Cut(blk, "</DateRevised>")
+		part := func(tag string, width int) string {
+			_, str, ok := strings.Cut(blk, "<"+tag+">")
+			if !ok {
+				return strings.Repeat("0", width)
+			}
+			str, _, _ = strings.Cut(str, "</"+tag+">")
+			for len(str) < width {
+				str = "0" + str
+			}
+			return str
+		}
+		revd = part("Year", 4) + part("Month", 2) + part("Day", 2)
+	}
+
+	return vers, revd
+}
+
+// isNewerRecord compares the stored forms of two records, reporting whether the incoming
+// record has a higher PMID version, or the same version and a later revision date
+func isNewerRecord(stsh string, existing, incoming []byte) bool {
+
+	old, err := DecompressRecord(stsh, existing)
+	if err != nil {
+		// damaged local copy
+		return true
+	}
+	nxt, err := DecompressRecord(stsh, incoming)
+	if err != nil {
+		return false
+	}
+
+	ov, od := recordVersion(string(old))
+	nv, nd := recordVersion(string(nxt))
+
+	if nv != ov {
+		return nv > ov
+	}
+
+	return nd > od
+}
+
+// recodeZstd decompresses each zstd frame with the source dictionary and compresses it
+// again with the destination dictionary, keeping the record header a separate frame
+func recodeZstd(from, to string, data []byte) ([]byte, error) {
+
+	var res []byte
+
+	for len(data) > 0 {
+		n := zstdFrameLen(data)
+		if n == 0 {
+			n = len(data)
+		}
+		txt, err := archiveCodec(from).decoder.DecodeAll(data[:n], nil)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, CompressString(to, CodecZstd, string(txt))...)
+		data = data[n:]
+	}
+
+	return res, nil
+}
+
+// installDictionary verifies the exported zstd dictionary and copies it into an archive
+// that has none, reporting whether incoming zstd records must be recompressed
+func installDictionary(stsh, dir, want string) (bool, error) {
+
+	if want == "" {
+		return false, nil
+	}
+
+	dct, err := os.ReadFile(filepath.Join(dir, ZstdDictionary))
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(dct)
+	if hex.EncodeToString(sum[:]) != want {
+		return false, fmt.Errorf("%s does not match its manifest checksum", ZstdDictionary)
+	}
+
+	local, err := os.ReadFile(filepath.Join(stsh, ZstdDictionary))
+	if os.IsNotExist(err) {
+		return false, os.WriteFile(filepath.Join(stsh, ZstdDictionary), dct, 0644)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(local, dct), nil
+}
+
+// verifyBundle checks a tar file against its manifest entry
+func verifyBundle(dir string, bf BundleFile) error {
+
+	fl, err := os.Open(filepath.Join(dir, bf.File))
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	hsh := sha256.New()
+	n, err := io.Copy(hsh, fl)
+	if err != nil {
+		return err
+	}
+
+	if n != bf.Bytes || hex.EncodeToString(hsh.Sum(nil)) != bf.SHA256 {
+		return fmt.Errorf("%s does not match its manifest checksum", bf.File)
+	}
+
+	return nil
+}
+
+// ImportArchive verifies exported bundles and stores their records in an archive,
+// adding absent records and replacing existing ones only with newer versions
+func ImportArchive(stsh, dir string) (ImportStats, error) {
+
+	var stats ImportStats
+
+	data, err := os.ReadFile(filepath.Join(dir, BundleManifestName))
+	if err != nil {
+		return stats, err
+	}
+
+	var manifest BundleManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return stats, fmt.Errorf("%s: %s", BundleManifestName, err.Error())
+	}
+	if manifest.Format != 1 {
+		return stats, fmt.Errorf("unsupported bundle format %d", manifest.Format)
+	}
+
+	// check every bundle before changing the archive
+	for _, bf := range manifest.Bundles {
+		err = verifyBundle(dir, bf)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	err = os.MkdirAll(stsh, os.ModePerm)
+	if err != nil {
+		return stats, err
+	}
+
+	// must precede first use of the archive codec
+	recode, err := installDictionary(stsh, dir, manifest.Dictionary)
+	if err != nil {
+		return stats, err
+	}
+
+	store := OpenArchiveStore(stsh)
+	checksums := newManifestBatch(store, stsh)
+
+	type importRecord struct {
+		key  string
+		data []byte
+	}
+
+	recq := make(chan importRecord, chanDepth)
+
+	var slock sync.Mutex
+
+	count := func(val *int) {
+		slock.Lock()
+		*val++
+		slock.Unlock()
+	}
+
+	importer := func(wg *sync.WaitGroup) {
+
+		defer wg.Done()
+
+		for rec := range recq {
+
+			_, name := path.Split(rec.key)
+			id := recordIdentifier(name)
+			sfx := strings.TrimPrefix(name, id)
+			for _, ext := range codecSuffixes {
+				sfx = strings.TrimSuffix(sfx, ext)
+			}
+			pfx := ""
+			if strings.HasPrefix(id, "PMC") {
+				pfx = "PMC"
+			}
+
+			if recode && DetectCodec(rec.data) == CodecZstd {
+				data, err := recodeZstd(dir, stsh, rec.data)
+				if err != nil {
+					count(&stats.Damaged)
+					continue
+				}
+				rec.data = data
+			}
+
+			// existing record may be stored with a different codec
+			prev := recordKey(store, id, pfx, sfx)
+
+			if prev != "" {
+				existing, err := store.Get(prev)
+				if err == nil && !isNewerRecord(stsh, existing, rec.data) {
+					count(&stats.Skipped)
+					continue
+				}
+			}
+
+			err := store.Put(rec.key, rec.data)
+			if err == nil {
+				err = checksums.record(rec.key, rec.data)
+			}
+			if err == nil && prev != "" && prev != rec.key {
+				err = store.Delete(prev)
+				if err == nil {
+					err = checksums.record(prev, nil)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				count(&stats.Damaged)
+				continue
+			}
+
+			if prev != "" {
+				count(&stats.Replaced)
+			} else {
+				count(&stats.Added)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	// launch multiple importer goroutines
+	for range max(numServe, 1) {
+		wg.Add(1)
+		go importer(&wg)
+	}
+
+	readBundle := func(bf BundleFile) error {
+
+		fl, err := os.Open(filepath.Join(dir, bf.File))
+		if err != nil {
+			return err
+		}
+		defer fl.Close()
+
+		tr := tar.NewReader(fl)
+
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+
+			// keys must stay inside the archive trie
+			key := path.Clean(hdr.Name)
+			_, name := path.Split(key)
+			if key != hdr.Name || strings.HasPrefix(key, "/") || strings.HasPrefix(key, "..") || !isRecordFile(name) {
+				count(&stats.Damaged)
+				continue
+			}
+
+			want, ok := hdr.PAXRecords[bundleCRC]
+			if !ok || want != strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 10) {
+				count(&stats.Damaged)
+				continue
+			}
+
+			recq <- importRecord{key, data}
+		}
+	}
+
+	for _, bf := range manifest.Bundles {
+		err = readBundle(bf)
+		if err != nil {
+			break
+		}
+	}
+
+	close(recq)
+	wg.Wait()
+
+	ferr := checksums.flush()
+	if err == nil {
+		err = ferr
+	}
+
+	return stats, err
+}
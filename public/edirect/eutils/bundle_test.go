@@ -0,0 +1,248 @@
+package eutils
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pubmedRecord builds a minimal PubmedArticle with a PMID version and revision date
+func pubmedRecord(pmid string, version int, revised, title string) string {
+
+	vers := ""
+	if version > 0 {
+		vers = " Version=\"" + strconv.Itoa(version) + "\""
+	}
+
+	revd := ""
+	if revised != "" {
+		revd = "<DateRevised><Year>" + revised[:4] + "</Year><Month>" + revised[4:6] + "</Month><Day>" + revised[6:] + "</Day></DateRevised>"
+	}
+
+	return "<PubmedArticle><MedlineCitation><PMID" + vers + ">" + pmid + "</PMID>" + revd +
+		"<ArticleTitle>" + title + "</ArticleTitle></MedlineCitation></PubmedArticle>"
+}
+
+// putRecords stores gzip records directly in an archive, keyed by PMID
+func putRecords(t *testing.T, stsh string, records map[string]string) {
+
+	store := OpenArchiveStore(stsh)
+	for pmid, text := range records {
+		err := store.Put(ArchiveKey(pmid, "", ".xml.gz"), GzipString(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// archiveText returns the decompressed record for a PMID, or "" if it is absent
+func archiveText(t *testing.T, stsh, pmid string) string {
+
+	store := OpenArchiveStore(stsh)
+	data, err := store.Get(ArchiveKey(pmid, "", ".xml.gz"))
+	if err != nil {
+		return ""
+	}
+	txt, err := DecompressRecord(stsh, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(txt)
+}
+
+func exportAll(t *testing.T, stsh, dir string, maxSize int64) BundleManifest {
+
+	manifest, err := ExportArchive(stsh, dir, ExportKeys(stsh, nil, 0, 0), maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return manifest
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	src := t.TempDir()
+	records := map[string]string{
+		"1":        pubmedRecord("1", 1, "19751001", "first"),
+		"2539356":  pubmedRecord("2539356", 1, "20190208", "second"),
+		"37011990": pubmedRecord("37011990", 2, "20230405", "third"),
+	}
+	putRecords(t, src, records)
+
+	// small size limit puts each record in its own bundle
+	dir := filepath.Join(t.TempDir(), "export")
+	manifest := exportAll(t, src, dir, 64)
+
+	if manifest.Records != 3 || len(manifest.Bundles) != 3 {
+		t.Errorf("exported %d records in %d bundles, want 3 in 3", manifest.Records, len(manifest.Bundles))
+	}
+	for _, bf := range manifest.Bundles {
+		err := verifyBundle(dir, bf)
+		if err != nil {
+			t.Errorf("verifyBundle(%s) = %v", bf.File, err)
+		}
+	}
+
+	// a second export into the same directory is refused
+	_, err := ExportArchive(src, dir, ExportKeys(src, nil, 0, 0), 64)
+	if err == nil {
+		t.Errorf("ExportArchive over existing export succeeded")
+	}
+
+	dst := t.TempDir()
+	stats, err := ImportArchive(dst, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (ImportStats{Added: 3}) {
+		t.Errorf("first import = %+v, want 3 added", stats)
+	}
+	for pmid, text := range records {
+		if got := archiveText(t, dst, pmid); got != text {
+			t.Errorf("imported %s = %q, want %q", pmid, got, text)
+		}
+	}
+
+	// importing identical records again changes nothing
+	stats, err = ImportArchive(dst, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (ImportStats{Skipped: 3}) {
+		t.Errorf("second import = %+v, want 3 skipped", stats)
+	}
+}
+
+func TestBundleCorruptShard(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	src := t.TempDir()
+	putRecords(t, src, map[string]string{
+		"1": pubmedRecord("1", 1, "19751001", "first"),
+		"2": pubmedRecord("2", 1, "19751001", "second"),
+	})
+
+	dir := filepath.Join(t.TempDir(), "export")
+	manifest := exportAll(t, src, dir, 64)
+	if len(manifest.Bundles) < 2 {
+		t.Fatalf("exported %d bundles, want 2", len(manifest.Bundles))
+	}
+
+	// damage the last shard, so that an import reading bundles in order would
+	// already have stored records from the first one
+	last := filepath.Join(dir, manifest.Bundles[len(manifest.Bundles)-1].File)
+	data, err := os.ReadFile(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/3] ^= 0xFF
+	err = os.WriteFile(last, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	stats, err := ImportArchive(dst, dir)
+	if err == nil || !strings.Contains(err.Error(), "does not match its manifest checksum") {
+		t.Errorf("ImportArchive = %v, want checksum mismatch", err)
+	}
+	if stats != (ImportStats{}) {
+		t.Errorf("import of damaged bundles = %+v, want nothing done", stats)
+	}
+	for _, pmid := range []string{"1", "2"} {
+		if got := archiveText(t, dst, pmid); got != "" {
+			t.Errorf("record %s stored despite damaged bundle", pmid)
+		}
+	}
+}
+
+func TestBundleImportNewer(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	tests := []struct {
+		name     string
+		pmid     string
+		oldVers  int
+		oldRevd  string
+		newVers  int
+		newRevd  string
+		replaced bool
+	}{
+		{"higher version", "11", 1, "20200101", 2, "20200101", true},
+		{"lower version", "12", 2, "20200101", 1, "20240101", false},
+		{"later revision", "13", 1, "20200101", 1, "20200102", true},
+		{"earlier revision", "14", 1, "20200102", 1, "20200101", false},
+		{"same revision", "15", 1, "20200101", 1, "20200101", false},
+		{"version outranks revision", "16", 1, "20240101", 2, "20200101", true},
+		{"revision added", "17", 1, "", 1, "20200101", true},
+		{"no version attribute", "18", 0, "20200101", 1, "20200101", true},
+	}
+
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	existing := make(map[string]string)
+	incoming := make(map[string]string)
+	for _, tt := range tests {
+		existing[tt.pmid] = pubmedRecord(tt.pmid, tt.oldVers, tt.oldRevd, "old")
+		incoming[tt.pmid] = pubmedRecord(tt.pmid, tt.newVers, tt.newRevd, "new")
+	}
+	// one record is only in the export
+	incoming["19"] = pubmedRecord("19", 1, "20200101", "new")
+
+	putRecords(t, dst, existing)
+	putRecords(t, src, incoming)
+
+	dir := filepath.Join(t.TempDir(), "export")
+	exportAll(t, src, dir, 1<<20)
+
+	stats, err := ImportArchive(dst, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ImportStats{Added: 1}
+	for _, tt := range tests {
+		if tt.replaced {
+			want.Replaced++
+		} else {
+			want.Skipped++
+		}
+	}
+	if stats != want {
+		t.Errorf("ImportArchive = %+v, want %+v", stats, want)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, nxt := existing[tt.pmid], incoming[tt.pmid]
+			if got := isNewerRecord(dst, GzipString(old), GzipString(nxt)); got != tt.replaced {
+				t.Errorf("isNewerRecord = %v, want %v", got, tt.replaced)
+			}
+			want := old
+			if tt.replaced {
+				want = nxt
+			}
+			if got := archiveText(t, dst, tt.pmid); got != want {
+				t.Errorf("archived %q, want %q", got, want)
+			}
+		})
+	}
+
+	if got := archiveText(t, dst, "19"); got != incoming["19"] {
+		t.Errorf("new record 19 = %q", got)
+	}
+
+	// an unreadable local copy is always replaced
+	if !isNewerRecord(dst, []byte{0x1f, 0x8b, 0}, GzipString(incoming["19"])) {
+		t.Errorf("isNewerRecord kept damaged local copy")
+	}
+}
@@ -34,12 +34,16 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -55,62 +59,43 @@ func fetchOneXMLRecord(id, base, pfx, sfx string, zipp bool, buf bytes.Buffer) s
 
 	id = strings.TrimPrefix(id, "PMC")
 
-	dir, file := ArchiveTrie(id)
+	store := OpenArchiveStore(base)
 
-	if dir == "" || file == "" {
-		return ""
+	// try compressed files first with -gzip, and either way detect the codec from the contents
+	sfxs := []string{sfx}
+	for _, ext := range codecSuffixes {
+		sfxs = append(sfxs, sfx+ext)
 	}
-
 	if zipp {
-		sfx += ".gz"
-	}
-
-	fpath := filepath.Join(base, dir, pfx+file+sfx)
-	if fpath == "" {
-		return ""
+		sfxs = append(sfxs[1:], sfx)
 	}
 
-	iszip := zipp
+	var data []byte
+	var err error
 
-	inFile, err := os.Open(fpath)
-
-	// if failed to find ".xml" or ".e2x" file, try ".xml.gz" or ".e2x.gz" without requiring -gzip
-	if err != nil && os.IsNotExist(err) && !zipp {
-		iszip = true
-		fpath := filepath.Join(base, dir, pfx+file+sfx+".gz")
-		if fpath == "" {
+	for _, ext := range sfxs {
+		key := ArchiveKey(id, pfx, ext)
+		if key == "" {
 			return ""
 		}
-		inFile, err = os.Open(fpath)
+		data, err = store.Get(key)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
 	}
 	if err != nil {
-		msg := err.Error()
-		if !strings.HasSuffix(msg, "no such file or directory") && !strings.HasSuffix(msg, "cannot find the path specified.") {
-			fmt.Fprintf(os.Stderr, "%s\n", msg)
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		}
 		return ""
 	}
 
-	defer inFile.Close()
-
-	brd := bufio.NewReader(inFile)
-
-	if iszip {
-
-		zpr, err := gzip.NewReader(brd)
-
-		defer zpr.Close()
-
-		if err == nil {
-			// copy and decompress cached file contents
-			buf.ReadFrom(zpr)
-		}
-
-	} else {
-
-		// copy cached file contents
-		buf.ReadFrom(brd)
+	// copy and decompress cached file contents
+	data, err = DecompressRecord(base, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 	}
+	buf.Write(data)
 
 	str := buf.String()
 
@@ -272,6 +257,36 @@ func ReadsUIDsFromString(uids string) <-chan XMLRecord {
 	return out
 }
 
+// ReadsUIDsFromArray sends an array of numeric uids, such as search results, through channel
+func ReadsUIDsFromArray(uids []int32) <-chan XMLRecord {
+
+	if len(uids) < 1 {
+		return nil
+	}
+
+	out := make(chan XMLRecord, chanDepth)
+	if out == nil {
+		DisplayError("Unable to create uid array reader channel")
+		os.Exit(1)
+	}
+
+	// uidArrayReader converts uids to strings only as they are sent
+	uidArrayReader := func(uids []int32, out chan<- XMLRecord) {
+
+		// close channel when all records have been processed
+		defer close(out)
+
+		for i, uid := range uids {
+			out <- XMLRecord{Index: i + 1, Text: strconv.Itoa(int(uid))}
+		}
+	}
+
+	// launch single uid array reader goroutine
+	go uidArrayReader(uids, out)
+
+	return out
+}
+
 func mapXMLtoASN(node *XMLNode, proc func(string)) {
 
 	if node == nil || proc == nil {
@@ -404,8 +419,10 @@ func mapXMLtoASN(node *XMLNode, proc func(string)) {
 const XMLDoctypeGzipLen = 183
 
 // CreateStashers saves records to archive, multithreaded for performance, use of UID
-// position index allows it to prevent earlier version from overwriting later version
-func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transform map[string]string, hash, zipp, asn bool, report int, inp <-chan XMLRecord) <-chan string {
+// position index allows it to prevent earlier version from overwriting later version,
+// a non-nil src keeps replaced records as prior revisions (see ArchiveHistory), and codec
+// selects gzip, zstd, or no compression
+func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transform map[string]string, hash, asn bool, codec string, report int, src *StashSource, inp <-chan XMLRecord) <-chan string {
 
 	if inp == nil {
 		return nil
@@ -419,9 +436,20 @@ func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transfor
 
 	find := ParseIndex(indx)
 
-	if zipp {
-		sfx += ".gz"
-	}
+	// a record stored earlier with a different codec is replaced by this one
+	others := []string{sfx + ".gz", sfx + ".zst", sfx}
+
+	sfx += CodecSuffix(codec)
+
+	store := OpenArchiveStore(stsh)
+
+	// checksum manifest is checked by rchive -scrub
+	manifest := newManifestBatch(store, stsh)
+
+	// records deleted earlier and now stashed again are taken out of the deleted set
+	deleted := ReadDeletedSet(db)
+	var reinstated []string
+	var rlock sync.Mutex
 
 	type StasherType int
 
@@ -494,12 +522,12 @@ func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transfor
 
 	var xmlDoctype []byte
 
-	// make gzip-compressed byte array of xml + DOCTYPE header
+	// make compressed byte array of xml + DOCTYPE header
 	if xmlString != "" {
-		xmlDoctype = GzipString(xmlString)
+		xmlDoctype = CompressString(stsh, codec, xmlString)
 	}
 
-	if db == "pubmed" {
+	if db == "pubmed" && codec == CodecGzip {
 
 		// reality check on expected length
 		pmaHeadLen := len(xmlDoctype)
@@ -586,27 +614,6 @@ func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transfor
 		// delete lock after writing file
 		defer freeFile(id)
 
-		dpath := filepath.Join(stsh, dir)
-		if dpath == "" {
-			return ""
-		}
-		err := os.MkdirAll(dpath, os.ModePerm)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-			return ""
-		}
-		fpath := filepath.Join(dpath, pfx+file+sfx)
-		if fpath == "" {
-			return ""
-		}
-
-		// overwrites and truncates existing file
-		fl, err := os.Create(fpath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-			return ""
-		}
-
 		res := id
 
 		if hash {
@@ -617,32 +624,107 @@ func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transfor
 			res = strconv.FormatUint(uint64(val), 10)
 		}
 
-		if zipp {
+		var data []byte
+
+		if codec != CodecNone {
 
 			if !asn && db == "pubmed" {
-				fl.Write(xmlDoctype)
+				data = append(data, xmlDoctype...)
 			}
 
-			zp := GzipString(str)
-			fl.Write(zp)
+			data = append(data, CompressString(stsh, codec, str)...)
 
 		} else {
 
-			// copy uncompressed record to file
-			fl.WriteString(str)
+			// copy uncompressed record
+			data = []byte(str)
 			if !strings.HasSuffix(str, "\n") {
-				fl.WriteString("\n")
+				data = append(data, '\n')
 			}
 		}
 
-		// fl.Sync()
+		key := dir + pfx + file + sfx
 
-		err = fl.Close()
+		// readers try compressed keys first, so copies under other codecs must be removed
+		var stale []string
+		for _, ext := range others {
+			alt := dir + pfx + file + ext
+			if alt != key && store.Has(alt) {
+				stale = append(stale, alt)
+			}
+		}
+
+		// contents currently served are the prior version
+		prev := key
+		if len(stale) > 0 && !store.Has(key) {
+			prev = stale[0]
+			err := moveRevisions(store, prev, key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				return ""
+			}
+		}
+
+		var hist []byte
+
+		if src != nil {
+			// save current contents as prior revision
+			var err error
+			hist, err = keepRevision(store, key, prev, data, src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				return ""
+			}
+			if hist == nil {
+				// unchanged record
+				countSuccess()
+				return res
+			}
+		}
+
+		// overwrites any existing record
+		err := store.Put(key, data)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 			return ""
 		}
 
+		err = manifest.record(key, data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+
+		for _, alt := range stale {
+			err = store.Delete(alt)
+			if err == nil {
+				err = deleteRevisions(store, alt)
+			}
+			if err == nil {
+				err = manifest.record(alt, nil)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+		}
+
+		if hist != nil {
+			// record history after new contents are in place
+			err = store.Put(historyKey(key), hist)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				return ""
+			}
+		}
+
+		if len(deleted) > 0 {
+			val, err := strconv.ParseInt(id, 10, 32)
+			if _, found := slices.BinarySearch(deleted, int32(val)); err == nil && found {
+				rlock.Lock()
+				reinstated = append(reinstated, id)
+				rlock.Unlock()
+			}
+		}
+
 		// progress monitor prints dot every 1000 (.xml or .asn) or 50000 (.e2x) records
 		countSuccess()
 
@@ -696,6 +778,14 @@ func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transfor
 	// launch separate anonymous goroutine to wait until all stashers are done
 	go func() {
 		wg.Wait()
+		err := manifest.flush()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+		err = ReinstateDeleted(db, reinstated)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
 		close(out)
 		// print newline after rows of dots (progress monitor)
 		fmt.Fprintf(os.Stderr, "\n")
@@ -706,6 +796,8 @@ func CreateStashers(stsh, parent, indx, pfx, sfx, db, xmlString string, transfor
 
 // CreateDeleter reads PMIDs, deletes them in the archive, and sends them
 // down a channel to have the affected inverted index cache files removed.
+// The PMIDs are also added to the postings deleted set, hiding them from
+// query results until the postings are rebuilt.
 func CreateDeleter(stsh, db string, in io.Reader) <-chan string {
 
 	if stsh == "" || in == nil {
@@ -718,6 +810,9 @@ func CreateDeleter(stsh, db string, in io.Reader) <-chan string {
 		os.Exit(1)
 	}
 
+	store := OpenArchiveStore(stsh)
+	manifest := newManifestBatch(store, stsh)
+
 	recordDeleter := func(in io.Reader, out chan<- string) {
 
 		// close channel when all records have been processed
@@ -732,6 +827,8 @@ func CreateDeleter(stsh, db string, in io.Reader) <-chan string {
 			verbose = true
 		}
 
+		var deleted []string
+
 		for scanr.Scan() {
 
 			// read lines of identifiers
@@ -746,24 +843,44 @@ func CreateDeleter(stsh, db string, in io.Reader) <-chan string {
 				id = id[:pos]
 			}
 
-			dir, file := ArchiveTrie(id)
-
-			if dir == "" || file == "" {
+			if ArchiveKey(id, "", ".xml") == "" {
 				continue
 			}
 
-			dpath := filepath.Join(stsh, dir, file+".xml.gz")
-			if dpath == "" {
-				continue
+			for _, ext := range codecSuffixes {
+				key := ArchiveKey(id, "", ".xml"+ext)
+				if !store.Has(key) {
+					continue
+				}
+				err := store.Delete(key)
+				if err == nil {
+					err = deleteRevisions(store, key)
+				}
+				if err == nil {
+					err = manifest.record(key, nil)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				}
+				if verbose {
+					fmt.Fprintf(os.Stderr, "DEL PMD %s\n", filepath.Join(stsh, key))
+				}
 			}
 
-			os.Remove(dpath)
-			if verbose {
-				fmt.Fprintf(os.Stderr, "DEL PMD %s\n", dpath)
-			}
+			deleted = append(deleted, id)
 
 			out <- id
 		}
+
+		err := manifest.flush()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+
+		err = RecordDeleted(db, deleted)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
 	}
 
 	// launch single deleter goroutine
@@ -856,8 +973,12 @@ func CreateClearer(indexBase, invertBase, db string, inp <-chan string) <-chan s
 	return out
 }
 
-// CreateFetchers returns uncompressed records from archive, multithreaded for speed
-func CreateFetchers(stsh, db, pfx, sfx, ptrn string, zipp bool, inp <-chan XMLRecord) <-chan XMLRecord {
+// CreateFetchers returns uncompressed records from archive, multithreaded for speed.
+// After ctx is cancelled, remaining identifiers are drained and returned as empty
+// records without reading the archive, keeping upstream and unshuffler unblocked.
+// If SetArchiveFill registered a source for db, missing XML records are retrieved,
+// stashed, and returned.
+func CreateFetchers(ctx context.Context, stsh, db, pfx, sfx, ptrn string, zipp bool, inp <-chan XMLRecord) <-chan XMLRecord {
 
 	if inp == nil || stsh == "" {
 		return nil
@@ -887,6 +1008,30 @@ func CreateFetchers(stsh, db, pfx, sfx, ptrn string, zipp bool, inp <-chan XMLRe
 		os.Exit(1)
 	}
 
+	fill := getArchiveFill(db)
+	if sfx != ".xml" || ptrn == "" {
+		fill = nil
+	}
+
+	var filler *archiveFiller
+	var fillDone <-chan struct{}
+	var fillOnce sync.Once
+
+	// start read-through filler on first missing record
+	missing := func(id string) string {
+
+		fillOnce.Do(func() {
+			filler, fillDone = newArchiveFiller(fill, stsh, db, pfx, sfx, ptrn, zipp)
+		})
+
+		if pos := strings.Index(id, "."); pos >= 0 {
+			// remove version suffix
+			id = id[:pos]
+		}
+
+		return filler.fetch(ctx, strings.TrimPrefix(id, pfx))
+	}
+
 	// xmlFetcher reads XML from file
 	xmlFetcher := func(wg *sync.WaitGroup, inp <-chan XMLRecord, out chan<- XMLRecord) {
 
@@ -897,10 +1042,19 @@ func CreateFetchers(stsh, db, pfx, sfx, ptrn string, zipp bool, inp <-chan XMLRe
 
 		for ext := range inp {
 
+			if ctx.Err() != nil {
+				out <- XMLRecord{Index: ext.Index, Ident: ext.Ident}
+				continue
+			}
+
 			buf.Reset()
 
 			str := fetchOneXMLRecord(ext.Text, stsh, pfx, sfx, zipp, buf)
 
+			if str == "" && fill != nil && ext.Text != "" {
+				str = missing(ext.Text)
+			}
+
 			// trim any header included in archive XML file
 			if str != "" && ptrn != "" {
 				pos := strings.Index(str, "<"+ptrn+">")
@@ -927,6 +1081,11 @@ func CreateFetchers(stsh, db, pfx, sfx, ptrn string, zipp bool, inp <-chan XMLRe
 	// launch separate anonymous goroutine to wait until all fetchers are done
 	go func() {
 		wg.Wait()
+		if filler != nil {
+			// wait for retrieved records to be saved
+			filler.close()
+			<-fillDone
+		}
 		close(out)
 	}()
 
@@ -934,8 +1093,10 @@ func CreateFetchers(stsh, db, pfx, sfx, ptrn string, zipp bool, inp <-chan XMLRe
 }
 
 // CreateCacheStreamers returns compressed records from archive, multithreaded for speed,
-// could be used for sending records over network to be decompressed later by client
-func CreateCacheStreamers(stsh, db, pfx, sfx string, skip int, inp <-chan XMLRecord) <-chan XMLRecord {
+// could be used for sending records over network to be decompressed later by client,
+// recompresses records stored with a different codec than requested, and, like
+// CreateFetchers, stops reading the archive once ctx is cancelled
+func CreateCacheStreamers(ctx context.Context, stsh, db, pfx, sfx string, skip int, codec string, inp <-chan XMLRecord) <-chan XMLRecord {
 
 	if inp == nil || stsh == "" {
 		return nil
@@ -964,45 +1125,36 @@ func CreateCacheStreamers(stsh, db, pfx, sfx string, skip int, inp <-chan XMLRec
 		DisplayError("Unable to create streamer channel")
 		os.Exit(1)
 	}
-	sfx += ".gz"
-
-	getRecord := func(id string, buf bytes.Buffer) []byte {
 
-		if id == "" {
-			return nil
-		}
+	if codec == CodecNone {
+		codec = CodecGzip
+	}
 
-		dir, file := ArchiveTrie(id)
+	store := OpenArchiveStore(stsh)
 
-		if dir == "" || file == "" {
-			return nil
-		}
+	getRecord := func(id string) []byte {
 
-		fpath := filepath.Join(stsh, dir, pfx+file+sfx)
-		if fpath == "" {
+		if id == "" {
 			return nil
 		}
 
-		inFile, err := os.Open(fpath)
-
-		if err != nil {
-			msg := err.Error()
-			if !strings.HasSuffix(msg, "no such file or directory") && !strings.HasSuffix(msg, "cannot find the path specified.") {
-				fmt.Fprintf(os.Stderr, "%s\n", msg)
+		// look for compressed record in either format
+		for _, ext := range codecSuffixes {
+			key := ArchiveKey(id, pfx, sfx+ext)
+			if key == "" {
+				return nil
+			}
+			data, err := store.Get(key)
+			if err == nil {
+				return data
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				return nil
 			}
-			return nil
 		}
 
-		brd := bufio.NewReader(inFile)
-
-		// copy cached file contents
-		buf.ReadFrom(brd)
-
-		data := buf.Bytes()
-
-		inFile.Close()
-
-		return data
+		return nil
 	}
 
 	// xmlStreamer reads compressed XML from file
@@ -1011,24 +1163,28 @@ func CreateCacheStreamers(stsh, db, pfx, sfx string, skip int, inp <-chan XMLRec
 		// report when more records to process
 		defer wg.Done()
 
-		var buf bytes.Buffer
-
 		for ext := range inp {
 
-			buf.Reset()
+			if ctx.Err() != nil {
+				out <- XMLRecord{Index: ext.Index, Ident: ext.Ident}
+				continue
+			}
 
-			data := getRecord(ext.Text, buf)
+			data := getRecord(ext.Text)
 
 			runtime.Gosched()
 
-			// skip past first gzip packet with xml and DOCTYPE
-			if skip > 0 {
-				if len(data) > skip {
-					data = data[skip:]
-				}
-			} else if db == "pubmed" {
-				if len(data) > XMLDoctypeGzipLen {
-					data = data[XMLDoctypeGzipLen:]
+			// skip past first compressed packet with xml and DOCTYPE
+			data = skipRecordHeader(data, db, skip)
+
+			if data != nil && DetectCodec(data) != codec {
+				// convert to requested codec
+				txt, err := DecompressRecord(stsh, data)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+					data = nil
+				} else {
+					data = CompressString(stsh, codec, string(txt))
 				}
 			}
 
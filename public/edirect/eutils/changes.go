@@ -0,0 +1,349 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  changes.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"encoding/xml"
+	"slices"
+	"strings"
+)
+
+// PUBMED UPDATE CHANGE REPORT
+
+// CompareArticles summarizes how an incoming PubmedArticle differs from the archived
+// copy, for review of daily updates by curators. Title, abstract, author, MeSH,
+// publication status, retraction, and erratum differences are reported separately,
+// with any remaining difference reported as "other".
+
+// change categories, in report order
+const (
+	ChangeTitle      = "title"
+	ChangeAbstract   = "abstract"
+	ChangeAuthors    = "authors"
+	ChangeMeSH       = "mesh"
+	ChangePubStatus  = "pubstatus"
+	ChangeRetraction = "retraction"
+	ChangeErratum    = "erratum"
+	ChangeOther      = "other"
+)
+
+// ValueChange holds the archived and incoming values of a single element
+type ValueChange struct {
+	Old string `xml:"Old" json:"old"`
+	New string `xml:"New" json:"new"`
+}
+
+// ListChange holds entries present in only one version of a list
+type ListChange struct {
+	Added   []string `xml:"Added,omitempty" json:"added,omitempty"`
+	Removed []string `xml:"Removed,omitempty" json:"removed,omitempty"`
+}
+
+// RecordChange reports the changed elements of one record
+type RecordChange struct {
+	XMLName    xml.Name     `xml:"Change" json:"-"`
+	PMID       string       `xml:"PMID" json:"pmid"`
+	Status     string       `xml:"Status" json:"status"`
+	Elements   []string     `xml:"Elements>Element,omitempty" json:"elements,omitempty"`
+	Title      *ValueChange `xml:"Title,omitempty" json:"title,omitempty"`
+	Authors    *ListChange  `xml:"Authors,omitempty" json:"authors,omitempty"`
+	MeSH       *ListChange  `xml:"MeSH,omitempty" json:"mesh,omitempty"`
+	PubStatus  *ValueChange `xml:"PubStatus,omitempty" json:"pubstatus,omitempty"`
+	Retraction *ListChange  `xml:"Retraction,omitempty" json:"retraction,omitempty"`
+	Erratum    *ListChange  `xml:"Erratum,omitempty" json:"erratum,omitempty"`
+}
+
+// articleSummary holds the elements of a PubmedArticle that are compared individually
+type articleSummary struct {
+	title      string
+	titleXML   string
+	abstract   string
+	authors    []string
+	authorXML  string
+	mesh       []string
+	pubStatus  string
+	retraction []string
+	erratum    []string
+}
+
+// rawElements returns the concatenated XML of all non-nested occurrences of an element
+func rawElements(text, tag string) string {
+
+	var buffer strings.Builder
+
+	removeElements(text, tag, func(str string) {
+		buffer.WriteString(str)
+	})
+
+	return buffer.String()
+}
+
+// removeElements returns the text without any occurrences of an element, passing each
+// removed occurrence to an optional callback
+func removeElements(text, tag string, proc func(string)) string {
+
+	var buffer strings.Builder
+
+	open := "<" + tag
+	shut := "</" + tag + ">"
+
+	for {
+		pos := strings.Index(text, open)
+		if pos < 0 {
+			break
+		}
+		rest := text[pos+len(open):]
+		gt := strings.Index(rest, ">")
+		if gt < 0 {
+			break
+		}
+		if gt > 0 && rest[0] != ' ' && rest[0] != '/' {
+			// longer element name with same prefix
+			buffer.WriteString(text[:pos+len(open)])
+			text = rest
+			continue
+		}
+		end := gt + 1
+		if gt == 0 || rest[gt-1] != '/' {
+			// not self-closing, find end tag
+			idx := strings.Index(rest, shut)
+			if idx < 0 {
+				break
+			}
+			end = idx + len(shut)
+		}
+		buffer.WriteString(text[:pos])
+		if proc != nil {
+			proc(text[pos : pos+len(open)+end])
+		}
+		text = rest[end:]
+	}
+
+	buffer.WriteString(text)
+
+	return buffer.String()
+}
+
+// markupFree removes all tags and collapses spaces
+func markupFree(str string) string {
+
+	var buffer strings.Builder
+
+	inTag := false
+	for _, ch := range str {
+		switch {
+		case ch == '<':
+			inTag = true
+		case ch == '>':
+			inTag = false
+		case !inTag:
+			buffer.WriteRune(ch)
+		}
+	}
+
+	return strings.Join(strings.Fields(buffer.String()), " ")
+}
+
+// comparedElements are reported individually, other differences are reported together
+var comparedElements = []string{
+	"ArticleTitle", "VernacularTitle", "Abstract", "OtherAbstract", "AuthorList",
+	"MeshHeadingList", "PublicationStatus", "CommentsCorrectionsList", "PublicationTypeList",
+}
+
+// eachElement passes the XML of each occurrence of an element to a callback
+func eachElement(text, tag string, proc func(string)) {
+
+	removeElements(text, tag, proc)
+}
+
+// elementText returns the text of the first occurrence of an element, without markup
+func elementText(text, tag string) string {
+
+	res := ""
+
+	eachElement(text, tag, func(str string) {
+		if res == "" {
+			res = markupFree(str)
+		}
+	})
+
+	return res
+}
+
+// summarizeArticle extracts the compared elements of a PubmedArticle, using string
+// searches so that unconverted embedded HTML tags are tolerated
+func summarizeArticle(text string) articleSummary {
+
+	var sum articleSummary
+
+	sum.titleXML = rawElements(text, "ArticleTitle") + rawElements(text, "VernacularTitle")
+	sum.title = elementText(text, "ArticleTitle")
+	sum.abstract = rawElements(text, "Abstract") + rawElements(text, "OtherAbstract")
+	sum.authorXML = rawElements(text, "AuthorList")
+
+	eachElement(sum.authorXML, "Author", func(auth string) {
+
+		name := elementText(auth, "CollectiveName")
+		if name == "" {
+			name = strings.TrimSpace(elementText(auth, "LastName") + " " + elementText(auth, "Initials"))
+		}
+		if name != "" {
+			sum.authors = append(sum.authors, name)
+		}
+	})
+
+	eachElement(rawElements(text, "MeshHeadingList"), "MeshHeading", func(mesh string) {
+
+		name := elementText(mesh, "DescriptorName")
+		if name == "" {
+			return
+		}
+		sum.mesh = append(sum.mesh, name)
+		eachElement(mesh, "QualifierName", func(str string) {
+			sum.mesh = append(sum.mesh, name+"/"+markupFree(str))
+		})
+	})
+
+	sum.pubStatus = elementText(text, "PublicationStatus")
+
+	eachElement(rawElements(text, "CommentsCorrectionsList"), "CommentsCorrections", func(cc string) {
+
+		ref := ""
+		if _, after, ok := strings.Cut(cc, `RefType="`); ok {
+			ref, _, _ = strings.Cut(after, `"`)
+		}
+		entry := strings.TrimSpace(ref + " " + elementText(cc, "PMID"))
+
+		switch ref {
+		case "RetractionIn", "RetractionOf", "ExpressionOfConcernIn", "ExpressionOfConcernFor":
+			sum.retraction = append(sum.retraction, entry)
+		case "ErratumIn", "ErratumFor":
+			sum.erratum = append(sum.erratum, entry)
+		}
+	})
+
+	eachElement(rawElements(text, "PublicationTypeList"), "PublicationType", func(pt string) {
+
+		switch str := markupFree(pt); str {
+		case "Retracted Publication", "Retraction of Publication", "Expression of Concern":
+			sum.retraction = append(sum.retraction, str)
+		case "Published Erratum":
+			sum.erratum = append(sum.erratum, str)
+		}
+	})
+
+	return sum
+}
+
+// compareLists returns entries present in only one of two lists, or nil if they match
+func compareLists(prev, next []string) *ListChange {
+
+	var lc ListChange
+
+	for _, str := range next {
+		if !slices.Contains(prev, str) {
+			lc.Added = append(lc.Added, str)
+		}
+	}
+	for _, str := range prev {
+		if !slices.Contains(next, str) {
+			lc.Removed = append(lc.Removed, str)
+		}
+	}
+
+	if lc.Added == nil && lc.Removed == nil {
+		return nil
+	}
+
+	return &lc
+}
+
+// CompareArticles reports changes between archived and incoming versions of a
+// PubmedArticle, where an empty archived version indicates a new record, and where
+// differences inside an optional ignore element are not reported
+func CompareArticles(id, prev, next, ignore string) RecordChange {
+
+	rc := RecordChange{PMID: id, Status: "updated"}
+
+	if prev == "" {
+		rc.Status = "new"
+		return rc
+	}
+
+	old := summarizeArticle(prev)
+	nxt := summarizeArticle(next)
+
+	if old.titleXML != nxt.titleXML {
+		rc.Elements = append(rc.Elements, ChangeTitle)
+		rc.Title = &ValueChange{Old: old.title, New: nxt.title}
+	}
+
+	if old.abstract != nxt.abstract {
+		rc.Elements = append(rc.Elements, ChangeAbstract)
+	}
+
+	if old.authorXML != nxt.authorXML {
+		// also flags reordering and affiliation changes, which leave the name lists equal
+		rc.Elements = append(rc.Elements, ChangeAuthors)
+		rc.Authors = compareLists(old.authors, nxt.authors)
+	}
+
+	if lc := compareLists(old.mesh, nxt.mesh); lc != nil {
+		rc.Elements = append(rc.Elements, ChangeMeSH)
+		rc.MeSH = lc
+	}
+
+	if old.pubStatus != nxt.pubStatus {
+		rc.Elements = append(rc.Elements, ChangePubStatus)
+		rc.PubStatus = &ValueChange{Old: old.pubStatus, New: nxt.pubStatus}
+	}
+
+	if lc := compareLists(old.retraction, nxt.retraction); lc != nil {
+		rc.Elements = append(rc.Elements, ChangeRetraction)
+		rc.Retraction = lc
+	}
+
+	if lc := compareLists(old.erratum, nxt.erratum); lc != nil {
+		rc.Elements = append(rc.Elements, ChangeErratum)
+		rc.Erratum = lc
+	}
+
+	// compare remainder of records
+	for _, tag := range append(comparedElements, ignore) {
+		if tag != "" {
+			prev = removeElements(prev, tag, nil)
+			next = removeElements(next, tag, nil)
+		}
+	}
+	if prev != next {
+		rc.Elements = append(rc.Elements, ChangeOther)
+	}
+
+	return rc
+}
@@ -0,0 +1,170 @@
+package eutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRemoveElements(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		text    string
+		tag     string
+		want    string
+		removed []string
+	}{
+		{"simple", "<A><B>x</B><C>y</C></A>", "B", "<A><C>y</C></A>", []string{"<B>x</B>"}},
+		{"attributes", `<A><B id="1">x</B></A>`, "B", "<A></A>", []string{`<B id="1">x</B>`}},
+		{"repeated", "<B>x</B>-<B>y</B>", "B", "-", []string{"<B>x</B>", "<B>y</B>"}},
+		{"self-closing", "<A><B/><B id=\"2\"/></A>", "B", "<A></A>", []string{"<B/>", `<B id="2"/>`}},
+		{"longer name kept", "<Abstract><AbstractText>x</AbstractText></Abstract><AbstractNote/>", "Abstract",
+			"<AbstractNote/>", []string{"<Abstract><AbstractText>x</AbstractText></Abstract>"}},
+		{"only longer name", "<AbstractText>x</AbstractText>", "Abstract", "<AbstractText>x</AbstractText>", nil},
+		{"absent", "<A>x</A>", "B", "<A>x</A>", nil},
+		{"unterminated", "<A><B>x</A>", "B", "<A><B>x</A>", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var removed []string
+			got := removeElements(tt.text, tt.tag, func(str string) {
+				removed = append(removed, str)
+			})
+			if got != tt.want {
+				t.Errorf("removeElements = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("removed %q, want %q", removed, tt.removed)
+			}
+		})
+	}
+}
+
+// testArticle is a PubmedArticle whose parts can be replaced one at a time
+const testArticle = `<PubmedArticle><MedlineCitation Status="MEDLINE"><PMID Version="1">2539356</PMID>` +
+	`<DateRevised><Year>2019</Year><Month>02</Month><Day>08</Day></DateRevised>` +
+	`<Article><Journal><Title>Cell</Title></Journal>` +
+	`<ArticleTitle>Tn3 transposition immunity</ArticleTitle>` +
+	`<Abstract><AbstractText>Immunity is conferred by the ends.</AbstractText></Abstract>` +
+	`<AuthorList><Author><LastName>Lee</LastName><Initials>CH</Initials><AffiliationInfo><Affiliation>MIT</Affiliation></AffiliationInfo></Author>` +
+	`<Author><LastName>Bhagwat</LastName><Initials>A</Initials></Author></AuthorList>` +
+	`<PublicationTypeList><PublicationType UI="D016428">Journal Article</PublicationType></PublicationTypeList></Article>` +
+	`<CommentsCorrectionsList><CommentsCorrections RefType="CommentIn"><RefSource>Cell 1989</RefSource><PMID Version="1">2600000</PMID></CommentsCorrections></CommentsCorrectionsList>` +
+	`<MeshHeadingList><MeshHeading><DescriptorName UI="D004269">DNA Transposable Elements</DescriptorName>` +
+	`<QualifierName UI="Q000235">genetics</QualifierName></MeshHeading></MeshHeadingList></MedlineCitation>` +
+	`<PubmedData><PublicationStatus>ppublish</PublicationStatus></PubmedData></PubmedArticle>`
+
+func TestCompareArticles(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		old    string
+		new    string
+		ignore string
+		want   RecordChange
+	}{
+		{"unchanged", "", "", "", RecordChange{}},
+		{"title",
+			"<ArticleTitle>Tn3 transposition immunity</ArticleTitle>",
+			"<ArticleTitle>Tn3 <i>transposition</i> immunity.</ArticleTitle>", "",
+			RecordChange{Elements: []string{ChangeTitle}, Title: &ValueChange{Old: "Tn3 transposition immunity", New: "Tn3 transposition immunity."}}},
+		{"vernacular title",
+			"<ArticleTitle>Tn3 transposition immunity</ArticleTitle>",
+			"<ArticleTitle>Tn3 transposition immunity</ArticleTitle><VernacularTitle>Immunite</VernacularTitle>", "",
+			RecordChange{Elements: []string{ChangeTitle}, Title: &ValueChange{Old: "Tn3 transposition immunity", New: "Tn3 transposition immunity"}}},
+		{"abstract text",
+			"Immunity is conferred by the ends.",
+			"Immunity is conferred by the terminal repeats.", "",
+			RecordChange{Elements: []string{ChangeAbstract}}},
+		{"abstract removed",
+			"<Abstract><AbstractText>Immunity is conferred by the ends.</AbstractText></Abstract>",
+			"<Abstract/>", "",
+			RecordChange{Elements: []string{ChangeAbstract}}},
+		{"author added",
+			"</AuthorList>",
+			"<Author><CollectiveName>Tn3 Consortium</CollectiveName></Author></AuthorList>", "",
+			RecordChange{Elements: []string{ChangeAuthors}, Authors: &ListChange{Added: []string{"Tn3 Consortium"}}}},
+		{"author renamed",
+			"<LastName>Bhagwat</LastName>",
+			"<LastName>Bhagwatt</LastName>", "",
+			RecordChange{Elements: []string{ChangeAuthors}, Authors: &ListChange{Added: []string{"Bhagwatt A"}, Removed: []string{"Bhagwat A"}}}},
+		{"affiliation only",
+			"<Affiliation>MIT</Affiliation>",
+			"<Affiliation>Massachusetts Institute of Technology</Affiliation>", "",
+			RecordChange{Elements: []string{ChangeAuthors}}},
+		{"mesh qualifier",
+			`<QualifierName UI="Q000235">genetics</QualifierName>`,
+			`<QualifierName UI="Q000378">metabolism</QualifierName>`, "",
+			RecordChange{Elements: []string{ChangeMeSH}, MeSH: &ListChange{Added: []string{"DNA Transposable Elements/metabolism"}, Removed: []string{"DNA Transposable Elements/genetics"}}}},
+		{"mesh attribute only",
+			`<DescriptorName UI="D004269">`,
+			`<DescriptorName UI="D004269" MajorTopicYN="Y">`, "",
+			RecordChange{}},
+		{"publication status",
+			"<PublicationStatus>ppublish</PublicationStatus>",
+			"<PublicationStatus>epublish</PublicationStatus>", "",
+			RecordChange{Elements: []string{ChangePubStatus}, PubStatus: &ValueChange{Old: "ppublish", New: "epublish"}}},
+		{"retraction notice",
+			"</CommentsCorrectionsList>",
+			`<CommentsCorrections RefType="RetractionIn"><RefSource>Cell 2024</RefSource><PMID Version="1">38000000</PMID></CommentsCorrections></CommentsCorrectionsList>`, "",
+			RecordChange{Elements: []string{ChangeRetraction}, Retraction: &ListChange{Added: []string{"RetractionIn 38000000"}}}},
+		{"retracted publication type",
+			"</PublicationTypeList>",
+			`<PublicationType UI="D016441">Retracted Publication</PublicationType></PublicationTypeList>`, "",
+			RecordChange{Elements: []string{ChangeRetraction}, Retraction: &ListChange{Added: []string{"Retracted Publication"}}}},
+		{"erratum",
+			"</CommentsCorrectionsList>",
+			`<CommentsCorrections RefType="ErratumIn"><RefSource>Cell 1990</RefSource><PMID Version="1">2700000</PMID></CommentsCorrections></CommentsCorrectionsList>`, "",
+			RecordChange{Elements: []string{ChangeErratum}, Erratum: &ListChange{Added: []string{"ErratumIn 2700000"}}}},
+		{"comment is not reported separately",
+			`<CommentsCorrections RefType="CommentIn"><RefSource>Cell 1989</RefSource>`,
+			`<CommentsCorrections RefType="CommentIn"><RefSource>Cell 1989;57</RefSource>`, "",
+			RecordChange{}},
+		{"other",
+			"<Title>Cell</Title>",
+			"<Title>Cell (Cambridge)</Title>", "",
+			RecordChange{Elements: []string{ChangeOther}}},
+		{"revision date",
+			"<Day>08</Day>",
+			"<Day>09</Day>", "",
+			RecordChange{Elements: []string{ChangeOther}}},
+		{"revision date ignored",
+			"<Day>08</Day>",
+			"<Day>09</Day>", "DateRevised",
+			RecordChange{}},
+		{"ignore does not hide other changes",
+			"<Title>Cell</Title>",
+			"<Title>Cell (Cambridge)</Title>", "DateRevised",
+			RecordChange{Elements: []string{ChangeOther}}},
+		{"several categories",
+			"<PublicationStatus>ppublish</PublicationStatus></PubmedData>",
+			"<PublicationStatus>epublish</PublicationStatus><ReferenceList/></PubmedData>", "",
+			RecordChange{Elements: []string{ChangePubStatus, ChangeOther}, PubStatus: &ValueChange{Old: "ppublish", New: "epublish"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := testArticle
+			if tt.old != "" {
+				if !strings.Contains(next, tt.old) {
+					t.Fatalf("test article does not contain %q", tt.old)
+				}
+				next = strings.Replace(next, tt.old, tt.new, 1)
+			}
+			want := tt.want
+			want.PMID = "2539356"
+			want.Status = "updated"
+			got := CompareArticles("2539356", testArticle, next, tt.ignore)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("CompareArticles = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	got := CompareArticles("2539356", "", testArticle, "")
+	if got.Status != "new" || got.Elements != nil {
+		t.Errorf("CompareArticles for new record = %+v", got)
+	}
+}
@@ -0,0 +1,182 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  chunk.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NOUN PHRASE CHUNKING
+
+// NounPhrases is a simple rule-based chunker for the PHRS field. Text is broken into
+// runs of words at punctuation, numbers, stop words, common reporting verbs, and adverbs.
+// A run cannot end in a past participle, which usually follows its noun. English noun
+// phrases put the head noun last, so every trailing part of a run is also a noun phrase,
+// and each is returned, from two words up to the word limit. Thus "nucleotide sequences
+// required for tn3 transposition immunity" gives "nucleotide sequences", "transposition
+// immunity", and "tn3 transposition immunity".
+
+// PhraseWords is the default maximum number of words in a PHRS term
+const PhraseWords = 4
+
+// verbs frequently found between noun phrases in scientific abstracts
+var isPhraseBreak = map[string]bool{
+	"affect":       true,
+	"affects":      true,
+	"cause":        true,
+	"caused":       true,
+	"causes":       true,
+	"confirm":      true,
+	"confirmed":    true,
+	"confirms":     true,
+	"contain":      true,
+	"contains":     true,
+	"decrease":     true,
+	"decreases":    true,
+	"demonstrate":  true,
+	"demonstrated": true,
+	"demonstrates": true,
+	"enhance":      true,
+	"enhances":     true,
+	"improve":      true,
+	"improves":     true,
+	"increase":     true,
+	"increases":    true,
+	"indicate":     true,
+	"indicated":    true,
+	"indicates":    true,
+	"induce":       true,
+	"induces":      true,
+	"inhibit":      true,
+	"inhibits":     true,
+	"involve":      true,
+	"involves":     true,
+	"mediate":      true,
+	"mediates":     true,
+	"promote":      true,
+	"promotes":     true,
+	"reduce":       true,
+	"reduces":      true,
+	"regulate":     true,
+	"regulates":    true,
+	"require":      true,
+	"requires":     true,
+	"reveal":       true,
+	"revealed":     true,
+	"reveals":      true,
+	"show":         true,
+	"showed":       true,
+	"shown":        true,
+	"shows":        true,
+	"suggest":      true,
+	"suggested":    true,
+	"suggests":     true,
+	"versus":       true,
+	"vs":           true,
+}
+
+// adverb endings, chosen to avoid nouns like family, assembly, and anomaly
+var adverbEndings = []string{
+	"ably", "ally", "antly", "arly", "edly", "ently", "fully",
+	"ghly", "ibly", "ingly", "ively", "ously", "sely", "tely",
+}
+
+func isAdverb(str string) bool {
+
+	if len(str) < 5 {
+		return false
+	}
+
+	for _, sfx := range adverbEndings {
+		if strings.HasSuffix(str, sfx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isParticiple detects words ending in -ed that cannot be the head of a phrase
+func isParticiple(str string) bool {
+
+	return len(str) > 4 && strings.HasSuffix(str, "ed") && !strings.HasSuffix(str, "eed")
+}
+
+// NounPhrases returns multi-word noun phrases of up to maxWords words from lower-case text
+func NounPhrases(str string, maxWords int) []string {
+
+	if str == "" {
+		return nil
+	}
+
+	if maxWords < 2 {
+		maxWords = PhraseWords
+	}
+
+	var res []string
+
+	addRun := func(run []string) {
+
+		// trailing participles modify a preceding noun, and cannot end a phrase
+		for len(run) > 0 && isParticiple(run[len(run)-1]) {
+			run = run[:len(run)-1]
+		}
+
+		// every suffix of two or more words, up to the limit
+		for num := 2; num <= maxWords && num <= len(run); num++ {
+			res = append(res, strings.Join(run[len(run)-num:], " "))
+		}
+	}
+
+	// break clauses at punctuation other than space, and at non-ASCII characters
+	clauses := strings.FieldsFunc(str, func(c rune) bool {
+		return (!unicode.IsLetter(c) && !unicode.IsDigit(c)) && c != ' ' || c > 127
+	})
+
+	for _, cls := range clauses {
+
+		var run []string
+
+		for _, item := range strings.Fields(strings.ToLower(cls)) {
+
+			if IsStopWord(item) || isPhraseBreak[item] || isAdverb(item) || IsAllDigits(item) {
+				addRun(run)
+				run = nil
+				continue
+			}
+
+			run = append(run, item)
+		}
+
+		addRun(run)
+	}
+
+	return res
+}
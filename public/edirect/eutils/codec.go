@@ -0,0 +1,339 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  codec.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RECORD COMPRESSION CODECS
+
+// Archived records are stored uncompressed, as gzip (".gz"), or as zstd (".zst"). The
+// codec is chosen when stashing, but readers recognize each file by its leading magic
+// bytes, so archives that mix codecs, e.g., during a gradual migration, still work.
+//
+// An archive may have a zstd dictionary, trained on sample records by "rchive -dictionary"
+// and saved in its top-level ZstdDictionary file. Small records compress much better
+// with a shared dictionary. Stashed zstd records use it, and readers load it to decode
+// them. Once records depend on a dictionary it must not be replaced.
+
+// codec names
+const (
+	CodecNone = ""
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// ZstdDictionary is the name of the dictionary file in the archive directory
+const ZstdDictionary = "zstd.dict"
+
+// suffixes added after ".xml", ".asn", or ".e2x"
+var codecSuffixes = []string{".gz", ".zst"}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CodecSuffix returns the file suffix for a codec
+func CodecSuffix(codec string) string {
+
+	switch codec {
+	case CodecGzip:
+		return ".gz"
+	case CodecZstd:
+		return ".zst"
+	}
+
+	return ""
+}
+
+// KeyCodec returns the codec implied by the suffix of an archive key
+func KeyCodec(key string) string {
+
+	switch {
+	case strings.HasSuffix(key, ".gz"):
+		return CodecGzip
+	case strings.HasSuffix(key, ".zst"):
+		return CodecZstd
+	}
+
+	return CodecNone
+}
+
+// DetectCodec identifies compressed data by its magic number
+func DetectCodec(data []byte) string {
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		return CodecGzip
+	}
+	if bytes.HasPrefix(data, zstdMagic) {
+		return CodecZstd
+	}
+
+	return CodecNone
+}
+
+// recordCodec holds the shared zstd encoder and decoder for one archive, both of which
+// are safe for concurrent use with EncodeAll and DecodeAll
+type recordCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+var (
+	codecLock sync.Mutex
+	codecMap  = make(map[string]*recordCodec)
+)
+
+// archiveCodec returns the zstd codec for an archive directory, loading its dictionary
+// if present, or a dictionary-free codec if stsh is empty
+func archiveCodec(stsh string) *recordCodec {
+
+	if stsh != "" {
+		stsh = filepath.Clean(stsh)
+	}
+
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	rc, ok := codecMap[stsh]
+	if ok {
+		return rc
+	}
+
+	eopts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderConcurrency(1)}
+	dopts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+
+	if stsh != "" {
+		dct, err := os.ReadFile(filepath.Join(stsh, ZstdDictionary))
+		if err == nil {
+			eopts = append(eopts, zstd.WithEncoderDict(dct))
+			dopts = append(dopts, zstd.WithDecoderDicts(dct))
+		} else if !os.IsNotExist(err) {
+			DisplayError("Unable to read zstd dictionary: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err == nil {
+		var dec *zstd.Decoder
+		dec, err = zstd.NewReader(nil, dopts...)
+		rc = &recordCodec{encoder: enc, decoder: dec}
+	}
+	if err != nil {
+		DisplayError("Unable to create zstd codec: %s", err.Error())
+		os.Exit(1)
+	}
+
+	codecMap[stsh] = rc
+
+	return rc
+}
+
+// CompressString compresses a record with the given codec, using the archive's zstd
+// dictionary if it has one
+func CompressString(stsh, codec, str string) []byte {
+
+	switch codec {
+	case CodecGzip:
+		return GzipString(str)
+	case CodecZstd:
+		if str == "" {
+			return nil
+		}
+		return archiveCodec(stsh).encoder.EncodeAll([]byte(str), nil)
+	}
+
+	return []byte(str)
+}
+
+// DecompressRecord expands stored record data, detecting the codec, and concatenating
+// the contents of multiple gzip members or zstd frames
+func DecompressRecord(stsh string, data []byte) ([]byte, error) {
+
+	switch DetectCodec(data) {
+	case CodecGzip:
+		zpr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zpr.Close()
+		return io.ReadAll(zpr)
+	case CodecZstd:
+		return archiveCodec(stsh).decoder.DecodeAll(data, nil)
+	}
+
+	return data, nil
+}
+
+// zstdFrameLen returns the length of the first zstd frame in data, or 0 if it is damaged
+func zstdFrameLen(data []byte) int {
+
+	if len(data) < 5 || !bytes.HasPrefix(data, zstdMagic) {
+		return 0
+	}
+
+	desc := data[4]
+	single := desc&0x20 != 0
+	pos := 5
+
+	if !single {
+		// window descriptor
+		pos++
+	}
+	pos += []int{0, 1, 2, 4}[desc&0x03]
+	switch desc >> 6 {
+	case 0:
+		if single {
+			pos++
+		}
+	case 1:
+		pos += 2
+	case 2:
+		pos += 4
+	case 3:
+		pos += 8
+	}
+
+	for pos+3 <= len(data) {
+		hdr := uint32(data[pos]) | uint32(data[pos+1])<<8 | uint32(data[pos+2])<<16
+		pos += 3
+		last := hdr&1 != 0
+		size := int(hdr >> 3)
+		switch (hdr >> 1) & 3 {
+		case 1:
+			// RLE block stores one byte
+			pos++
+		case 3:
+			return 0
+		default:
+			pos += size
+		}
+		if last {
+			if desc&0x04 != 0 {
+				// content checksum
+				pos += 4
+			}
+			if pos > len(data) {
+				return 0
+			}
+			return pos
+		}
+	}
+
+	return 0
+}
+
+// skipRecordHeader removes the separately-compressed xml and DOCTYPE header that precedes
+// stashed PubmedArticle records, using skip for gzip if supplied
+func skipRecordHeader(data []byte, db string, skip int) []byte {
+
+	switch DetectCodec(data) {
+	case CodecGzip:
+		if skip > 0 {
+			if len(data) > skip {
+				return data[skip:]
+			}
+		} else if db == "pubmed" {
+			if len(data) > XMLDoctypeGzipLen {
+				return data[XMLDoctypeGzipLen:]
+			}
+		}
+	case CodecZstd:
+		if db == "pubmed" {
+			n := zstdFrameLen(data)
+			if n > 0 && n < len(data) {
+				return data[n:]
+			}
+		}
+	}
+
+	return data
+}
+
+// TrainZstdDictionary builds a dictionary from sample records and saves it in the archive
+// directory, refusing to replace an existing dictionary that records may depend on
+func TrainZstdDictionary(stsh string, samples [][]byte) error {
+
+	fpath := filepath.Join(stsh, ZstdDictionary)
+
+	_, err := os.Stat(fpath)
+	if err == nil {
+		return fmt.Errorf("%s already exists", fpath)
+	}
+
+	if len(samples) < 100 {
+		return errors.New("at least 100 sample records are needed")
+	}
+
+	// 112 KB is the default size of the zstd command-line trainer
+	dct, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 112640, HashBytes: 6, ZstdLevel: zstd.SpeedBetterCompression})
+	if err != nil {
+		return err
+	}
+
+	// check that the dictionary can be loaded before saving it
+	_, err = zstd.NewWriter(nil, zstd.WithEncoderDict(dct))
+	if err != nil {
+		return err
+	}
+
+	// write complete file before it becomes visible
+	tmp := fpath + ".tmp"
+	err = os.WriteFile(tmp, dct, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fpath)
+}
+
+// ZstdDictionaryID returns the identifier of an archive's dictionary, or 0 if it has none
+func ZstdDictionaryID(stsh string) uint32 {
+
+	dct, err := os.ReadFile(filepath.Join(stsh, ZstdDictionary))
+	if err != nil || len(dct) < 8 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(dct[4:8])
+}
@@ -0,0 +1,350 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  deleted.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DELETED RECORD SET
+
+// Records deleted by daily updates are removed from the archive and incremental index
+// files right away, but promoted postings keep returning them until they are rebuilt.
+// CreateDeleter appends their identifiers to a DELETED file at the top of the Postings
+// directory, and query results have them subtracted. CreatePromoters also leaves them
+// out of new postings, and notes which identifiers it left out, after which a completed
+// rebuild can fold those identifiers out of the set. A deleted record that is stashed
+// again is taken out of the set right away.
+
+// DeletedSetName is the name of the deleted identifier file in the Postings directory
+const DeletedSetName = "DELETED"
+
+// DeletedAppliedName lists the deleted identifiers that rebuilt postings leave out
+const DeletedAppliedName = "DELETED.applied"
+
+// deletedLockName serializes changes to the deleted set across processes
+const deletedLockName = "DELETED.lock"
+
+func deletedSetPath(db string) string {
+
+	base, _ := GetLocalArchivePaths(db)
+	if base == "" {
+		return ""
+	}
+
+	return filepath.Join(base+"Postings", DeletedSetName)
+}
+
+// deletedSet caches the sorted identifiers, reloading them when the file changes
+type deletedSet struct {
+	size int64
+	when time.Time
+	uids []int32
+}
+
+var (
+	deletedLock sync.Mutex
+	deletedMap  = make(map[string]*deletedSet)
+)
+
+// RecordDeleted appends deleted identifiers to the postings deleted set
+func RecordDeleted(db string, ids []string) error {
+
+	fpath := deletedSetPath(db)
+	if fpath == "" || len(ids) < 1 {
+		return nil
+	}
+
+	// no postings yet means there is nothing to hide
+	if _, err := os.Stat(filepath.Dir(fpath)); err != nil {
+		return nil
+	}
+
+	unlock, err := lockDeletedSet(fpath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var buffer strings.Builder
+	for _, id := range ids {
+		if IsAllDigits(id) {
+			buffer.WriteString(id)
+			buffer.WriteString("\n")
+		}
+	}
+
+	fl, err := os.OpenFile(fpath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fl.WriteString(buffer.String())
+	if err == nil {
+		err = fl.Sync()
+	}
+	if cerr := fl.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// ReadDeletedSet returns the sorted identifiers in the deleted set, which must not be
+// modified by the caller
+func ReadDeletedSet(db string) []int32 {
+
+	fpath := deletedSetPath(db)
+	if fpath == "" {
+		return nil
+	}
+
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return nil
+	}
+
+	deletedLock.Lock()
+	defer deletedLock.Unlock()
+
+	ds := deletedMap[fpath]
+	if ds != nil && ds.size == fi.Size() && ds.when.Equal(fi.ModTime()) {
+		return ds.uids
+	}
+
+	uids, err := readIDFile(fpath)
+	if err != nil {
+		return nil
+	}
+
+	deletedMap[fpath] = &deletedSet{size: fi.Size(), when: fi.ModTime(), uids: uids}
+
+	return uids
+}
+
+// SubtractDeleted returns sorted identifiers without those in the deleted set, leaving
+// the original array unchanged
+func SubtractDeleted(db string, uids []int32) []int32 {
+
+	if len(uids) < 1 {
+		return uids
+	}
+
+	return excludeIDs(uids, ReadDeletedSet(db))
+}
+
+// ReinstateDeleted takes identifiers of records stashed again out of the deleted set
+func ReinstateDeleted(db string, ids []string) error {
+
+	fpath := deletedSetPath(db)
+	if fpath == "" || len(ids) < 1 {
+		return nil
+	}
+
+	var uids []int32
+	for _, id := range ids {
+		val, err := strconv.ParseInt(id, 10, 32)
+		if err == nil {
+			uids = append(uids, int32(val))
+		}
+	}
+	slices.Sort(uids)
+	uids = slices.Compact(uids)
+
+	return removeDeleted(fpath, uids)
+}
+
+// NoteDeletedApplied records the deleted identifiers that a promote run leaves out of
+// new postings. If an earlier run has not yet been folded, only identifiers left out by
+// both runs are kept, since the others may still be in some postings.
+func NoteDeletedApplied(db string, uids []int32) error {
+
+	fpath := deletedSetPath(db)
+	if fpath == "" {
+		return nil
+	}
+
+	unlock, err := lockDeletedSet(fpath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	apath := filepath.Join(filepath.Dir(fpath), DeletedAppliedName)
+
+	prev, err := readIDFile(apath)
+	if err == nil {
+		uids = intersectIDs(prev, uids)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return writeIDFile(apath, uids)
+}
+
+// ClearDeletedSet removes the identifiers that rebuilt postings leave out from the
+// deleted set, returning the number removed. Identifiers deleted after the promote
+// run, or reinstated by a later stash, are left as they are.
+func ClearDeletedSet(db string) (int, error) {
+
+	fpath := deletedSetPath(db)
+	if fpath == "" {
+		return 0, fmt.Errorf("unable to get local postings path")
+	}
+
+	apath := filepath.Join(filepath.Dir(fpath), DeletedAppliedName)
+
+	applied, err := readIDFile(apath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// no promote run has left out any deleted records
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	num := len(intersectIDs(ReadDeletedSet(db), applied))
+
+	err = removeDeleted(fpath, applied)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Remove(apath)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	return num, nil
+}
+
+// removeDeleted rewrites the deleted set without the given sorted identifiers, removing
+// the file once it is empty
+func removeDeleted(fpath string, uids []int32) error {
+
+	if len(uids) < 1 {
+		return nil
+	}
+
+	unlock, err := lockDeletedSet(fpath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	curr, err := readIDFile(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	rest := excludeIDs(curr, uids)
+	if len(rest) == len(curr) {
+		return nil
+	}
+
+	deletedLock.Lock()
+	delete(deletedMap, fpath)
+	deletedLock.Unlock()
+
+	if len(rest) < 1 {
+		err = os.Remove(fpath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return writeIDFile(fpath, rest)
+}
+
+// lockDeletedSet takes the lock that serializes changes to the deleted set files
+func lockDeletedSet(fpath string) (func(), error) {
+
+	return flockFile(context.Background(), filepath.Join(filepath.Dir(fpath), deletedLockName), true)
+}
+
+// readIDFile returns the sorted, unique identifiers in a file with one per line
+func readIDFile(fpath string) ([]int32, error) {
+
+	fl, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer fl.Close()
+
+	var uids []int32
+
+	scanr := bufio.NewScanner(fl)
+	for scanr.Scan() {
+		val, err := strconv.ParseInt(scanr.Text(), 10, 32)
+		if err == nil {
+			uids = append(uids, int32(val))
+		}
+	}
+	if scanr.Err() != nil {
+		return nil, scanr.Err()
+	}
+
+	slices.Sort(uids)
+	uids = slices.Compact(uids)
+
+	return uids, nil
+}
+
+// writeIDFile replaces a file of identifiers, renaming it into place so readers never
+// see a partial list
+func writeIDFile(fpath string, uids []int32) error {
+
+	var buffer strings.Builder
+	for _, uid := range uids {
+		buffer.WriteString(strconv.Itoa(int(uid)))
+		buffer.WriteString("\n")
+	}
+
+	tmp := fpath + ".tmp"
+
+	err := os.WriteFile(tmp, []byte(buffer.String()), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fpath)
+}
@@ -0,0 +1,127 @@
+package eutils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDeletedSet(t *testing.T) {
+
+	master := t.TempDir()
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+
+	// without a Postings directory nothing is recorded
+	err := RecordDeleted("pubmed", []string{"7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ReadDeletedSet("pubmed"); len(got) != 0 {
+		t.Fatalf("deleted set without postings = %v", got)
+	}
+
+	err = os.MkdirAll(filepath.Join(master, "Postings"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RecordDeleted("pubmed", []string{"30", "10", "PMC5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RecordDeleted("pubmed", []string{"20", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := SubtractDeleted("pubmed", []int32{5, 10, 15, 20, 25, 30, 35})
+	want := []int32{5, 15, 25, 35}
+	if !slices.Equal(got, want) {
+		t.Errorf("SubtractDeleted = %v, want %v", got, want)
+	}
+
+	// nothing is folded before a promote run has left deleted records out
+	num, err := ClearDeletedSet("pubmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 0 {
+		t.Errorf("ClearDeletedSet before promote = %d, want 0", num)
+	}
+
+	err = NoteDeletedApplied("pubmed", ReadDeletedSet("pubmed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted while postings were being rebuilt, so still in them
+	err = RecordDeleted("pubmed", []string{"40"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	num, err = ClearDeletedSet("pubmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 3 {
+		t.Errorf("ClearDeletedSet = %d, want 3", num)
+	}
+
+	got = SubtractDeleted("pubmed", []int32{10, 20, 40})
+	if !slices.Equal(got, []int32{10, 20}) {
+		t.Errorf("SubtractDeleted after clear = %v", got)
+	}
+}
+
+func TestDeletedSetReinstated(t *testing.T) {
+
+	master := t.TempDir()
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+
+	err := os.MkdirAll(filepath.Join(master, "Postings"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stsh := t.TempDir()
+
+	stash := func(pmid string) {
+		inp := make(chan XMLRecord, 1)
+		inp <- XMLRecord{Index: 1, Text: "<PubmedArticle><MedlineCitation><PMID>" + pmid + "</PMID></MedlineCitation></PubmedArticle>"}
+		close(inp)
+		for range CreateStashers(stsh, "PubmedArticle", "MedlineCitation/PMID", "", ".xml", "pubmed", "", nil, false, false, CodecGzip, 1000, nil, inp) {
+		}
+	}
+
+	stash("10")
+	stash("20")
+
+	for range CreateDeleter(stsh, "pubmed", strings.NewReader("10\n20\n")) {
+	}
+
+	err = NoteDeletedApplied("pubmed", ReadDeletedSet("pubmed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// record comes back after the promote run left it out
+	stash("20")
+
+	if got := SubtractDeleted("pubmed", []int32{10, 20}); !slices.Equal(got, []int32{20}) {
+		t.Errorf("SubtractDeleted after restash = %v, want [20]", got)
+	}
+
+	num, err := ClearDeletedSet("pubmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 1 {
+		t.Errorf("ClearDeletedSet = %d, want 1", num)
+	}
+	if got := ReadDeletedSet("pubmed"); len(got) != 0 {
+		t.Errorf("deleted set after fold = %v", got)
+	}
+}
@@ -1,6 +1,13 @@
 package eutils
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 type stringTable struct {
 	input    string
@@ -146,6 +153,86 @@ func TestNormalizeJournal(t *testing.T) {
 		})
 }
 
+func TestNormalizeORCID(t *testing.T) {
+
+	stringTestMatch(t, "NormalizeORCID,",
+		NormalizeORCID,
+		[]stringTable{
+			{"0000-0002-1825-0097", "0000000218250097"},
+			{"https://orcid.org/0000-0002-1694-233X", "000000021694233x"},
+			{"http://orcid.org/0000000218250097", "0000000218250097"},
+			{"0000-0002-1825", ""},
+			{"0000-000A-1825-0097", ""},
+		})
+}
+
+func TestMalformedQueryTerm(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	key, err := NormalizeQuery("pubmed", "0000-0002-1825-0097 [ORCD]", false, false, true, "")
+	if err != nil || key != "0000000218250097 [ORCD]" {
+		t.Errorf("NormalizeQuery = %q, %v", key, err)
+	}
+
+	// a bad term in a server request must not exit the process
+	for _, query := range []string{"0000-0002-1825 [ORCD]", "198* [YEAR]"} {
+		_, err = NormalizeQuery("pubmed", query, false, false, true, "")
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("NormalizeQuery(%s) error = %v, expected *QueryError", query, err)
+		}
+	}
+}
+
+func TestMalformedQuerySyntax(t *testing.T) {
+
+	master := t.TempDir()
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+
+	err := os.MkdirAll(filepath.Join(master, "Postings"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	_, err = ProcessQueryContext(ctx, "pubmed", "foo AND bar", false, false, false, true, "")
+	if err != nil {
+		t.Errorf("ProcessQueryContext(foo AND bar) = %v", err)
+	}
+
+	// a syntax error in a server request must not exit the process
+	for _, query := range []string{"foo AND", "AND foo", "foo OR NOT bar", "(foo AND bar", "foo AND bar)"} {
+		_, err = ProcessQueryContext(ctx, "pubmed", query, false, false, false, true, "")
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ProcessQueryContext(%s) error = %v, expected *QueryError", query, err)
+		}
+	}
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", "")
+	t.Setenv("EDIRECT_LOCAL_ARCHIVE", "")
+	t.Setenv("EDIRECT_LOCAL_CONFIG", "")
+	_, err = ProcessQueryContext(ctx, "pubmed", "foo", false, false, false, true, "")
+	if !errors.Is(err, ErrNoArchivePath) {
+		t.Errorf("ProcessQueryContext without archive = %v, expected ErrNoArchivePath", err)
+	}
+}
+
+func TestGeneSymbolField(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	// official symbols are indexed only as PREF
+	for _, query := range []string{"brca1 [GSYM]", "brca1 [gsym]", "brca1 [PREF]"} {
+		fld, str := parseField("pubmed", query)
+		if fld != "PREF" || str != "brca1" {
+			t.Errorf("parseField(%s) = %s, %s, want PREF, brca1", query, fld, str)
+		}
+	}
+}
+
 func TestNormalizePage(t *testing.T) {
 
 	stringTestMatch(t, "NormalizePage,",
@@ -165,6 +252,21 @@ func TestNormalizeTitle(t *testing.T) {
 		})
 }
 
+func TestNounPhrases(t *testing.T) {
+
+	stringTestMatch(t, "NounPhrases,",
+		func(str string) string {
+			return strings.Join(NounPhrases(str, PhraseWords), "|")
+		},
+		[]stringTable{
+			{"nucleotide sequences required for tn3 transposition immunity",
+				"nucleotide sequences|transposition immunity|tn3 transposition immunity"},
+			{"purified protein significantly inhibits tumor growth", "purified protein|tumor growth"},
+			{"gene family, 120 patients", "gene family"},
+			{"one two three four five", "four five|three four five|two three four five"},
+		})
+}
+
 func TestRelaxString(t *testing.T) {
 
 	stringTestMatch(t, "RelaxString,",
@@ -0,0 +1,376 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  fill.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// READ-THROUGH ARCHIVE FILL
+
+// When an archive fill is registered for a database, CreateFetchers requests records
+// that are absent from the local archive from an efetch-compatible URL. Missing
+// identifiers from all fetcher goroutines are gathered into batches, requests are
+// spaced to respect the server's rate limit, and retrieved records are saved with
+// CreateStashers before being returned, so later fetches are served locally.
+
+// ArchiveFill configures retrieval of missing records
+type ArchiveFill struct {
+	// efetch-compatible endpoint, e.g. https://eutils.ncbi.nlm.nih.gov/entrez/eutils/efetch.fcgi
+	URL string
+	// identifiers per request
+	Batch int
+	// maximum requests per second
+	Rate int
+	// delay for gathering identifiers into a batch
+	Wait time.Duration
+	// path to identifier in each record, e.g. MedlineCitation/PMID
+	Index string
+	// xml and DOCTYPE header saved with each record, defaults to PubmedArticle header
+	Header string
+	// compression for stashed records, CodecNone uses the fetcher's zipp setting
+	Codec string
+	// optional E-utilities API key, defaults to NCBI_API_KEY
+	APIKey string
+
+	// spaces requests from all fetchers using this endpoint
+	limiter *fillLimiter
+}
+
+// fillLimiter hands out request times at most Rate per second, shared by all fillers
+// for one endpoint, so concurrent edict requests together stay within the limit
+type fillLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until the next request slot, which it reserves
+func (fl *fillLimiter) wait() {
+
+	fl.lock.Lock()
+	slot := time.Now()
+	if fl.next.After(slot) {
+		slot = fl.next
+	}
+	fl.next = slot.Add(fl.interval)
+	fl.lock.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
+
+// header stashed before PubmedArticle records, as in rchive -archive
+const pubmedArticleHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE PubmedArticle PUBLIC "-//NLM//DTD PubMedArticle, 1st January 2019//EN" "https://dtd.nlm.nih.gov/ncbi/pubmed/out/pubmed_190101.dtd">
+`
+
+var (
+	fillLock   sync.Mutex
+	fillMap    = make(map[string]*ArchiveFill)
+	fillLimits = make(map[string]*fillLimiter)
+)
+
+// SetArchiveFill registers read-through fill for a database, or removes it if fill is nil
+func SetArchiveFill(db string, fill *ArchiveFill) {
+
+	fillLock.Lock()
+	defer fillLock.Unlock()
+
+	if fill == nil {
+		delete(fillMap, db)
+		return
+	}
+
+	cfg := *fill
+	if cfg.Batch < 1 {
+		cfg.Batch = 200
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("NCBI_API_KEY")
+	}
+	if cfg.Rate < 1 {
+		// E-utilities allows 3 requests per second, or 10 with an API key
+		cfg.Rate = 3
+		if cfg.APIKey != "" {
+			cfg.Rate = 10
+		}
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = 50 * time.Millisecond
+	}
+	if db == "pubmed" {
+		if cfg.Index == "" {
+			cfg.Index = "MedlineCitation/PMID"
+		}
+		if cfg.Header == "" {
+			cfg.Header = pubmedArticleHeader
+		}
+	}
+
+	// databases filled from the same endpoint share its rate limit
+	lim, ok := fillLimits[cfg.URL]
+	if !ok {
+		lim = &fillLimiter{}
+		fillLimits[cfg.URL] = lim
+	}
+	lim.lock.Lock()
+	lim.interval = time.Second / time.Duration(cfg.Rate)
+	lim.lock.Unlock()
+	cfg.limiter = lim
+
+	fillMap[db] = &cfg
+}
+
+func getArchiveFill(db string) *ArchiveFill {
+
+	fillLock.Lock()
+	defer fillLock.Unlock()
+
+	return fillMap[db]
+}
+
+// fillRequest asks the filler for one record, answered on reply with "" if not found
+type fillRequest struct {
+	id    string
+	reply chan string
+}
+
+// archiveFiller batches missing identifiers and stashes retrieved records
+type archiveFiller struct {
+	cfg    *ArchiveFill
+	db     string
+	ptrn   string
+	reqs   chan fillRequest
+	stashq chan XMLRecord
+	client *http.Client
+	index  int
+}
+
+// newArchiveFiller starts the batching goroutine and its stasher, done is closed after
+// all retrieved records have been saved
+func newArchiveFiller(cfg *ArchiveFill, stsh, db, pfx, sfx, ptrn string, zipp bool) (*archiveFiller, <-chan struct{}) {
+
+	af := &archiveFiller{
+		cfg:    cfg,
+		db:     db,
+		ptrn:   ptrn,
+		reqs:   make(chan fillRequest, chanDepth),
+		stashq: make(chan XMLRecord, chanDepth),
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}
+
+	codec := cfg.Codec
+	if codec == CodecNone && zipp {
+		codec = CodecGzip
+	}
+
+	stsq := CreateStashers(stsh, "", cfg.Index, pfx, sfx, db, cfg.Header, nil, false, false, codec, math.MaxInt, nil, af.stashq)
+	if stsq == nil {
+		DisplayError("Unable to create archive fill stasher")
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		for range stsq {
+		}
+		close(done)
+	}()
+
+	go af.run()
+
+	return af, done
+}
+
+// fetch waits for a batch containing id to be retrieved
+func (af *archiveFiller) fetch(ctx context.Context, id string) string {
+
+	reply := make(chan string, 1)
+
+	af.reqs <- fillRequest{id: id, reply: reply}
+
+	select {
+	case str := <-reply:
+		return str
+	case <-ctx.Done():
+		return ""
+	}
+}
+
+// close stops accepting requests, the stasher finishes after the last batch
+func (af *archiveFiller) close() {
+
+	close(af.reqs)
+}
+
+// run gathers requests until the batch is full or the wait period expires
+func (af *archiveFiller) run() {
+
+	defer close(af.stashq)
+
+	for req := range af.reqs {
+
+		batch := map[string][]chan string{req.id: {req.reply}}
+		order := []string{req.id}
+
+		timer := time.NewTimer(af.cfg.Wait)
+
+	gather:
+		for len(order) < af.cfg.Batch {
+			select {
+			case nxt, ok := <-af.reqs:
+				if !ok {
+					break gather
+				}
+				if _, seen := batch[nxt.id]; !seen {
+					order = append(order, nxt.id)
+				}
+				batch[nxt.id] = append(batch[nxt.id], nxt.reply)
+			case <-timer.C:
+				break gather
+			}
+		}
+
+		timer.Stop()
+
+		found, err := af.retrieve(order)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Archive fill failed: %s\n", err.Error())
+		}
+
+		for id, replies := range batch {
+			str := found[id]
+			for _, reply := range replies {
+				reply <- str
+			}
+		}
+	}
+}
+
+// retrieve requests one batch, saves the records, and returns them by identifier
+func (af *archiveFiller) retrieve(ids []string) (map[string]string, error) {
+
+	data, err := af.post(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	find := ParseIndex(af.cfg.Index)
+
+	found := make(map[string]string)
+
+	PartitionXML(af.ptrn, "", false, CreateXMLStreamer(bytes.NewReader(data), nil),
+		func(str string) {
+			id := FindIdentifier(str, "", find)
+			if pos := strings.Index(id, "."); pos >= 0 {
+				id = id[:pos]
+			}
+			if !wanted[id] {
+				return
+			}
+			if !strings.HasSuffix(str, "\n") {
+				str += "\n"
+			}
+			found[id] = str
+			af.index++
+			af.stashq <- XMLRecord{Index: af.index, Ident: id, Text: str}
+		})
+
+	return found, nil
+}
+
+// post sends an efetch request after waiting for the rate limit, retrying throttling
+// and server errors with exponential backoff
+func (af *archiveFiller) post(ids []string) ([]byte, error) {
+
+	form := url.Values{}
+	form.Set("db", af.db)
+	form.Set("id", strings.Join(ids, ","))
+	form.Set("retmode", "xml")
+	form.Set("tool", "edirect")
+	if af.cfg.APIKey != "" {
+		form.Set("api_key", af.cfg.APIKey)
+	}
+	body := form.Encode()
+
+	delay := time.Second / time.Duration(af.cfg.Rate)
+
+	var lastErr error
+
+	for attempt := 0; attempt < 5; attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		af.cfg.limiter.wait()
+
+		resp, err := af.client.Post(af.cfg.URL, "application/x-www-form-urlencoded", strings.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("%s: %s", af.cfg.URL, resp.Status)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", af.cfg.URL, resp.Status)
+		}
+
+		return data, nil
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,177 @@
+package eutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newEfetchServer starts an efetch stand-in that returns PubmedArticle records for
+// identifiers it knows, throttling the first request with 429
+func newEfetchServer(t *testing.T, known map[string]bool) (*httptest.Server, *[][]string) {
+
+	var lock sync.Mutex
+	var batches [][]string
+	throttled := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if !throttled {
+			throttled = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		if r.Method != http.MethodPost || r.FormValue("db") != "pubmed" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ids := strings.Split(r.FormValue("id"), ",")
+		batches = append(batches, ids)
+
+		fmt.Fprintf(w, "<?xml version=\"1.0\" ?>\n<PubmedArticleSet>\n")
+		for _, id := range ids {
+			if known[id] {
+				fmt.Fprintf(w, "<PubmedArticle><MedlineCitation><PMID Version=\"1\">%s</PMID>"+
+					"<Article><ArticleTitle>Remote %s</ArticleTitle></Article></MedlineCitation></PubmedArticle>\n", id, id)
+			}
+		}
+		fmt.Fprintf(w, "</PubmedArticleSet>\n")
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, &batches
+}
+
+func TestArchiveFill(t *testing.T) {
+
+	SetTunings(0, 0, 0, 0, 0, 0, 0, false)
+
+	master := t.TempDir()
+	stsh := filepath.Join(master, "Archive") + "/"
+	err := os.MkdirAll(stsh, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+
+	store := OpenArchiveStore(stsh)
+	err = store.Put(ArchiveKey("100", "", ".xml.gz"), GzipString("<PubmedArticle><MedlineCitation><PMID>100</PMID></MedlineCitation></PubmedArticle>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known := map[string]bool{}
+	for i := 101; i <= 125; i++ {
+		known[fmt.Sprint(i)] = true
+	}
+
+	srv, batches := newEfetchServer(t, known)
+
+	SetArchiveFill("pubmed", &ArchiveFill{URL: srv.URL, Batch: 10, Rate: 100})
+	defer SetArchiveFill("pubmed", nil)
+
+	fetch := func(ids []string) map[string]string {
+		uidq := make(chan XMLRecord, len(ids))
+		for i, id := range ids {
+			uidq <- XMLRecord{Index: i + 1, Text: id}
+		}
+		close(uidq)
+		res := make(map[string]string)
+		for rec := range CreateFetchers(context.Background(), stsh, "pubmed", "", ".xml", "PubmedArticle", true, uidq) {
+			res[ids[rec.Index-1]] = rec.Text
+		}
+		return res
+	}
+
+	ids := []string{"100", "999"}
+	for i := 101; i <= 125; i++ {
+		ids = append(ids, fmt.Sprint(i))
+	}
+
+	res := fetch(ids)
+
+	if !strings.Contains(res["100"], "<PMID>100</PMID>") {
+		t.Errorf("local record not returned: %q", res["100"])
+	}
+	if res["999"] != "" {
+		t.Errorf("unknown record returned: %q", res["999"])
+	}
+	for i := 101; i <= 125; i++ {
+		id := fmt.Sprint(i)
+		if !strings.Contains(res[id], "Remote "+id) {
+			t.Errorf("record %s not filled: %q", id, res[id])
+		}
+		if recordKey(store, id, "", ".xml") == "" {
+			t.Errorf("record %s not stashed", id)
+		}
+	}
+
+	requested := 0
+	for _, batch := range *batches {
+		if len(batch) > 10 {
+			t.Errorf("batch of %d exceeds limit", len(batch))
+		}
+		for _, id := range batch {
+			if id == "100" {
+				t.Errorf("local record requested from server")
+			}
+		}
+		requested += len(batch)
+	}
+	if requested != 26 {
+		t.Errorf("requested %d identifiers, expected 26", requested)
+	}
+
+	// stashed records are now served locally
+	before := len(*batches)
+	res = fetch([]string{"101", "125"})
+	if !strings.Contains(res["125"], "Remote 125") || len(*batches) != before {
+		t.Errorf("filled records not read from archive")
+	}
+}
+
+func TestArchiveFillRateShared(t *testing.T) {
+
+	SetArchiveFill("pubmed", &ArchiveFill{URL: "http://localhost/efetch.fcgi", Rate: 20})
+	SetArchiveFill("pmc", &ArchiveFill{URL: "http://localhost/efetch.fcgi", Rate: 20})
+	defer SetArchiveFill("pubmed", nil)
+	defer SetArchiveFill("pmc", nil)
+
+	pm, pmc := getArchiveFill("pubmed"), getArchiveFill("pmc")
+	if pm.limiter == nil || pm.limiter != pmc.limiter {
+		t.Fatalf("fills for one endpoint do not share a rate limiter")
+	}
+
+	// requests from concurrent fetchers are spaced as if from one
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		cfg := pm
+		if i%2 == 1 {
+			cfg = pmc
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.limiter.wait()
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 5*50*time.Millisecond {
+		t.Errorf("6 requests at 20 per second took %v", elapsed)
+	}
+}
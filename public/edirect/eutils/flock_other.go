@@ -0,0 +1,44 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  flock_other.go
+//
+// ==========================================================================
+
+//go:build !unix
+
+package eutils
+
+import (
+	"context"
+)
+
+// ADVISORY FILE LOCKS
+
+// flockFile is a no-op on platforms without flock, where a single writer
+// and readers that tolerate a generation change in mid-query are assumed
+func flockFile(ctx context.Context, fpath string, exclusive bool) (func(), error) {
+
+	return func() {}, ctx.Err()
+}
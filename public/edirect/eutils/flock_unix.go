@@ -0,0 +1,85 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  flock_unix.go
+//
+// ==========================================================================
+
+//go:build unix
+
+package eutils
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ADVISORY FILE LOCKS
+
+// flockFile takes a shared or exclusive advisory lock on the named file,
+// polling so that a cancelled context or expired deadline ends the wait.
+// A missing lock file is only created for an exclusive lock, since a reader
+// has nothing to wait for until some writer has made one. The returned
+// function releases the lock.
+func flockFile(ctx context.Context, fpath string, exclusive bool) (func(), error) {
+
+	flag := os.O_RDONLY
+	how := syscall.LOCK_SH
+	if exclusive {
+		flag = os.O_RDWR | os.O_CREATE
+		how = syscall.LOCK_EX
+	}
+
+	fl, err := os.OpenFile(fpath, flag, 0644)
+	if err != nil {
+		if !exclusive && os.IsNotExist(err) {
+			return func() {}, nil
+		}
+		return nil, err
+	}
+
+	for {
+		err = syscall.Flock(int(fl.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			fl.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			fl.Close()
+			return nil, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	return func() {
+		syscall.Flock(int(fl.Fd()), syscall.LOCK_UN)
+		fl.Close()
+	}, nil
+}
@@ -0,0 +1,181 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  generation.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// POSTINGS GENERATION MARKER
+
+// GenerationMarker is the name of the file, at the top of the Postings directory,
+// that records how many times the postings have been published. It is rewritten
+// (atomically, by rename) by rchive -advance, once all -promote runs of an update
+// have finished, so a long-running server such as edict can poll it and know
+// when to discard stale state.
+//
+// The file contains one line with the generation number and an RFC 3339 time:
+//
+//   17	2024-02-06T03:14:15Z
+
+const GenerationMarker = "GENERATION"
+
+// StagedSuffix is appended to postings files written by -promote -stage. Staged
+// files are invisible to queries until -advance renames them into place.
+const StagedSuffix = ".next"
+
+// postingsLock is the advisory lock file that queries hold shared while reading
+// postings, and that -advance holds exclusive while publishing staged files
+const postingsLock = "GENERATION.lock"
+
+func postingsGenerationPath(db string) string {
+
+	base, _ := GetLocalArchivePaths(db)
+	if base == "" {
+		return ""
+	}
+
+	return filepath.Join(base+"Postings", GenerationMarker)
+}
+
+// ReadGeneration returns the current postings generation number and time, or 0 and an empty string if no marker exists
+func ReadGeneration(db string) (int, string) {
+
+	fpath := postingsGenerationPath(db)
+	if fpath == "" {
+		return 0, ""
+	}
+
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return 0, ""
+	}
+
+	str := strings.TrimSpace(string(data))
+	num, when := SplitInTwoLeft(str, "\t")
+
+	gen, err := strconv.Atoi(num)
+	if err != nil {
+		return 0, ""
+	}
+
+	return gen, when
+}
+
+// AdvanceGeneration increments the postings generation marker, returning the new generation number
+func AdvanceGeneration(db string) int {
+
+	fpath := postingsGenerationPath(db)
+	if fpath == "" {
+		return 0
+	}
+
+	gen, _ := ReadGeneration(db)
+	gen++
+
+	when := time.Now().UTC().Format(time.RFC3339)
+	txt := strconv.Itoa(gen) + "\t" + when + "\n"
+
+	// write to temporary file, then rename, so readers never see a partial marker
+	tmp := fpath + ".tmp"
+	err := os.WriteFile(tmp, []byte(txt), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 0
+	}
+	err = os.Rename(tmp, fpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Remove(tmp)
+		return 0
+	}
+
+	return gen
+}
+
+// lockPostings takes the postings lock for a query, so that -advance cannot rename
+// staged files into place while the query is reading a term's .mst, .pst, and .uqi
+// files, and the query never mixes files from two generations
+func lockPostings(ctx context.Context, postingsBase string) (func(), error) {
+
+	if postingsBase == "" {
+		return func() {}, nil
+	}
+
+	return flockFile(ctx, filepath.Join(postingsBase, postingsLock), false)
+}
+
+// PublishPostings renames all staged postings files into place while holding the
+// postings lock exclusively, then advances the generation marker. It returns the
+// number of files published and the new generation number.
+func PublishPostings(db string) (int, int, error) {
+
+	fpath := postingsGenerationPath(db)
+	if fpath == "" {
+		return 0, 0, fmt.Errorf("unable to get local postings path")
+	}
+	postingsBase := filepath.Dir(fpath)
+
+	unlock, err := flockFile(context.Background(), filepath.Join(postingsBase, postingsLock), true)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	count := 0
+
+	err = filepath.WalkDir(postingsBase, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, StagedSuffix) {
+			return nil
+		}
+		err = os.Rename(path, strings.TrimSuffix(path, StagedSuffix))
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, 0, err
+	}
+
+	gen := AdvanceGeneration(db)
+
+	return count, gen, nil
+}
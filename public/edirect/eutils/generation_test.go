@@ -0,0 +1,85 @@
+package eutils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublishPostings(t *testing.T) {
+
+	master := t.TempDir()
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+
+	dpath := filepath.Join(master, "Postings", "TIAB", "c", "a")
+	err := os.MkdirAll(dpath, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := filepath.Join(dpath, "ca.TIAB.pst")
+	err = os.WriteFile(live, []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(live+StagedSuffix, []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a query holding the postings lock delays publication
+	postingsBase := filepath.Join(master, "Postings")
+	err = os.WriteFile(filepath.Join(postingsBase, postingsLock), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockPostings(context.Background(), postingsBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		count, gen, err := PublishPostings("pubmed")
+		if err != nil || count != 1 || gen != 1 {
+			t.Errorf("PublishPostings = %d, %d, %v", count, gen, err)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	data, _ := os.ReadFile(live)
+	if string(data) != "old" {
+		t.Fatalf("staged file published while query held postings lock")
+	}
+
+	unlock()
+	<-done
+
+	data, _ = os.ReadFile(live)
+	if string(data) != "new" {
+		t.Errorf("published file = %q, want new", data)
+	}
+	if _, err := os.Stat(live + StagedSuffix); !os.IsNotExist(err) {
+		t.Errorf("staged file remains after publication")
+	}
+	if gen, _ := ReadGeneration("pubmed"); gen != 1 {
+		t.Errorf("generation = %d, want 1", gen)
+	}
+
+	// a waiting query gives up when its context ends
+	unlock, err = flockFile(context.Background(), filepath.Join(postingsBase, postingsLock), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = lockPostings(ctx, postingsBase)
+	if err == nil {
+		t.Errorf("lockPostings succeeded while postings were being published")
+	}
+}
@@ -3,9 +3,11 @@ module eutils
 go 1.23.0
 
 require (
+	github.com/blevesearch/snowballstem v0.9.0
 	github.com/fatih/color v1.17.0
 	github.com/gedex/inflector v0.0.0-20170307190818-16278e9db813
 	github.com/goccy/go-yaml v1.12.0
+	github.com/klauspost/compress v1.17.9
 	github.com/klauspost/cpuid v1.3.1
 	github.com/klauspost/pgzip v1.2.6
 	github.com/komkom/toml v0.1.2
@@ -15,7 +17,6 @@ require (
 )
 
 require (
-	github.com/klauspost/compress v1.17.9 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
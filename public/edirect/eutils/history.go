@@ -0,0 +1,413 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  history.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ARCHIVE VERSION HISTORY
+
+// In versioned mode, CreateStashers keeps each record's previous contents before
+// replacing it. For the record stored under key "02/53/93/2539356.xml.gz", revision
+// N is saved as "02/53/93/2539356.xml.gz.vN", and "02/53/93/2539356.xml.gz.hst" lists
+// every revision, one tab-delimited line per version with its arrival date and source
+// file. The highest-numbered revision is the current record, which is still stored
+// under the original key, so unversioned fetching and indexing are not affected.
+//
+// A record archived before versioning was turned on becomes revision 1, with "-" for
+// its unknown arrival date and source.
+
+// StashSource identifies the update file that a batch of records came from, and
+// turns on versioned mode when passed to CreateStashers
+type StashSource struct {
+	Name    string
+	Arrived time.Time
+}
+
+// ArchiveRevision describes one version of an archived record
+type ArchiveRevision struct {
+	Version int
+	Arrived time.Time
+	Source  string
+}
+
+func historyKey(key string) string {
+
+	return key + ".hst"
+}
+
+func revisionKey(key string, version int) string {
+
+	return key + ".v" + strconv.Itoa(version)
+}
+
+// readRevisions parses a record's history list, returning nil if it has none
+func readRevisions(store ArchiveStore, key string) []ArchiveRevision {
+
+	data, err := store.Get(historyKey(key))
+	if err != nil {
+		return nil
+	}
+
+	var revs []ArchiveRevision
+
+	for _, line := range strings.Split(string(data), "\n") {
+		cols := strings.Split(line, "\t")
+		if len(cols) < 3 {
+			continue
+		}
+		num, err := strconv.Atoi(cols[0])
+		if err != nil {
+			continue
+		}
+		rev := ArchiveRevision{Version: num, Source: cols[2]}
+		if cols[1] != "-" {
+			rev.Arrived, _ = time.Parse(time.RFC3339, cols[1])
+		}
+		revs = append(revs, rev)
+	}
+
+	return revs
+}
+
+func formatRevisions(revs []ArchiveRevision) []byte {
+
+	var buf bytes.Buffer
+
+	for _, rev := range revs {
+		when := "-"
+		if !rev.Arrived.IsZero() {
+			when = rev.Arrived.UTC().Format(time.RFC3339)
+		}
+		src := rev.Source
+		if src == "" {
+			src = "-"
+		}
+		fmt.Fprintf(&buf, "%d\t%s\t%s\n", rev.Version, when, src)
+	}
+
+	return buf.Bytes()
+}
+
+// keepRevision saves the current contents of a record about to be replaced, and returns
+// the updated history list to be stored after the new contents, or nil if the update
+// is identical to the current record and need not be written. The current contents are
+// read from prevKey, which differs from key when the record was stored with another codec.
+func keepRevision(store ArchiveStore, key, prevKey string, data []byte, src *StashSource) ([]byte, error) {
+
+	revs := readRevisions(store, key)
+
+	prev, err := store.Get(prevKey)
+	if err == nil {
+
+		if bytes.Equal(prev, data) {
+			return nil, nil
+		}
+
+		if len(revs) < 1 {
+			revs = append(revs, ArchiveRevision{Version: 1})
+		}
+
+		last := revs[len(revs)-1].Version
+
+		err = store.Put(revisionKey(key, last), prev)
+		if err != nil {
+			return nil, err
+		}
+
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	next := 1
+	if len(revs) > 0 {
+		next = revs[len(revs)-1].Version + 1
+	}
+
+	revs = append(revs, ArchiveRevision{Version: next, Arrived: src.Arrived, Source: src.Name})
+
+	return formatRevisions(revs), nil
+}
+
+// deleteRevisions removes a deleted record's earlier versions and history list, so
+// they cannot be fetched or compared, and a later record with the same identifier
+// starts a new history
+func deleteRevisions(store ArchiveStore, key string) error {
+
+	for _, rev := range readRevisions(store, key) {
+		err := store.Delete(revisionKey(key, rev.Version))
+		if err != nil {
+			return err
+		}
+	}
+
+	return store.Delete(historyKey(key))
+}
+
+// moveRevisions transfers earlier versions and the history list of a record to the key
+// it is being restored under with a different codec
+func moveRevisions(store ArchiveStore, from, to string) error {
+
+	revs := readRevisions(store, from)
+	if len(revs) < 1 {
+		return nil
+	}
+
+	for _, rev := range revs {
+		data, err := store.Get(revisionKey(from, rev.Version))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = store.Put(revisionKey(to, rev.Version), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := store.Put(historyKey(to), formatRevisions(revs))
+	if err != nil {
+		return err
+	}
+
+	return deleteRevisions(store, from)
+}
+
+// recordKey finds the stored key for an identifier, preferring compressed records
+func recordKey(store ArchiveStore, id, pfx, sfx string) string {
+
+	id = strings.TrimPrefix(id, "PMC")
+
+	if pos := strings.Index(id, "."); pos >= 0 {
+		// remove version suffix
+		id = id[:pos]
+	}
+
+	for _, ext := range []string{sfx + ".gz", sfx + ".zst", sfx} {
+		key := ArchiveKey(id, pfx, ext)
+		if key != "" && store.Has(key) {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// ArchiveHistory returns the list of stored versions of a record, oldest first
+func ArchiveHistory(stsh, id, pfx, sfx string) []ArchiveRevision {
+
+	store := OpenArchiveStore(stsh)
+
+	key := recordKey(store, id, pfx, sfx)
+	if key == "" {
+		return nil
+	}
+
+	revs := readRevisions(store, key)
+	if len(revs) < 1 {
+		// archived without versioning
+		revs = append(revs, ArchiveRevision{Version: 1})
+	}
+
+	return revs
+}
+
+// ParseArchiveDate accepts 2024-03-15, 2024/03/15, 20240315, or an RFC 3339 timestamp,
+// returning the end of a given day so that -as-of includes updates arriving on that date
+func ParseArchiveDate(str string) (time.Time, error) {
+
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "20060102"} {
+		day, err := time.Parse(layout, str)
+		if err == nil {
+			return day.Add(24*time.Hour - time.Nanosecond), nil
+		}
+	}
+
+	return time.Parse(time.RFC3339, str)
+}
+
+// SelectRevision picks the requested version number, or the latest version that
+// arrived on or before the asOf time, or the current version if neither is given
+func SelectRevision(revs []ArchiveRevision, version int, asOf time.Time) (ArchiveRevision, bool) {
+
+	if len(revs) < 1 {
+		return ArchiveRevision{}, false
+	}
+
+	if version > 0 {
+		for _, rev := range revs {
+			if rev.Version == version {
+				return rev, true
+			}
+		}
+		return ArchiveRevision{}, false
+	}
+
+	if !asOf.IsZero() {
+		found := false
+		var res ArchiveRevision
+		for _, rev := range revs {
+			// revisions of unknown date predate versioning, and precede any dated revision
+			if rev.Arrived.After(asOf) {
+				break
+			}
+			res = rev
+			found = true
+		}
+		return res, found
+	}
+
+	return revs[len(revs)-1], true
+}
+
+// FetchArchiveRevision returns the uncompressed text of one version of a record,
+// with any leading xml and DOCTYPE lines removed when ptrn is supplied
+func FetchArchiveRevision(stsh, id, pfx, sfx, ptrn string, version int) string {
+
+	store := OpenArchiveStore(stsh)
+
+	key := recordKey(store, id, pfx, sfx)
+	if key == "" {
+		return ""
+	}
+
+	revs := readRevisions(store, key)
+
+	src := key
+	if len(revs) > 0 && version != revs[len(revs)-1].Version {
+		src = revisionKey(key, version)
+	} else if len(revs) < 1 && version != 1 {
+		return ""
+	}
+
+	data, err := store.Get(src)
+	if err != nil {
+		return ""
+	}
+
+	data, err = DecompressRecord(stsh, data)
+	if err != nil {
+		return ""
+	}
+
+	str := string(data)
+
+	if ptrn != "" {
+		pos := strings.Index(str, "<"+ptrn+">")
+		if pos > 0 {
+			// remove any leading xml and DOCTYPE lines
+			str = str[pos:]
+		}
+	}
+
+	return str
+}
+
+// DiffRevisions compares two versions of a record after indenting them, one element
+// per line, and returns the removed and added lines prefixed by "-" and "+" with
+// "@@" headers giving their line positions in each version
+func DiffRevisions(older, newer string) string {
+
+	split := func(str string) []string {
+		str = ChanToString(FormatRecord(str, "", FormatArgs{Format: "indent"}))
+		return strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+	}
+
+	a := split(older)
+	b := split(newer)
+
+	// longest common subsequence table, records are small enough for quadratic space
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var buffer strings.Builder
+
+	var dels, adds []string
+	delAt, addAt := 0, 0
+
+	flush := func() {
+		if len(dels) == 0 && len(adds) == 0 {
+			return
+		}
+		fmt.Fprintf(&buffer, "@@ -%d,%d +%d,%d @@\n", delAt+1, len(dels), addAt+1, len(adds))
+		for _, str := range dels {
+			buffer.WriteString("-" + str + "\n")
+		}
+		for _, str := range adds {
+			buffer.WriteString("+" + str + "\n")
+		}
+		dels = dels[:0]
+		adds = adds[:0]
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			if len(dels) == 0 && len(adds) == 0 {
+				delAt, addAt = i, j
+			}
+			adds = append(adds, b[j])
+			j++
+		default:
+			if len(dels) == 0 && len(adds) == 0 {
+				delAt, addAt = i, j
+			}
+			dels = append(dels, a[i])
+			i++
+		}
+	}
+	flush()
+
+	return buffer.String()
+}
@@ -0,0 +1,94 @@
+package eutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteRemovesRevisions(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	stsh := t.TempDir()
+	store := OpenArchiveStore(stsh)
+
+	key := ArchiveKey("2539356", "", ".xml.gz")
+	revs := []ArchiveRevision{
+		{Version: 1},
+		{Version: 2, Arrived: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), Source: "pubmed24n1300.xml.gz"},
+	}
+
+	for k, v := range map[string]string{
+		key:                 "<PubmedArticle>two</PubmedArticle>",
+		revisionKey(key, 1): "<PubmedArticle>one</PubmedArticle>",
+		historyKey(key):     string(formatRevisions(revs)),
+	} {
+		err := store.Put(k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := FetchArchiveRevision(stsh, "2539356", "", ".xml", "PubmedArticle", 1); got == "" {
+		t.Fatalf("revision 1 missing before deletion")
+	}
+
+	for range CreateDeleter(stsh, "pubmed", strings.NewReader("2539356\n")) {
+	}
+
+	for _, k := range []string{key, revisionKey(key, 1), historyKey(key)} {
+		if store.Has(k) {
+			t.Errorf("%s remains after deletion", k)
+		}
+	}
+	if got := FetchArchiveRevision(stsh, "2539356", "", ".xml", "PubmedArticle", 1); got != "" {
+		t.Errorf("revision 1 fetched after deletion: %s", got)
+	}
+}
+
+func TestStashReplacesOtherCodec(t *testing.T) {
+
+	t.Setenv("EDIRECT_PUBMED_MASTER", t.TempDir())
+
+	stsh := t.TempDir()
+	store := OpenArchiveStore(stsh)
+
+	stash := func(text, codec string, src *StashSource) {
+		inp := make(chan XMLRecord, 1)
+		inp <- XMLRecord{Index: 1, Text: text}
+		close(inp)
+		for range CreateStashers(stsh, "PubmedArticle", "MedlineCitation/PMID", "", ".xml", "pubmed", "", nil, false, false, codec, 1000, src, inp) {
+		}
+	}
+
+	one := "<PubmedArticle><MedlineCitation><PMID>2539356</PMID><ArticleTitle>one</ArticleTitle></MedlineCitation></PubmedArticle>"
+	two := "<PubmedArticle><MedlineCitation><PMID>2539356</PMID><ArticleTitle>two</ArticleTitle></MedlineCitation></PubmedArticle>"
+
+	src := &StashSource{Name: "pubmed24n1300.xml.gz", Arrived: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)}
+
+	stash(one, CodecGzip, src)
+	stash(two, CodecZstd, src)
+
+	gz := ArchiveKey("2539356", "", ".xml.gz")
+	zst := ArchiveKey("2539356", "", ".xml.zst")
+
+	if store.Has(gz) || store.Has(historyKey(gz)) {
+		t.Errorf("gzip copy remains after zstd update")
+	}
+	if !store.Has(zst) {
+		t.Fatalf("zstd record not stored")
+	}
+
+	var buf bytes.Buffer
+	if got := fetchOneXMLRecord("2539356", stsh, "", ".xml", true, buf); !strings.Contains(got, "two") {
+		t.Errorf("fetched stale record: %s", got)
+	}
+	if got := FetchArchiveRevision(stsh, "2539356", "", ".xml", "PubmedArticle", 1); !strings.Contains(got, "one") {
+		t.Errorf("revision 1 is not the gzip record: %s", got)
+	}
+	if revs := ArchiveHistory(stsh, "2539356", "", ".xml"); len(revs) != 2 {
+		t.Errorf("history has %d versions, want 2", len(revs))
+	}
+}
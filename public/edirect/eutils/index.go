@@ -33,6 +33,7 @@ package eutils
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"html"
 	"io"
@@ -50,7 +51,8 @@ import (
 // PUBMED INDEXED AND INVERTED FILE FORMATS
 
 // Local archive indexing reads PubmedArticle XML records and produces IdxDocument records.
-// Title and Title/Abstract fields include term positions as XML attributes:
+// Title and Title/Abstract fields include term positions as XML attributes. Structured
+// abstract sections (BKGD, OBJT, METH, RSLT, and CONC) use the same positions as TIAB:
 
 /*
 
@@ -106,6 +108,11 @@ import (
       ...
       <MESH>Plasmids</MESH>
       <MESH>Recombination, Genetic</MESH>
+      <MESH>DNA Transposable Elements / genetics</MESH>
+      <MAJR>DNA Transposable Elements / genetics</MAJR>
+      <MAJR>DNA Transposable Elements</MAJR>
+      <SUBS>DNA Transposable Elements</SUBS>
+      <SUBS>DNA, Bacterial</SUBS>
     </IdxSearchFields>
   </IdxDocument>
   ...
@@ -150,6 +157,111 @@ import (
 // Separate inversion runs are merged and used to produce term lists and postings file.
 // These can then be searched by passing commands to EDirect's "phrase-search" script.
 
+// STRUCTURED ABSTRACT SECTIONS
+
+// abstractSections maps AbstractText NlmCategory values to positional index fields
+var abstractSections = map[string]string{
+	"BACKGROUND":  "BKGD",
+	"OBJECTIVE":   "OBJT",
+	"METHODS":     "METH",
+	"RESULTS":     "RSLT",
+	"CONCLUSIONS": "CONC",
+}
+
+// sectionField returns the index field for an AbstractText node, or an empty string for
+// titles and unlabeled or unassigned paragraphs
+func sectionField(node *XMLNode) string {
+
+	if node == nil || node.Attributes == "" {
+		return ""
+	}
+
+	if node.Attribs == nil {
+		node.Attribs = ParseAttributes(node.Attributes)
+	}
+
+	for i := 0; i < len(node.Attribs)-1; i += 2 {
+		if node.Attribs[i] == "NlmCategory" {
+			return abstractSections[strings.ToUpper(node.Attribs[i+1])]
+		}
+	}
+
+	return ""
+}
+
+// AbstractSection is one labeled paragraph of a structured abstract, with the range of TIAB
+// word positions it occupies, so highlighted terms can be shown under their section label
+type AbstractSection struct {
+	Label    string
+	Category string
+	Field    string
+	First    int
+	Last     int
+}
+
+// AbstractSections returns the labeled paragraphs of a PubmedArticle abstract, counting
+// words in the title and abstract the way -indexer and -sections do for TIAB positions
+func AbstractSections(text string) []AbstractSection {
+
+	var res []AbstractSection
+
+	// split element into attributes and escaped contents, as -wrp passes them to -indexer
+	splitElement := func(str string) (string, string) {
+		gt := strings.Index(str, ">")
+		if gt < 0 || strings.HasSuffix(str[:gt+1], "/>") {
+			return "", ""
+		}
+		open := str[:gt]
+		if sp := strings.Index(open, " "); sp >= 0 {
+			open = open[sp+1:]
+		} else {
+			open = ""
+		}
+		inner := str[gt+1:]
+		if lt := strings.LastIndex(inner, "</"); lt >= 0 {
+			inner = inner[:lt]
+		}
+		return open, html.EscapeString(inner)
+	}
+
+	cumulative := 0
+
+	eachElement(text, "ArticleTitle", func(str string) {
+		_, inner := splitElement(str)
+		_, cumulative = indexWords(inner, cumulative, languageAnalyzers["eng"], false, func(string, int) {})
+	})
+
+	eachElement(rawElements(text, "Abstract"), "AbstractText", func(str string) {
+		attrs, inner := splitElement(str)
+
+		sect := AbstractSection{First: cumulative + 1}
+
+		var last int
+		last, cumulative = indexWords(inner, cumulative, languageAnalyzers["eng"], false, func(string, int) {})
+		if last < sect.First {
+			return
+		}
+		sect.Last = last
+
+		arry := ParseAttributes(attrs)
+		for i := 0; i < len(arry)-1; i += 2 {
+			switch arry[i] {
+			case "Label":
+				sect.Label = arry[i+1]
+			case "NlmCategory":
+				sect.Category = arry[i+1]
+				sect.Field = abstractSections[strings.ToUpper(arry[i+1])]
+			}
+		}
+
+		if sect.Label != "" || sect.Category != "" {
+			res = append(res, sect)
+		}
+	})
+
+	return res
+}
+
 // ENTREZ2INDEX COMMAND GENERATOR
 
 // MakeE2Commands generates extraction commands to create input for Entrez2Index
@@ -232,7 +344,7 @@ func examineFolder(base, path string) ([]string, []string, []string, []string) {
 			if isTwoDigits(name) {
 				dirs = append(dirs, name)
 			}
-		} else if strings.HasSuffix(name, ".xml.gz") {
+		} else if strings.HasSuffix(name, ".xml.gz") || strings.HasSuffix(name, ".xml.zst") {
 			xmls = append(xmls, name)
 		} else if strings.HasSuffix(name, ".e2x.gz") {
 			e2xs = append(e2xs, name)
@@ -244,6 +356,39 @@ func examineFolder(base, path string) ([]string, []string, []string, []string) {
 	return dirs, xmls, e2xs, invs
 }
 
+// countLeafFolders counts trie folders holding xml or e2x files, for progress estimates
+func countLeafFolders(base string) int {
+
+	count := 0
+
+	// recursive definition
+	var countSubFolders func(path string)
+
+	countSubFolders = func(path string) {
+
+		dirs, xmls, e2xs, _ := examineFolder(base, path)
+
+		if dirs != nil {
+			for _, dr := range dirs {
+				countSubFolders(filepath.Join(path, dr))
+			}
+			return
+		}
+
+		if xmls != nil || e2xs != nil {
+			count++
+		}
+	}
+
+	dirs, _, _, _ := examineFolder(base, "")
+
+	for _, top := range dirs {
+		countSubFolders(top)
+	}
+
+	return count
+}
+
 // gzFileToString reads selected gzipped file, uncompressing and saving contents as string
 func gzFileToString(fpath string) string {
 
@@ -299,8 +444,11 @@ func stringToGzFile(base, path, file, str string) {
 		return
 	}
 
-	// overwrites and truncates existing file
-	fl, err := os.Create(fpath)
+	// write to temporary file, then rename, so an interrupted run
+	// never leaves a partial file that a restart would skip over
+	tmpath := fpath + ".tmp"
+
+	fl, err := os.Create(tmpath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		return
@@ -324,12 +472,16 @@ func stringToGzFile(base, path, file, str string) {
 	err = wrtr.Flush()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		fl.Close()
+		os.Remove(tmpath)
 		return
 	}
 
 	err = zpr.Close()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		fl.Close()
+		os.Remove(tmpath)
 		return
 	}
 
@@ -338,8 +490,15 @@ func stringToGzFile(base, path, file, str string) {
 	err = fl.Close()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Remove(tmpath)
 		return
 	}
+
+	err = os.Rename(tmpath, fpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Remove(tmpath)
+	}
 }
 
 // e2IndexConsumer callbacks have access to application-specific data as closures
@@ -383,6 +542,9 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 		os.Exit(1)
 	}
 
+	// JSON progress events replace dots if enabled
+	pm := newProgressMonitor("index", "folders", 0)
+
 	// visitArchiveFolders sends an Archive leaf folder path plus the file base names
 	// contained in it down a channel, e.g., [ "02/53/93", "2539300", "2539301", ..., ] for
 	// Archive/02/53/93/*.xml.gz
@@ -428,6 +590,8 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 			if len(xmls) > 1 {
 				// sort fields in alphabetical or numeric order
 				slices.SortFunc(xmls, CompareAlphaOrNumericKeys)
+				// records being migrated between codecs may be present twice
+				xmls = slices.Compact(xmls)
 			}
 
 			var res []string
@@ -441,6 +605,24 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 
 			defer close(out)
 
+			// other storage backends list their keys instead of exposing directories
+			store := OpenArchiveStore(base)
+			if _, ok := store.(*trieStore); !ok {
+				flds := archiveFolders(store, ".xml.gz", ".xml.zst")
+				pm.SetTotal(len(flds))
+				for _, res := range flds {
+					out <- res
+				}
+				return
+			}
+
+			if pm != nil {
+				// count folders separately, so indexing can start right away
+				go func() {
+					pm.SetTotal(countLeafFolders(base))
+				}()
+			}
+
 			dirs, _, _, _ := examineFolder(base, "")
 
 			// iterate through top directories
@@ -499,6 +681,7 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 				_, err := os.Stat(target)
 				if err == nil {
 					// skip if first-level incremental Entrez index file exists for current set of 100 archive records
+					pm.Skip(1)
 					continue
 				}
 
@@ -607,6 +790,7 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 			defer close(out)
 
 			currentIdent := ""
+			numRecs := 0
 
 			var buffer strings.Builder
 
@@ -635,17 +819,21 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 
 					if verbose {
 						fmt.Fprintf(os.Stderr, "IDX %s/%s%s.e2x.gz\n", indBase, indPath, currentIdent)
-					} else {
+					} else if pm == nil {
 						// progress monitor
 						countSuccess()
 					}
 
+					pm.Add(1, numRecs)
+					numRecs = 0
+
 					out <- currentIdent
 				}
 
 				currentIdent = ident
 
 				buffer.WriteString(str)
+				numRecs++
 
 			}
 
@@ -658,8 +846,12 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 				if verbose {
 					fmt.Fprintf(os.Stderr, "IDX %s/%s%s.e2x.gz\n", indBase, indPath, currentIdent)
 				}
+
+				pm.Add(1, numRecs)
 			}
 
+			pm.Finish()
+
 			if rollingColumn > 0 {
 				vlock.Lock()
 				fmt.Fprintf(os.Stderr, "\n")
@@ -685,7 +877,7 @@ func IncrementalIndex(db, pfx, ptrn string, dotMax int, csmr e2IndexConsumer) <-
 
 	vrfq := visitArchiveFolders(archiveBase)
 	vifq := filterIndexFolders(indexBase, vrfq)
-	strq := CreateFetchers(archiveBase, db, pfx, ".xml", ptrn, true, vifq)
+	strq := CreateFetchers(context.Background(), archiveBase, db, pfx, ".xml", ptrn, true, vifq)
 	// callback passes cmds and transform values as closures to xtract createConsumers
 	tblq := csmr(strq)
 	// clean up XML (no measured benefit to adding next record size prefix)
@@ -1058,6 +1250,9 @@ func IncrementalInvert(db string, dotMax int) <-chan string {
 		os.Exit(1)
 	}
 
+	// JSON progress events replace dots if enabled
+	pm := newProgressMonitor("invert", "folders", 0)
+
 	indexFetchers := func(inp <-chan string) <-chan string {
 
 		if inp == nil {
@@ -1147,6 +1342,8 @@ func IncrementalInvert(db string, dotMax int) <-chan string {
 				// save to target file
 				stringToGzFile(invertBase, invPath, invFile+".inv.gz", txt)
 
+				pm.Add(1, len(filenames))
+
 				out <- invFile + ".inv.gz"
 			}
 		}
@@ -1162,6 +1359,7 @@ func IncrementalInvert(db string, dotMax int) <-chan string {
 		// launch separate anonymous goroutine to wait until all inverters are done
 		go func() {
 			wg.Wait()
+			pm.Finish()
 			close(out)
 		}()
 
@@ -1253,6 +1451,7 @@ func IncrementalInvert(db string, dotMax int) <-chan string {
 			_, err := os.Stat(target)
 			if err == nil {
 				// if inverted index file exists for the indexed folder, no need to recreate
+				pm.Skip(1)
 				return
 			}
 
@@ -1271,7 +1470,7 @@ func IncrementalInvert(db string, dotMax int) <-chan string {
 
 			if verbose {
 				fmt.Fprintf(os.Stderr, "INV %s\n", invFile)
-			} else {
+			} else if pm == nil {
 				// progress monitor
 				countSuccess()
 			}
@@ -1283,6 +1482,13 @@ func IncrementalInvert(db string, dotMax int) <-chan string {
 
 			defer close(out)
 
+			if pm != nil {
+				// count folders separately, so inversion can start right away
+				go func() {
+					pm.SetTotal(countLeafFolders(idxBase))
+				}()
+			}
+
 			dirs, _, _, _ := examineFolder(idxBase, "")
 
 			// iterate through top directories
@@ -0,0 +1,36 @@
+package eutils
+
+import (
+	"testing"
+)
+
+func TestAbstractSections(t *testing.T) {
+
+	text := `<PubmedArticle><MedlineCitation><PMID Version="1">200</PMID><Article>` +
+		`<ArticleTitle>Mortality after <i>randomized</i> surgery.</ArticleTitle><Abstract>` +
+		`<AbstractText Label="BACKGROUND" NlmCategory="BACKGROUND">Surgery carries risk &amp; cost.</AbstractText>` +
+		`<AbstractText Label="DESIGN" NlmCategory="METHODS">A randomized trial was done.</AbstractText>` +
+		`<AbstractText Label="SETTING" NlmCategory="METHODS">Ten hospitals enrolled patients.</AbstractText>` +
+		`<AbstractText Label="FINDINGS" NlmCategory="RESULTS">Mortality fell.</AbstractText>` +
+		`<AbstractText Label="INTERPRETATION" NlmCategory="CONCLUSIONS">Randomized surgery lowers mortality.</AbstractText>` +
+		`<AbstractText>Extra unlabeled note.</AbstractText>` +
+		`</Abstract></Article></MedlineCitation></PubmedArticle>`
+
+	want := []AbstractSection{
+		{"BACKGROUND", "BACKGROUND", "BKGD", 101, 104},
+		{"DESIGN", "METHODS", "METH", 201, 205},
+		{"SETTING", "METHODS", "METH", 301, 304},
+		{"FINDINGS", "RESULTS", "RSLT", 401, 402},
+		{"INTERPRETATION", "CONCLUSIONS", "CONC", 501, 504},
+	}
+
+	got := AbstractSections(text)
+	if len(got) != len(want) {
+		t.Fatalf("AbstractSections returned %d sections, want %d: %v", len(got), len(want), got)
+	}
+	for i, sect := range got {
+		if sect != want[i] {
+			t.Errorf("section %d = %v, want %v", i, sect, want[i])
+		}
+	}
+}
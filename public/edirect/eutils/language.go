@@ -0,0 +1,213 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  language.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/french"
+	"github.com/blevesearch/snowballstem/german"
+	"github.com/blevesearch/snowballstem/italian"
+	"github.com/blevesearch/snowballstem/portuguese"
+	"github.com/blevesearch/snowballstem/spanish"
+	"github.com/surgebase/porter2"
+	"strings"
+)
+
+// LANGUAGE ANALYZERS
+
+// Analyzer holds the stop words and stemmer for one language, keyed by MEDLINE Language code.
+// Words are lower case with accents removed, as indexWords and prepareQuery produce them.
+type Analyzer struct {
+	Code  string
+	Name  string
+	stops map[string]bool
+	stem  func(string) string
+}
+
+// IsStopWord uses the analyzer's stop word list, a nil analyzer has none
+func (a *Analyzer) IsStopWord(str string) bool {
+
+	if a == nil || a.stops == nil {
+		return false
+	}
+
+	return a.stops[str]
+}
+
+// Stem applies the analyzer's stemmer, a nil analyzer returns the word unchanged
+func (a *Analyzer) Stem(str string) string {
+
+	if a == nil || a.stem == nil || str == "" {
+		return str
+	}
+
+	return strings.TrimSpace(a.stem(str))
+}
+
+// stopWordSet converts a space-separated word list into a lookup table
+func stopWordSet(str string) map[string]bool {
+
+	set := make(map[string]bool)
+
+	for _, item := range strings.Fields(str) {
+		set[item] = true
+	}
+
+	return set
+}
+
+// snowballStemmer adapts a generated Snowball stemmer, using a new environment for each word
+func snowballStemmer(proc func(*snowballstem.Env) bool) func(string) string {
+
+	return func(str string) string {
+		env := snowballstem.NewEnv(str)
+		proc(env)
+		return env.Current()
+	}
+}
+
+var languageAnalyzers = map[string]*Analyzer{
+	"eng": {
+		Code:  "eng",
+		Name:  "English",
+		stops: isStopWord,
+		stem:  porter2.Stem,
+	},
+	"fre": {
+		Code: "fre",
+		Name: "French",
+		stops: stopWordSet(`a ai au aux avec c ce ces cet cette d dans de des du elle elles en est et
+			etaient etait ete etre eu il ils j je l la le les leur leurs lui m ma mais me meme mes moi
+			mon n ne nos notre nous on ont ou par pas pour qu que qui s sa sans se ses son sont sur t ta
+			te tes toi ton tu un une vos votre vous y`),
+		stem: snowballStemmer(french.Stem),
+	},
+	"ger": {
+		Code: "ger",
+		Name: "German",
+		stops: stopWordSet(`aber als am an auch auf aus bei bin bis das dass dem den der des die dies
+			diese dieser dieses du durch ein eine einem einen einer eines er es fur hat haben ich ihr im
+			in ist mit nach nicht noch nur oder sein sich sie sind so uber um und unter vom von vor war
+			waren was wie wir wird zu zum zur zwischen`),
+		stem: snowballStemmer(german.Stem),
+	},
+	"spa": {
+		Code: "spa",
+		Name: "Spanish",
+		stops: stopWordSet(`a al algo ante con como de del desde donde el ella ellos en entre era es
+			esta este esto estos fue ha han hay la las le les lo los mas me mi muy no nos o para pero por
+			que se sin sobre su sus te tu un una uno unos y ya`),
+		stem: snowballStemmer(spanish.Stem),
+	},
+	"por": {
+		Code: "por",
+		Name: "Portuguese",
+		stops: stopWordSet(`a ao aos as com como da das de do dos e ela ele eles em entre era essa esse
+			esta este foi ha isso isto mais mas na nas nao no nos o os ou para pela pelo por que se sem ser
+			seu seus so sua suas tambem um uma umas uns`),
+		stem: snowballStemmer(portuguese.Stem),
+	},
+	"ita": {
+		Code: "ita",
+		Name: "Italian",
+		stops: stopWordSet(`a ad agli ai al all alla alle allo anche che chi ci come con da dal dall
+			dalla dei del dell della delle dello di e ed gli ha hanno i il in l la le lo ma mi ne negli nel
+			nell nella nelle non o per piu quale questa questo se si sono su sua sul sull sulla tra un una
+			uno`),
+		stem: snowballStemmer(italian.Stem),
+	},
+}
+
+// languageAliases maps ISO 639 codes and English names to MEDLINE Language codes
+var languageAliases = map[string]string{
+	"en":         "eng",
+	"english":    "eng",
+	"fr":         "fre",
+	"fra":        "fre",
+	"french":     "fre",
+	"de":         "ger",
+	"deu":        "ger",
+	"german":     "ger",
+	"es":         "spa",
+	"spanish":    "spa",
+	"pt":         "por",
+	"portuguese": "por",
+	"it":         "ita",
+	"italian":    "ita",
+}
+
+// anyVernacular removes stop words of every non-English analyzer, for vernacular field
+// queries without a language, since an extra placeholder still matches any indexed word
+var anyVernacular = &Analyzer{
+	Code: "",
+	Name: "Any",
+	stops: func() map[string]bool {
+		set := make(map[string]bool)
+		for code, anlz := range languageAnalyzers {
+			if code == "eng" {
+				continue
+			}
+			for item := range anlz.stops {
+				set[item] = true
+			}
+		}
+		return set
+	}(),
+}
+
+// LanguageAnalyzer returns the analyzer for a MEDLINE Language code, ISO 639 code, or
+// English language name, or nil if there is no analyzer for the language
+func LanguageAnalyzer(lang string) *Analyzer {
+
+	lang = strings.ToLower(strings.TrimSpace(lang))
+
+	if code, ok := languageAliases[lang]; ok {
+		lang = code
+	}
+
+	return languageAnalyzers[lang]
+}
+
+// vernacularAnalyzer picks the analyzer for a vernacular title from a record's Language
+// elements, preferring the first language other than English, which may have no analyzer
+func vernacularAnalyzer(langs []string) *Analyzer {
+
+	for _, lang := range langs {
+		anlz := LanguageAnalyzer(lang)
+		if anlz == nil || anlz.Code != "eng" {
+			return anlz
+		}
+	}
+
+	if len(langs) > 0 {
+		return languageAnalyzers["eng"]
+	}
+
+	return nil
+}
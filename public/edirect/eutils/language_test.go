@@ -0,0 +1,42 @@
+package eutils
+
+import "testing"
+
+func TestLanguageAnalyzer(t *testing.T) {
+
+	tests := []struct {
+		lang, word, stem string
+		stop             bool
+	}{
+		{"fre", "chirurgies", "chirurg", false},
+		{"fr", "des", "des", true},
+		{"german", "kindern", "kind", false},
+		{"ger", "fur", "fur", true},
+		{"spa", "tratamientos", "tratamient", false},
+		{"pt", "nao", "nao", true},
+		{"ita", "della", "della", true},
+		{"eng", "running", "run", false},
+	}
+	for _, test := range tests {
+		anlz := LanguageAnalyzer(test.lang)
+		if anlz == nil {
+			t.Fatalf("LanguageAnalyzer(%s) = nil", test.lang)
+		}
+		if test.stop {
+			if !anlz.IsStopWord(test.word) {
+				t.Errorf("%s IsStopWord(%s) = false", anlz.Name, test.word)
+			}
+			continue
+		}
+		if got := anlz.Stem(test.word); got != test.stem {
+			t.Errorf("%s Stem(%s) = %s, want %s", anlz.Name, test.word, got, test.stem)
+		}
+	}
+
+	if LanguageAnalyzer("jpn") != nil {
+		t.Error("LanguageAnalyzer(jpn) should be nil")
+	}
+	if anlz := vernacularAnalyzer([]string{"eng", "spa"}); anlz == nil || anlz.Code != "spa" {
+		t.Errorf("vernacularAnalyzer(eng, spa) = %v", anlz)
+	}
+}
@@ -0,0 +1,296 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  ledger.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// INGESTION LEDGER
+
+// The ledger records each PubMed source file with its SHA-256 checksum and the last
+// pipeline stage it has completed. Archiving is tracked per file, while the later
+// stages process everything archived so far, so AdvanceLedger moves all files waiting
+// at the preceding stage forward together. The ledger is an append-only log in the
+// archive Sentinels folder, where the last line for a file gives its current state,
+// so an interrupted update leaves earlier entries intact and can be resumed.
+
+// IngestStages lists pipeline stages in order
+var IngestStages = []string{"archive", "index", "invert", "collect", "merge", "promote"}
+
+// LedgerName is the ledger file in the archive Sentinels folder
+const LedgerName = "ledger.tsv"
+
+// LedgerEntry is the current state of one source file
+type LedgerEntry struct {
+	File     string
+	Checksum string
+	Stage    string
+	Time     time.Time
+}
+
+var ledgerLock sync.Mutex
+
+func ledgerPath(arch string) string {
+
+	return filepath.Join(arch, "Sentinels", LedgerName)
+}
+
+func stageIndex(stage string) int {
+
+	return slices.Index(IngestStages, stage)
+}
+
+// checkStage reports an unrecognized stage name
+func checkStage(stage string) error {
+
+	if stageIndex(stage) < 0 {
+		return fmt.Errorf("unrecognized ingestion stage '%s', expected %s", stage, strings.Join(IngestStages, ", "))
+	}
+
+	return nil
+}
+
+// ReadLedger returns the current state of each file, in order of first appearance
+func ReadLedger(arch string) ([]LedgerEntry, error) {
+
+	fl, err := os.Open(ledgerPath(arch))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fl.Close()
+
+	var res []LedgerEntry
+	where := make(map[string]int)
+
+	scanr := bufio.NewScanner(fl)
+	for scanr.Scan() {
+		// time, file, checksum, stage
+		cols := strings.Split(scanr.Text(), "\t")
+		if len(cols) != 4 || stageIndex(cols[3]) < 0 {
+			// ignore partial line from interrupted write
+			continue
+		}
+		tm, _ := time.Parse(time.RFC3339, cols[0])
+		ent := LedgerEntry{File: cols[1], Checksum: cols[2], Stage: cols[3], Time: tm}
+		if idx, ok := where[ent.File]; ok {
+			res[idx] = ent
+		} else {
+			where[ent.File] = len(res)
+			res = append(res, ent)
+		}
+	}
+
+	return res, scanr.Err()
+}
+
+// appendLedger writes new states and syncs the ledger to disk
+func appendLedger(arch string, ents []LedgerEntry) error {
+
+	if len(ents) < 1 {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(ledgerPath(arch)), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fl, err := os.OpenFile(ledgerPath(arch), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	var buffer strings.Builder
+	for _, ent := range ents {
+		fmt.Fprintf(&buffer, "%s\t%s\t%s\t%s\n", ent.Time.UTC().Format(time.RFC3339), ent.File, ent.Checksum, ent.Stage)
+	}
+
+	_, err = fl.WriteString(buffer.String())
+	if err == nil {
+		err = fl.Sync()
+	}
+	if cerr := fl.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// fileChecksum returns the SHA-256 of a file
+func fileChecksum(path string) (string, error) {
+
+	fl, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fl.Close()
+
+	hsh := sha256.New()
+	_, err = io.Copy(hsh, fl)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hsh.Sum(nil)), nil
+}
+
+// RecordIngest notes that a source file has completed a stage, doing nothing if the
+// unchanged file is already at or past that stage, and reports whether the file's
+// checksum differs from the one previously recorded
+func RecordIngest(arch, path, stage string) (bool, error) {
+
+	err := checkStage(stage)
+	if err != nil {
+		return false, err
+	}
+
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return false, err
+	}
+
+	name := filepath.Base(path)
+
+	ledgerLock.Lock()
+	defer ledgerLock.Unlock()
+
+	ents, err := ReadLedger(arch)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+
+	for _, ent := range ents {
+		if ent.File != name {
+			continue
+		}
+		if ent.Checksum == sum && stageIndex(ent.Stage) >= stageIndex(stage) {
+			return false, nil
+		}
+		changed = ent.Checksum != sum
+	}
+
+	return changed, appendLedger(arch, []LedgerEntry{{File: name, Checksum: sum, Stage: stage, Time: time.Now()}})
+}
+
+// moveLedger sets every file at stage from to stage to, returning the number moved
+func moveLedger(arch string, from func(int) bool, to string) (int, error) {
+
+	ledgerLock.Lock()
+	defer ledgerLock.Unlock()
+
+	ents, err := ReadLedger(arch)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	var moved []LedgerEntry
+	for _, ent := range ents {
+		if from(stageIndex(ent.Stage)) {
+			ent.Stage = to
+			ent.Time = now
+			moved = append(moved, ent)
+		}
+	}
+
+	return len(moved), appendLedger(arch, moved)
+}
+
+// AdvanceLedger marks a stage complete for all files that had completed the previous stage
+func AdvanceLedger(arch, stage string) (int, error) {
+
+	err := checkStage(stage)
+	if err != nil {
+		return 0, err
+	}
+
+	idx := stageIndex(stage)
+	if idx < 1 {
+		return 0, fmt.Errorf("%s is recorded per file", stage)
+	}
+
+	return moveLedger(arch, func(i int) bool { return i == idx-1 }, stage)
+}
+
+// ResetLedger returns files past the previous stage to that stage, so a complete
+// rebuild starting at stage is tracked from the beginning
+func ResetLedger(arch, stage string) (int, error) {
+
+	err := checkStage(stage)
+	if err != nil {
+		return 0, err
+	}
+
+	idx := stageIndex(stage)
+	if idx < 1 {
+		return 0, fmt.Errorf("%s is recorded per file", stage)
+	}
+
+	return moveLedger(arch, func(i int) bool { return i >= idx }, IngestStages[idx-1])
+}
+
+// PendingFiles returns files waiting for a stage, having completed the previous one
+func PendingFiles(arch, stage string) ([]string, error) {
+
+	err := checkStage(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	ents, err := ReadLedger(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, ent := range ents {
+		if stageIndex(ent.Stage) == stageIndex(stage)-1 {
+			res = append(res, ent.File)
+		}
+	}
+
+	return res, nil
+}
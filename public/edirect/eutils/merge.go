@@ -82,6 +82,71 @@ func (h *PlexHeap) Pop() interface{} {
 	return x
 }
 
+// MERGE RESOURCE LIMITS
+
+// A single-pass merge opens every inverted file at once, and each open file holds a
+// decompression buffer and a channel of waiting records. SpillMergeInputs joins groups
+// of files into temporary spill files until the remainder fits under the open file
+// limit, and the memory budget is divided among the files open in each pass.
+//
+// The budget only sizes those per-file input buffers, in CreatePresenters. It is not
+// enforced on the postings that CreateMergers collects for one term, on records held
+// by the unshuffler while it restores order, or on the splitter's output, so a merge
+// can still use more memory than the budget when a term has very many postings.
+
+var (
+	mergeMaxOpen = 256
+	mergeMemory  int64
+)
+
+// SetMergeLimits sets the maximum number of inverted files open at once, and the
+// memory budget in bytes for their decompression blocks and channel depth (zero keeps
+// default buffer sizes)
+func SetMergeLimits(maxOpen int, memory int64) {
+
+	if maxOpen > 1 {
+		mergeMaxOpen = maxOpen
+	}
+	if memory >= 0 {
+		mergeMemory = memory
+	}
+}
+
+// merged postings are restricted to these fields if any are set
+var mergeFields map[string]bool
+
+// SetMergeFields limits -merge output to postings for the named fields, separated by
+// spaces, so link fields can be merged separately with the LinksTrie layout
+func SetMergeFields(fields string) {
+
+	mergeFields = nil
+
+	for _, fld := range strings.Fields(fields) {
+		if mergeFields == nil {
+			mergeFields = make(map[string]bool)
+		}
+		mergeFields[fld] = true
+	}
+}
+
+// presenterBuffers divides the memory budget among open files, returning channel
+// depth plus decompression block size and count (zero for pgzip defaults)
+func presenterBuffers(numFiles int) (int, int, int) {
+
+	if mergeMemory < 1 || numFiles < 1 {
+		return chanDepth, 0, 0
+	}
+
+	share := mergeMemory / int64(numFiles)
+
+	// half for two decompression blocks, half for records waiting in the channel
+	blockSize := min(max(share/4, 32*1024), 1024*1024)
+
+	depth := int(min(max(share/2/(16*1024), 1), int64(chanDepth)))
+
+	return depth, int(blockSize), 2
+}
+
 // CreatePresenters creates one channel per input file
 func CreatePresenters(files []string) []<-chan Plex {
 
@@ -101,6 +166,18 @@ func CreatePresenters(files []string) []<-chan Plex {
 		os.Exit(1)
 	}
 
+	depth, blockSize, blocks := presenterBuffers(numFiles)
+
+	// total input size lets the splitter estimate time remaining
+	mergeInputRead.Store(0)
+	mergeInputSize.Store(0)
+	for _, str := range files {
+		inf, err := os.Stat(str)
+		if err == nil {
+			mergeInputSize.Add(inf.Size())
+		}
+	}
+
 	// xmlPresenter sends partitioned XML strings through channel
 	xmlPresenter := func(fileNum int, fileName string, out chan<- Plex) {
 
@@ -118,7 +195,8 @@ func CreatePresenters(files []string) []<-chan Plex {
 
 		var in io.Reader
 
-		in = f
+		// count bytes read from disk, so progress events can estimate time remaining
+		in = byteCounter{rdr: f, count: &mergeInputRead}
 
 		// if suffix is ".gz", use decompressor
 		iszip := false
@@ -127,13 +205,19 @@ func CreatePresenters(files []string) []<-chan Plex {
 		}
 
 		if iszip {
-			brd := bufio.NewReader(f)
+			brd := bufio.NewReader(in)
 			if brd == nil {
 				DisplayError("Unable to create buffered reader on '%s'", fileName)
 				os.Exit(1)
 			}
 			// using parallel pgzip for better performance on large files
-			zpr, err := pgzip.NewReader(brd)
+			var zpr *pgzip.Reader
+			if blockSize > 0 {
+				// smaller read-ahead blocks under a memory budget
+				zpr, err = pgzip.NewReaderN(brd, blockSize, blocks)
+			} else {
+				zpr, err = pgzip.NewReader(brd)
+			}
 			if err != nil {
 				DisplayError("Unable to create decompressor on '%s'", fileName)
 				os.Exit(1)
@@ -167,7 +251,7 @@ func CreatePresenters(files []string) []<-chan Plex {
 	// launch multiple presenter goroutines
 	for i, str := range files {
 
-		chn := make(chan Plex, chanDepth)
+		chn := make(chan Plex, depth)
 		if chn == nil {
 			DisplayError("Unable to create presenter channel")
 			os.Exit(1)
@@ -405,6 +489,146 @@ func CreateJoiners(inp <-chan Plex) <-chan XMLRecord {
 	return out
 }
 
+// joinToSpillFile writes a sorted, unfused join of inverted files, returning the number of terms
+func joinToSpillFile(files []string, fpath string) int {
+
+	chns := CreatePresenters(files)
+	mfld := CreateManifold(chns)
+	jnrs := CreateJoiners(mfld)
+	unsq := CreateXMLUnshuffler(jnrs)
+
+	if chns == nil || mfld == nil || jnrs == nil || unsq == nil {
+		DisplayError("Unable to create inverted index spill joiner")
+		os.Exit(1)
+	}
+
+	// write to temporary file, then rename when complete
+	tmpath := fpath + ".tmp"
+
+	fl, err := os.Create(tmpath)
+	if err != nil {
+		DisplayError("Unable to create spill file '%s'", tmpath)
+		os.Exit(1)
+	}
+
+	zpr, err := pgzip.NewWriterLevel(fl, pgzip.BestSpeed)
+	if err != nil {
+		DisplayError("Unable to create compressor")
+		os.Exit(1)
+	}
+
+	wrtr := bufio.NewWriter(zpr)
+
+	wrtr.WriteString("<InvDocumentSet>\n")
+
+	num := 0
+
+	for curr := range unsq {
+
+		str := curr.Text
+
+		if str == "" {
+			continue
+		}
+
+		wrtr.WriteString(str)
+		num++
+	}
+
+	wrtr.WriteString("</InvDocumentSet>\n")
+
+	err = wrtr.Flush()
+	if err == nil {
+		err = zpr.Close()
+	}
+	if err == nil {
+		err = fl.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpath, fpath)
+	}
+	if err != nil {
+		DisplayError("Unable to write spill file '%s': %s", fpath, err.Error())
+		os.Exit(1)
+	}
+
+	return num
+}
+
+// SpillMergeInputs reduces the number of inverted files to the open file limit by
+// joining groups of files into spill files under spillBase (default Merged folder).
+// Each spill replaces just enough files to reach the limit, and spill files can be
+// joined again in later passes. It returns the files for the final merge, and a
+// function that removes the spill folder once the merge is done.
+func SpillMergeInputs(files []string, db, spillBase string) ([]string, func()) {
+
+	cleanup := func() {}
+
+	if len(files) <= mergeMaxOpen {
+		return files, cleanup
+	}
+
+	if spillBase == "" {
+
+		_, working := GetLocalArchivePaths(db)
+
+		if working == "" {
+			DisplayError("Unable to get local merge path")
+			os.Exit(1)
+		}
+
+		spillBase = working + "Merged"
+	}
+
+	spillDir, err := os.MkdirTemp(spillBase, "spill")
+	if err != nil {
+		DisplayError("Unable to create spill folder in '%s'", spillBase)
+		os.Exit(1)
+	}
+
+	cleanup = func() {
+		os.RemoveAll(spillDir)
+	}
+
+	// count joins in advance for progress estimates
+	joins := 0
+	for num := len(files); num > mergeMaxOpen; joins++ {
+		num -= min(mergeMaxOpen, num-mergeMaxOpen+1) - 1
+	}
+
+	pm := newProgressMonitor("spill", "joins", joins)
+
+	// copy so caller's slice is not reordered
+	files = slices.Clone(files)
+
+	for idx := 0; len(files) > mergeMaxOpen; idx++ {
+
+		// join just enough files to reach the limit, up to the limit at a time
+		size := min(mergeMaxOpen, len(files)-mergeMaxOpen+1)
+		grp := files[:size]
+
+		fpath := filepath.Join(spillDir, fmt.Sprintf("spill%04d.inv.gz", idx))
+		num := joinToSpillFile(grp, fpath)
+
+		// earlier spill files are no longer needed
+		for _, str := range grp {
+			if filepath.Dir(str) == spillDir {
+				os.Remove(str)
+			}
+		}
+
+		files = append(files[size:], fpath)
+
+		pm.Add(1, num)
+
+		debug.FreeOSMemory()
+	}
+
+	pm.Finish()
+
+	return files, cleanup
+}
+
 // CreateMergers combines collected indices for the same term
 func CreateMergers(inp <-chan Plex) <-chan XMLRecord {
 
@@ -441,7 +665,7 @@ func CreateMergers(inp <-chan Plex) <-chan XMLRecord {
 
 			addUID := func(tag, attr, content string) {
 
-				if tag != "InvKey" {
+				if tag != "InvKey" && (mergeFields == nil || mergeFields[tag]) {
 
 					addIdents(tag, attr, content)
 				}
@@ -451,6 +675,11 @@ func CreateMergers(inp <-chan Plex) <-chan XMLRecord {
 				StreamValues(str[:], "InvDocument", addUID)
 			}
 
+			// term has no postings in the selected fields
+			if len(fields) < 1 {
+				return ""
+			}
+
 			// sort fields in alphabetical order
 			keys := slices.Sorted(maps.Keys(fields))
 
@@ -498,6 +727,7 @@ func CreateMergers(inp <-chan Plex) <-chan XMLRecord {
 				continue
 			}
 
+			// empty text keeps the record index sequence for the unshuffler
 			str := fusePostings(key, data)
 
 			out <- XMLRecord{Index: rec, Ident: key, Text: str}
@@ -524,7 +754,7 @@ func CreateMergers(inp <-chan Plex) <-chan XMLRecord {
 }
 
 // CreateSplitter distributes adjacent records with the same identifier prefix
-func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecord) <-chan string {
+func CreateSplitter(mergePath, db string, zipp, isLink bool, files []string, inp <-chan XMLRecord) <-chan string {
 
 	if inp == nil {
 		return nil
@@ -540,6 +770,9 @@ func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecor
 	}
 
 	mergeBase := working + "Merged"
+	if mergePath != "" {
+		mergeBase = mergePath
+	}
 
 	// check to make sure local merge directory is mounted
 	_, err := os.Stat(mergeBase)
@@ -554,6 +787,37 @@ func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecor
 		os.Exit(1)
 	}
 
+	sfx := ".mrg"
+	if zipp {
+		sfx += ".gz"
+	}
+
+	// a restarted merge of the same inverted files skips prefixes that were already written
+	hdr := fmt.Sprintf("merge\t%s\t%s", sfx, fileSignature(files))
+	if isLink {
+		hdr += "\tlink"
+	}
+	cp := openCheckpoint(filepath.Join(mergeBase, MergeCheckpoint), hdr)
+	if cp != nil && len(cp.done) < 1 {
+		// new merged files start a new promotion
+		master, _ := GetLocalArchivePaths(db)
+		if master != "" {
+			os.Remove(filepath.Join(master+"Postings", PromoteCheckpoint))
+		}
+	}
+
+	// estimate time remaining from fraction of inverted files read
+	pm := newProgressMonitor("merge", "prefixes", 0)
+	if pm != nil {
+		pm.fraction = func() float64 {
+			total := mergeInputSize.Load()
+			if total < 1 {
+				return 0
+			}
+			return float64(mergeInputRead.Load()) / float64(total)
+		}
+	}
+
 	openSaver := func(mergeBase, key string, zipp bool) (*os.File, *bufio.Writer, *pgzip.Writer) {
 
 		var (
@@ -563,11 +827,6 @@ func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecor
 			err  error
 		)
 
-		sfx := ".mrg"
-		if zipp {
-			sfx += ".gz"
-		}
-
 		fpath := filepath.Join(mergeBase, key+sfx)
 		if fpath == "" {
 			return nil, nil, nil
@@ -635,8 +894,8 @@ func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecor
 		currTag := ""
 		prevTag := ""
 
-		// remember previous record
-		prev := XMLRecord{}
+		numTerms := 0
+		skipping := false
 
 		getCurrTag := func(ident string) string {
 
@@ -680,52 +939,79 @@ func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecor
 			return tag
 		}
 
+		// closeCurrent finishes the open prefix file and records it in the checkpoint
+		closeCurrent := func() {
+
+			// send closing tag
+			wrtr.WriteString("</InvDocumentSet>\n")
+
+			closeSaver(fl, wrtr, zpr)
+			fl = nil
+
+			cp.Record(prevTag)
+			pm.Add(1, numTerms)
+
+			out <- prevTag
+
+			// force garbage collection
+			runtime.GC()
+			debug.FreeOSMemory()
+
+			runtime.Gosched()
+		}
+
 		for curr := range inp {
 
+			// skip terms without postings in the selected fields
+			if curr.Text == "" {
+				continue
+			}
+
 			// use first few characters of identifier
 			currTag = getCurrTag(curr.Ident)
 			if currTag == "" {
 				continue
 			}
 
-			if fl == nil {
-				// open initial file
-				fl, wrtr, zpr = openSaver(mergeBase, currTag, zipp)
-				if wrtr == nil {
-					continue
-				}
-
-				// send first opening tag and indent
-				wrtr.WriteString("<InvDocumentSet>\n  ")
-			}
-
 			// compare keys from adjacent term lists
-			if prev.Text != "" && prevTag != currTag {
+			if currTag != prevTag {
 
 				// after IdentifierKey converts space to underscore,
 				// okay that x_ and x0 will be out of alphabetical order
 
-				// send closing tag
-				wrtr.WriteString("</InvDocumentSet>\n")
-
-				closeSaver(fl, wrtr, zpr)
-
-				out <- currTag
+				if fl != nil {
+					closeCurrent()
+				}
 
-				// force garbage collection
-				runtime.GC()
-				debug.FreeOSMemory()
+				numTerms = 0
 
-				runtime.Gosched()
+				// skip prefix if an interrupted run already wrote it
+				skipping = false
+				if cp.Completed(currTag) {
+					_, err := os.Stat(filepath.Join(mergeBase, currTag+sfx))
+					if err == nil {
+						skipping = true
+						pm.Skip(1)
+					}
+				}
 
-				// open next file
-				fl, wrtr, zpr = openSaver(mergeBase, currTag, zipp)
-				if wrtr == nil {
-					continue
+				if !skipping {
+					// open next file
+					fl, wrtr, zpr = openSaver(mergeBase, currTag, zipp)
+					if wrtr == nil {
+						fl = nil
+						skipping = true
+					} else {
+						// send opening tag and indent
+						wrtr.WriteString("<InvDocumentSet>\n  ")
+					}
 				}
 
-				// send opening tag and indent
-				wrtr.WriteString("<InvDocumentSet>\n  ")
+				prevTag = currTag
+			}
+
+			if skipping {
+				continue
 			}
 
 			// send one InvDocument
@@ -736,27 +1022,15 @@ func CreateSplitter(mergePath, db string, zipp, isLink bool, inp <-chan XMLRecor
 				wrtr.WriteString("\n")
 			}
 
-			// now remember this record
-			prev = curr
-
-			prevTag = currTag
+			numTerms++
 		}
 
-		if prev.Text != "" {
-
-			// send last closing tag
-			wrtr.WriteString("</InvDocumentSet>\n")
-
-			closeSaver(fl, wrtr, zpr)
-
-			out <- currTag
-
-			// force garbage collection
-			runtime.GC()
-			debug.FreeOSMemory()
-
-			runtime.Gosched()
+		if fl != nil {
+			closeCurrent()
 		}
+
+		pm.Finish()
+		cp.Close()
 	}
 
 	// launch single splitter goroutine
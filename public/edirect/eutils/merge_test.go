@@ -0,0 +1,139 @@
+package eutils
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeInvertedFiles creates sorted inverted files in which many terms appear in
+// several files, alternating plain and gzip files
+func writeInvertedFiles(t *testing.T, dir string, count int) []string {
+
+	terms := []string{"ablation", "cancer", "cell", "heart", "protein", "surgery", "tumor", "zebrafish"}
+
+	var files []string
+
+	for f := range count {
+
+		var buf strings.Builder
+		buf.WriteString("<InvDocumentSet>\n")
+		for i, term := range terms {
+			if (f+i)%3 == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "<InvDocument>\n<InvKey>%s</InvKey>\n<InvIDs>\n", term)
+			fmt.Fprintf(&buf, "<TIAB pos=\"%d\">%d</TIAB>\n", i+1, 1000*(f+1)+i)
+			if i%2 == 0 {
+				fmt.Fprintf(&buf, "<TITL>%d</TITL>\n", 1000*(f+1)+i)
+			}
+			buf.WriteString("</InvIDs>\n</InvDocument>\n")
+		}
+		buf.WriteString("</InvDocumentSet>\n")
+
+		fpath := filepath.Join(dir, fmt.Sprintf("pubmed%03d.inv", f))
+		data := []byte(buf.String())
+		if f%2 == 1 {
+			fpath += ".gz"
+			data = GzipString(buf.String())
+		}
+		err := os.WriteFile(fpath, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fpath)
+	}
+
+	return files
+}
+
+// mergeInto runs the -merge pipeline, spilling as needed, and returns the merged files
+func mergeInto(t *testing.T, files []string, mergeBase string) map[string]string {
+
+	err := os.MkdirAll(mergeBase, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, cleanup := SpillMergeInputs(files, "pubmed", mergeBase)
+	if len(inputs) > mergeMaxOpen {
+		t.Errorf("spilling left %d files, limit is %d", len(inputs), mergeMaxOpen)
+	}
+
+	sptr := CreateSplitter(mergeBase, "pubmed", false, false, files, CreateXMLUnshuffler(CreateMergers(CreateManifold(CreatePresenters(inputs)))))
+	for range sptr {
+	}
+	cleanup()
+
+	res := make(map[string]string)
+
+	entries, err := os.ReadDir(mergeBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range entries {
+		if ent.IsDir() {
+			t.Errorf("%s remains after merge", ent.Name())
+			continue
+		}
+		if ent.Name() == MergeCheckpoint {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(mergeBase, ent.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[ent.Name()] = string(data)
+	}
+
+	return res
+}
+
+func TestSpillMergeEquivalence(t *testing.T) {
+
+	SetTunings(0, 0, 0, 0, 0, 0, 0, false)
+
+	master := t.TempDir()
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+	t.Setenv("EDIRECT_PUBMED_WORKING", master)
+
+	files := writeInvertedFiles(t, t.TempDir(), 7)
+
+	defer SetMergeLimits(256, 0)
+
+	SetMergeLimits(len(files), 0)
+	want := mergeInto(t, files, filepath.Join(master, "single"))
+	if len(want) < 2 {
+		t.Fatalf("single-pass merge wrote %d prefix files, expected several", len(want))
+	}
+
+	tests := []struct {
+		name    string
+		maxOpen int
+		memory  int64
+	}{
+		{"two open files", 2, 0},
+		{"three open files", 3, 0},
+		{"two open files with memory budget", 2, 1024 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMergeLimits(tt.maxOpen, tt.memory)
+			got := mergeInto(t, files, filepath.Join(t.TempDir(), "spill"))
+			names := slices.Sorted(maps.Keys(got))
+			if len(got) != len(want) {
+				t.Fatalf("spilled merge wrote %v, want %d files", names, len(want))
+			}
+			for _, name := range names {
+				if got[name] != want[name] {
+					t.Errorf("%s differs from single-pass merge:\n%s\nwant:\n%s", name, got[name], want[name])
+				}
+			}
+		})
+	}
+}
@@ -2640,6 +2640,41 @@ func NormalizeAuthor(str string) string {
 	return str
 }
 
+// NormalizeORCID reduces an ORCID identifier or URL to its 16 lower-case characters without
+// hyphens, for consistent index and query keys, returning an empty string if malformed
+func NormalizeORCID(str string) string {
+
+	if str == "" {
+		return str
+	}
+
+	var buffer strings.Builder
+
+	for _, ch := range strings.ToLower(str) {
+		if (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'z') {
+			buffer.WriteRune(ch)
+		}
+	}
+
+	// drop https://orcid.org/ or other prefix
+	str = buffer.String()
+	if len(str) < 16 {
+		return ""
+	}
+	str = str[len(str)-16:]
+
+	// fifteen digits followed by digit or X check character
+	if !IsAllDigits(str[:15]) {
+		return ""
+	}
+	last := str[15]
+	if last != 'x' && (last < '0' || last > '9') {
+		return ""
+	}
+
+	return str
+}
+
 // NormalizeJournal is used for citation matching
 func NormalizeJournal(str string) string {
 
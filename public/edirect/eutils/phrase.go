@@ -33,9 +33,10 @@ package eutils
 import (
 	"bufio"
 	"cmp"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"github.com/surgebase/porter2"
 	"html"
 	"io"
 	"maps"
@@ -309,9 +310,25 @@ var (
 	meshTree alias
 )
 
+// ResetQueryTables discards loaded MeSH alias tables and index schemas, which are then
+// reread on next use. It must only be called when no queries are being evaluated.
+func ResetQueryTables() {
+
+	for _, a := range []*alias{&meshName, &meshTree} {
+		a.lock.Lock()
+		a.table = make(map[string]string)
+		a.isLoaded = false
+		a.lock.Unlock()
+	}
+
+	schemaLock.Lock()
+	schemaMap = make(map[string]*IndexSchema)
+	schemaLock.Unlock()
+}
+
 func printTermCount(base, term, field string) int {
 
-	data, _ := getPostingIDs(base, term, field, true, false)
+	data, _ := getPostingIDs(context.Background(), base, term, field, true, false)
 	size := len(data)
 	fmt.Fprintf(os.Stdout, "%d\t%s\n", size, term)
 
@@ -406,7 +423,7 @@ func printTermCounts(base, term, field string) int {
 
 func printTermPositions(base, term, field string) int {
 
-	data, ofst := getPostingIDs(base, term, field, false, false)
+	data, ofst := getPostingIDs(context.Background(), base, term, field, false, false)
 	size := len(data)
 	fmt.Fprintf(os.Stdout, "\n%d\t%s\n\n", size, term)
 
@@ -431,22 +448,39 @@ func parseField(db, str string) (string, string) {
 		field = "TEXT"
 	}
 
+	schema := postingsSchema(db)
+	if schema != nil && schema.Default != "" {
+		field = schema.Default
+	}
+
 	if strings.HasSuffix(str, "]") {
 		pos := strings.Index(str, "[")
 		if pos >= 0 {
 			field = str[pos:]
 			field = strings.TrimPrefix(field, "[")
 			field = strings.TrimSuffix(field, "]")
+			// range expansion leaves lower-case qualifiers, postings folders are upper case
+			field = strings.ToUpper(field)
 			str = str[:pos]
 			str = strings.TrimSpace(str)
 		}
 		switch field {
 		case "NORM":
 			field = "TIAB"
+		case "GSYM":
+			// official gene symbol is indexed once, as PREF
+			field = "PREF"
+			str = strings.Replace(str, " ", "_", -1)
 		case "STEM", "TIAB", "TITL", "ABST", "TEXT", "TERM":
+		case "BKGD", "OBJT", "METH", "RSLT", "CONC":
+		case "AFFL":
+		case "VERN", "VSTM":
 		case "PIPE":
 		default:
-			str = strings.Replace(str, " ", "_", -1)
+			// positional schema fields are searched as phrases, like TIAB
+			if fs := schema.Field(field); fs == nil || !fs.Positional {
+				str = strings.Replace(str, " ", "_", -1)
+			}
 		}
 	}
 
@@ -455,14 +489,40 @@ func parseField(db, str string) (string, string) {
 
 // QUERY EVALUATION FUNCTION
 
-func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink bool) (int, []int32) {
+func evaluateQuery(ctx context.Context, base, db, phrase string, clauses []string, noStdout, isLink bool) (int, []int32, error) {
 
 	if clauses == nil || clauses[0] == "" {
-		return 0, nil
+		return 0, nil, nil
 	}
 
+	// hold the postings lock, so -advance does not publish a new generation in mid-query
+	unlock, err := lockPostings(ctx, base)
+	if err != nil {
+		return 0, nil, queryError(err)
+	}
+	defer unlock()
+
 	count := 0
 
+	// first cancellation, timeout, or budget error stops further postings reads,
+	// remaining tokens are still parsed, but with empty results
+	var abort error
+
+	stopped := func() bool {
+		if abort == nil {
+			abort = checkQuery(ctx)
+		}
+		return abort != nil
+	}
+
+	// syntax error also stops the query, returns empty token to end parsing
+	malformed := func(reason, term string) string {
+		if abort == nil {
+			abort = &QueryError{Reason: reason, Term: term}
+		}
+		return ""
+	}
+
 	// flag set if no tildes, indicates no proximity tests in query
 	noProx := true
 	for _, tkn := range clauses {
@@ -557,6 +617,10 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 
 	eval := func(str string) ([]int32, [][]uint16, int) {
 
+		if stopped() {
+			return nil, nil, 0
+		}
+
 		// extract optional [FIELD] qualifier
 		field, str := parseField(db, str)
 
@@ -595,8 +659,11 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 				return nil, nil, 0
 			}
 			term = strings.Replace(term, "_", " ", -1)
-			data, _ := getPostingIDs(base, term, field, true, isLink)
+			data, _ := getPostingIDs(ctx, base, term, field, true, isLink)
 			count++
+			if stopped() {
+				return nil, nil, 0
+			}
 			return data, nil, 1
 		}
 
@@ -617,7 +684,7 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 				continue
 			}
 
-			fetch := postingIDsFuture(base, term, field, dist, isLink)
+			fetch := postingIDsFuture(ctx, base, term, field, dist, isLink)
 
 			futures = append(futures, fetch)
 
@@ -628,8 +695,17 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 
 		for _, chn := range futures {
 
-			// fetch postings data
-			fut := <-chn
+			var fut Arrays
+
+			// fetch postings data, unless request is cancelled first
+			select {
+			case fut = <-chn:
+			case <-ctx.Done():
+			}
+
+			if stopped() {
+				return nil, nil, 0
+			}
 
 			if len(fut.Data) < 1 {
 				// bail if word not present
@@ -657,7 +733,7 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 
 			// add subsequent words, keep starting positions of phrases that contain all words in proper position
 			data, ofst = extendPositionalIDs(data, ofst, intersect[i].Data, intersect[i].Ofst, intersect[i].Dist, phrasePositions)
-			if len(data) < 1 {
+			if len(data) < 1 || stopped() {
 				// bail if phrase not present
 				return nil, nil, 0
 			}
@@ -683,13 +759,11 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 		clauses = clauses[1:]
 
 		if tkn == "(" && prevTkn != "" && prevTkn != "&" && prevTkn != "|" && prevTkn != "!" {
-			DisplayError("Tokens '%s' and '%s' should be separated by AND, OR, or NOT", prevTkn, tkn)
-			os.Exit(1)
+			return malformed("Tokens should be separated by AND, OR, or NOT", prevTkn+"' and '"+tkn)
 		}
 
 		if prevTkn == ")" && tkn != "" && tkn != "&" && tkn != "|" && tkn != "!" && tkn != ")" {
-			DisplayError("Tokens '%s' and '%s' should be separated by AND, OR, or NOT", prevTkn, tkn)
-			os.Exit(1)
+			return malformed("Tokens should be separated by AND, OR, or NOT", prevTkn+"' and '"+tkn)
 		}
 
 		prevTkn = tkn
@@ -721,18 +795,14 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 			if tkn == ")" {
 				tkn = nextToken()
 			} else {
-				DisplayError("Expected ')' but received '%s'", tkn)
-				os.Exit(1)
+				tkn = malformed("Expected ')' but received", tkn)
 			}
 		} else if tkn == ")" {
-			DisplayError("Unexpected ')' token")
-			os.Exit(1)
+			tkn = malformed("Unexpected token", tkn)
 		} else if tkn == "&" || tkn == "|" || tkn == "!" {
-			DisplayError("Unexpected operator '%s' in expression", tkn)
-			os.Exit(1)
+			tkn = malformed("Unexpected operator in expression", tkn)
 		} else if tkn == "" {
-			DisplayError("Unexpected end of expression in '%s'", phrase)
-			os.Exit(1)
+			tkn = malformed("Unexpected end of expression in", phrase)
 		} else {
 			// evaluate current phrase
 			data, ofst, delta = eval(tkn)
@@ -815,15 +885,18 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 	result, tkn := expr()
 
 	if tkn != "" {
-		DisplayError("Unexpected token '%s' at end of expression", tkn)
-		os.Exit(1)
+		malformed("Unexpected token at end of expression", tkn)
+	}
+
+	if stopped() {
+		return count, nil, abort
 	}
 
 	// sort final result
 	slices.Sort(result)
 
 	if noStdout {
-		return count, result
+		return count, result, nil
 	}
 
 	// use buffers to speed up uid printing
@@ -847,7 +920,7 @@ func evaluateQuery(base, db, phrase string, clauses []string, noStdout, isLink b
 
 	runtime.Gosched()
 
-	return count, nil
+	return count, nil, nil
 }
 
 // QUERY PARSING FUNCTIONS
@@ -1099,7 +1172,7 @@ func prepareExact(str, sfx string, deStop bool) string {
 	return tmp
 }
 
-func processStopWords(str string, deStop bool) string {
+func processStopWords(db, str string, deStop bool, lang string) string {
 
 	if str == "" {
 		return ""
@@ -1107,6 +1180,14 @@ func processStopWords(str string, deStop bool) string {
 
 	var chain []string
 
+	schema := postingsSchema(db)
+
+	// vernacular fields use the requested language, or remove stop words of any language
+	vern := LanguageAnalyzer(lang)
+	if vern == nil {
+		vern = anyVernacular
+	}
+
 	terms := strings.Fields(str)
 
 	nextField := func(terms []string) (string, int) {
@@ -1130,27 +1211,49 @@ func processStopWords(str string, deStop bool) string {
 
 		stps := false
 		rlxd := false
+		anlz := languageAnalyzers["eng"]
 		switch fld {
 		case "[NORM]":
 			fld = "[TIAB]"
 			fallthrough
 		case "[TIAB]", "[TITL]", "[ABST]", "[TEXT]", "[TERM]":
 			stps = true
+		case "[BKGD]", "[OBJT]", "[METH]", "[RSLT]", "[CONC]":
+			stps = true
+		case "[AFFL]":
+			stps = true
 		case "[STEM]":
 			stps = true
 			rlxd = true
+		case "[VERN]":
+			stps = true
+			anlz = vern
+		case "[VSTM]":
+			stps = true
+			rlxd = true
+			anlz = vern
 		case "":
 			stps = true
 		default:
 		}
 
+		// schema fields remove stop words and stem as they were indexed
+		name := strings.Trim(fld, "[]")
+		if name == "" && schema != nil {
+			name = schema.Default
+		}
+		if fs := schema.Field(name); fs != nil {
+			stps = fs.Positional
+			rlxd = fs.Stemmed
+		}
+
 		addOneTerm := func(itm string) {
 
 			if stps {
 				if itm == "." {
 					// skip if just a period, but allow terms that are all digits or period
 					chain = append(chain, "+")
-				} else if deStop && IsStopWord(itm) {
+				} else if deStop && anlz.IsStopWord(itm) {
 					// skip if stop word, breaking phrase chain
 					chain = append(chain, "+")
 				} else if rlxd {
@@ -1160,8 +1263,7 @@ func processStopWords(str string, deStop bool) string {
 						itm = strings.TrimSuffix(itm, "*")
 					}
 
-					itm = porter2.Stem(itm)
-					itm = strings.TrimSpace(itm)
+					itm = anlz.Stem(itm)
 
 					if isWildCard {
 						// do wildcard search in stemmed term list
@@ -1272,12 +1374,111 @@ func partitionQuery(str string) []string {
 	return tmp
 }
 
+// numericRange checks a numeric field query, expanding the remnant of a #:# range into
+// an OR group of individual numbers
+func numericRange(str, bdy, fld string) ([]string, error) {
+
+	var res []string
+
+	// look for remnant of colon separating two integers
+	lft, rgt := SplitInTwoLeft(bdy, " ")
+	lft = strings.TrimSpace(lft)
+	rgt = strings.TrimSpace(rgt)
+
+	if lft == "" && rgt == "" {
+		return nil, &QueryError{Reason: "Unable to recognize expression", Term: str}
+	}
+
+	// regular integer
+	if rgt == "" {
+		// check for wildcard
+		if strings.HasSuffix(lft, "*") {
+
+			return nil, &QueryError{Reason: "Wildcards not supported - use #:# range instead of", Term: lft}
+		}
+		if IsAllDigits(lft) {
+			return []string{str}, nil
+		}
+		return nil, &QueryError{Reason: "Field " + fld + " must be an integer, found", Term: lft}
+	}
+
+	// check for integer range
+	if !IsAllDigits(lft) || !IsAllDigits(rgt) {
+		return nil, &QueryError{Reason: "Unable to recognize expression", Term: str}
+	}
+
+	start, err := strconv.Atoi(lft)
+	if err != nil {
+		return nil, &QueryError{Reason: "Unable to recognize starting number", Term: lft}
+	}
+	stop, err := strconv.Atoi(rgt)
+	if err != nil {
+		return nil, &QueryError{Reason: "Unable to recognize ending number", Term: rgt}
+	}
+	if start > stop {
+		// put into proper order
+		start, stop = stop, start
+	}
+	// expand range into individual number-by-number queries
+	fld = strings.ToLower(fld)
+	pfx := "("
+	sfx := ")"
+	for start <= stop {
+		res = append(res, pfx)
+		pfx = "|"
+		yr := strconv.Itoa(start)
+		res = append(res, yr+" "+fld)
+		start++
+	}
+	res = append(res, sfx)
+
+	return res, nil
+}
+
+// QueryError reports a query term that cannot be searched
+type QueryError struct {
+	Reason string
+	Term   string
+}
+
+func (qe *QueryError) Error() string {
+
+	return qe.Reason + " '" + qe.Term + "'"
+}
+
+// setFieldQualifiers expands field qualifiers for command-line searches, exiting on a malformed term
 func setFieldQualifiers(db string, clauses []string) []string {
 
+	res, err := fieldQualifiers(db, clauses)
+	if err != nil {
+		DisplayError("%s", err.Error())
+		os.Exit(1)
+	}
+
+	return res
+}
+
+// fieldQualifiers expands field qualifiers, returning a *QueryError for a malformed term
+func fieldQualifiers(db string, clauses []string) ([]string, error) {
+
 	var res []string
 
 	if clauses == nil {
-		return nil
+		return nil, nil
+	}
+
+	schema := postingsSchema(db)
+
+	// remove leading and trailing plus signs and spaces
+	trimPlus := func(str string) string {
+		for strings.HasPrefix(str, "+") || strings.HasPrefix(str, " ") {
+			str = str[1:]
+		}
+		for strings.HasSuffix(str, "+") || strings.HasSuffix(str, " ") {
+			slen := len(str)
+			str = str[:slen-1]
+		}
+		return str
 	}
 
 	for _, str := range clauses {
@@ -1294,6 +1495,29 @@ func setFieldQualifiers(db string, clauses []string) []string {
 			continue
 		}
 
+		// fields declared by an index schema take its numeric and truncation settings
+		if schema != nil && strings.HasSuffix(str, "]") {
+			pos := strings.LastIndex(str, " [")
+			if pos >= 0 {
+				if fs := schema.Field(str[pos+2 : len(str)-1]); fs != nil {
+					bdy := strings.TrimSpace(str[:pos])
+					if fs.Numeric {
+						rng, err := numericRange(str, bdy, "["+fs.Name+"]")
+						if err != nil {
+							return nil, err
+						}
+						res = append(res, rng...)
+						continue
+					}
+					if !fs.Truncatable() && strings.Contains(bdy, "*") {
+						return nil, &QueryError{Reason: "Wildcards not supported in [" + fs.Name + "] field", Term: bdy}
+					}
+					res = append(res, trimPlus(str))
+					continue
+				}
+			}
+		}
+
 		if strings.HasSuffix(str, " [YEAR]") {
 
 			slen := len(str)
@@ -1308,8 +1532,7 @@ func setFieldQualifiers(db string, clauses []string) []string {
 			// check for year wildcard
 			if len(str) == 4 && str[3] == '*' && IsAllDigitsOrPeriod(str[:3]) {
 
-				DisplayError("Wildcards not supported - use ####:#### range instead")
-				os.Exit(1)
+				return nil, &QueryError{Reason: "Wildcards not supported - use ####:#### range instead of", Term: str}
 			}
 
 			// allow year month day to look for unexpected annotation
@@ -1322,13 +1545,11 @@ func setFieldQualifiers(db string, clauses []string) []string {
 			if len(str) == 9 && str[4] == ' ' && IsAllDigitsOrPeriod(str[:4]) && IsAllDigitsOrPeriod(str[5:]) {
 				start, err := strconv.Atoi(str[:4])
 				if err != nil {
-					DisplayError("Unable to recognize first year '%s'", str[:4])
-					os.Exit(1)
+					return nil, &QueryError{Reason: "Unable to recognize first year", Term: str[:4]}
 				}
 				stop, err := strconv.Atoi(str[5:])
 				if err != nil {
-					DisplayError("Unable to recognize final year '%s'", str[5:])
-					os.Exit(1)
+					return nil, &QueryError{Reason: "Unable to recognize final year", Term: str[5:]}
 				}
 				if start > stop {
 					continue
@@ -1347,8 +1568,7 @@ func setFieldQualifiers(db string, clauses []string) []string {
 				continue
 			}
 
-			DisplayError("Unable to recognize year expression '%s'", str)
-			os.Exit(1)
+			return nil, &QueryError{Reason: "Unable to recognize year expression", Term: str}
 
 		} else if strings.HasSuffix(str, " [AUTH]") ||
 			strings.HasSuffix(str, " [FAUT]") ||
@@ -1400,64 +1620,11 @@ func setFieldQualifiers(db string, clauses []string) []string {
 			bdy = strings.TrimSpace(bdy)
 			fld = strings.TrimSpace(fld)
 
-			// look for remnant of colon separating two integers
-			lft, rgt := SplitInTwoLeft(bdy, " ")
-			lft = strings.TrimSpace(lft)
-			rgt = strings.TrimSpace(rgt)
-
-			if lft == "" && rgt == "" {
-				DisplayError("Unable to recognize expression '%s'", str)
-				os.Exit(1)
-			}
-
-			// regular integer
-			if rgt == "" {
-				// check for wildcard
-				if strings.HasSuffix(lft, "*") {
-
-					DisplayError("Wildcards not supported - use #:# range instead")
-					os.Exit(1)
-				}
-				if IsAllDigits(lft) {
-					res = append(res, str)
-					continue
-				}
-				DisplayError("Field %s must be an integer", fld)
-				os.Exit(1)
-			}
-
-			// check for integer range
-			if !IsAllDigits(lft) || !IsAllDigits(rgt) {
-				DisplayError("Unable to recognize expression '%s'", str)
-				os.Exit(1)
-			}
-
-			start, err := strconv.Atoi(lft)
-			if err != nil {
-				DisplayError("Unable to recognize starting number '%s'", lft)
-				os.Exit(1)
-			}
-			stop, err := strconv.Atoi(rgt)
+			rng, err := numericRange(str, bdy, fld)
 			if err != nil {
-				DisplayError("Unable to recognize ending number '%s'", rgt)
-				os.Exit(1)
+				return nil, err
 			}
-			if start > stop {
-				// put into proper order
-				start, stop = stop, start
-			}
-			// expand range into individual number-by-number queries
-			fld = strings.ToLower(fld)
-			pfx := "("
-			sfx := ")"
-			for start <= stop {
-				res = append(res, pfx)
-				pfx = "|"
-				yr := strconv.Itoa(start)
-				res = append(res, yr+" "+fld)
-				start++
-			}
-			res = append(res, sfx)
+			res = append(res, rng...)
 			continue
 
 		} else if strings.HasSuffix(str, " [TREE]") {
@@ -1485,8 +1652,7 @@ func setFieldQualifiers(db string, clauses []string) []string {
 					continue
 				}
 
-				DisplayError("Unable to recognize mesh code expression '%s'", str)
-				os.Exit(1)
+				return nil, &QueryError{Reason: "Unable to recognize mesh code expression", Term: str}
 
 			} else if db == "taxonomy" {
 
@@ -1528,6 +1694,19 @@ func setFieldQualifiers(db string, clauses []string) []string {
 			res = append(res, str+" [PTYP]")
 			continue
 
+		} else if strings.HasSuffix(str, " [ORCD]") {
+
+			slen := len(str)
+			str = str[:slen-7]
+
+			// accept hyphenated or URL forms, indexed as 16 characters by -orcid
+			orcd := NormalizeORCID(str)
+			if orcd == "" {
+				return nil, &QueryError{Reason: "Unable to recognize ORCID identifier", Term: str}
+			}
+			res = append(res, orcd+" [ORCD]")
+			continue
+
 		} else if strings.HasSuffix(str, " [DOI]") {
 
 			slen := len(str)
@@ -1596,29 +1775,21 @@ func setFieldQualifiers(db string, clauses []string) []string {
 				}
 			}
 
-			// skip if MeSH term not yet indexed in tree
+			// heading/qualifier combinations, and headings not yet indexed in tree, match MESH terms
+			res = append(res, str+" [MESH]")
 			continue
 		}
 
-		// remove leading and trailing plus signs and spaces
-		for strings.HasPrefix(str, "+") || strings.HasPrefix(str, " ") {
-			str = str[1:]
-		}
-		for strings.HasSuffix(str, "+") || strings.HasSuffix(str, " ") {
-			slen := len(str)
-			str = str[:slen-1]
-		}
-
-		res = append(res, str)
+		res = append(res, trimPlus(str))
 	}
 
-	return res
+	return res, nil
 }
 
 // SEARCH TERM LISTS FOR PHRASES OR NORMALIZED TERMS, OR MATCH BY PATTERN
 
 // ProcessSearch evaluates query, returns list of PMIDs to stdout
-func ProcessSearch(db, phrase string, xact, titl, isLink, deStop bool) int {
+func ProcessSearch(db, phrase string, xact, titl, isLink, deStop bool, lang string) int {
 
 	if phrase == "" {
 		return 0
@@ -1652,22 +1823,43 @@ func ProcessSearch(db, phrase string, xact, titl, isLink, deStop bool) int {
 		phrase = prepareQuery(phrase)
 	}
 
-	phrase = processStopWords(phrase, deStop)
+	phrase = processStopWords(db, phrase, deStop, lang)
 
 	clauses := partitionQuery(phrase)
 
 	clauses = setFieldQualifiers(db, clauses)
 
-	count, _ := evaluateQuery(postingsBase, db, phrase, clauses, false, isLink)
+	count, _, err := evaluateQuery(context.Background(), postingsBase, db, phrase, clauses, false, isLink)
+	if err != nil {
+		DisplayError("%s", err.Error())
+		os.Exit(1)
+	}
 
 	return count
 }
 
-// ProcessQuery evaluates query, returns list of PMIDs in array
+// ProcessQuery evaluates query, returns list of PMIDs in array, exiting on a malformed query
 func ProcessQuery(db, phrase string, xact, titl, isLink, deStop bool) []int32 {
 
+	arry, err := ProcessQueryContext(context.Background(), db, phrase, xact, titl, isLink, deStop, "")
+	if err != nil {
+		DisplayError("%s", err.Error())
+		os.Exit(1)
+	}
+
+	return arry
+}
+
+// ErrNoArchivePath is returned by ProcessQueryContext if no local archive is configured
+var ErrNoArchivePath = errors.New("unable to get local archive path")
+
+// ProcessQueryContext evaluates query, returns list of PMIDs in array, or an error if the
+// context is cancelled, its deadline passes, its memory budget (see WithMemoryBudget) is
+// exceeded, or the query has a syntax error or a term that cannot be searched (see QueryError)
+func ProcessQueryContext(ctx context.Context, db, phrase string, xact, titl, isLink, deStop bool, lang string) ([]int32, error) {
+
 	if phrase == "" {
-		return nil
+		return nil, nil
 	}
 
 	if db == "" {
@@ -1679,13 +1871,27 @@ func ProcessQuery(db, phrase string, xact, titl, isLink, deStop bool) []int32 {
 	base, _ := GetLocalArchivePaths(db)
 
 	if base == "" {
-
-		DisplayError("Unable to get local archive path")
-		os.Exit(1)
+		return nil, ErrNoArchivePath
 	}
 
 	postingsBase := base + "Postings"
 
+	phrase, clauses, err := canonicalQuery(db, phrase, xact, titl, deStop, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	_, arry, err := evaluateQuery(ctx, postingsBase, db, phrase, clauses, true, isLink)
+
+	// remove records deleted since postings were promoted
+	arry = SubtractDeleted(db, arry)
+
+	return arry, err
+}
+
+// canonicalQuery runs the query preparation steps shared by ProcessQuery and NormalizeQuery
+func canonicalQuery(db, phrase string, xact, titl, deStop bool, lang string) (string, []string, error) {
+
 	if titl {
 		phrase = prepareExact(phrase, "[titl]", deStop)
 	} else if xact {
@@ -1698,19 +1904,39 @@ func ProcessQuery(db, phrase string, xact, titl, isLink, deStop bool) []int32 {
 		phrase = prepareQuery(phrase)
 	}
 
-	phrase = processStopWords(phrase, deStop)
+	phrase = processStopWords(db, phrase, deStop, lang)
 
 	clauses := partitionQuery(phrase)
 
-	clauses = setFieldQualifiers(db, clauses)
+	clauses, err := fieldQualifiers(db, clauses)
 
-	_, arry := evaluateQuery(postingsBase, db, phrase, clauses, true, isLink)
+	return phrase, clauses, err
+}
 
-	return arry
+// NormalizeQuery returns the canonical form of a query, after case folding, stop word
+// removal, and field qualifier expansion, so equivalent queries yield the same string,
+// or a *QueryError if the query has a term that cannot be searched
+func NormalizeQuery(db, phrase string, xact, titl, deStop bool, lang string) (string, error) {
+
+	if phrase == "" {
+		return "", nil
+	}
+
+	if db == "" {
+		db = "pubmed"
+	}
+	db = strings.ToLower(db)
+
+	_, clauses, err := canonicalQuery(db, phrase, xact, titl, deStop, lang)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(clauses, " "), nil
 }
 
 // ProcessMock shows individual steps in processing query for evaluation
-func ProcessMock(db, phrase string, xact, titl, deStop bool) int {
+func ProcessMock(db, phrase string, xact, titl, deStop bool, lang string) int {
 
 	if phrase == "" {
 		return 0
@@ -1741,7 +1967,7 @@ func ProcessMock(db, phrase string, xact, titl, deStop bool) int {
 		fmt.Fprintf(os.Stdout, "prepareQuery:\n\n%s\n\n", phrase)
 	}
 
-	phrase = processStopWords(phrase, deStop)
+	phrase = processStopWords(db, phrase, deStop, lang)
 
 	fmt.Fprintf(os.Stdout, "processStopWords:\n\n%s\n\n", phrase)
 
@@ -1765,7 +1991,7 @@ func ProcessMock(db, phrase string, xact, titl, deStop bool) int {
 }
 
 // ProcessCount prints document count for each term, also supports terminal wildcards
-func ProcessCount(db, phrase string, plrl, psns, deStop bool) int {
+func ProcessCount(db, phrase string, plrl, psns, deStop bool, lang string) int {
 
 	if phrase == "" {
 		return 0
@@ -1789,7 +2015,7 @@ func ProcessCount(db, phrase string, plrl, psns, deStop bool) int {
 
 	phrase = prepareQuery(phrase)
 
-	phrase = processStopWords(phrase, deStop)
+	phrase = processStopWords(db, phrase, deStop, lang)
 
 	clauses := partitionQuery(phrase)
 
@@ -2241,7 +2467,7 @@ func ProcessMatch(db, phrase string, deStop bool) {
 
 	phrase = prepareQuery(phrase)
 
-	phrase = processStopWords(phrase, deStop)
+	phrase = processStopWords(db, phrase, deStop, "")
 
 	clauses := partitionQuery(phrase)
 
@@ -33,6 +33,7 @@ package eutils
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/klauspost/pgzip"
@@ -74,7 +75,10 @@ import (
 // for calculating TF-IDF term weights, which can support ranked retrieval,
 // is the total number of live PubMed documents, which could easily be saved
 // during indexing.
-func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-chan string {
+//
+// With stage set, files are written with a StagedSuffix, and are only seen by
+// queries after PublishPostings renames them into place as one generation.
+func CreatePromoters(prom, db, fields string, isLink, stage bool, files []string) <-chan string {
 
 	if files == nil {
 		return nil
@@ -106,11 +110,31 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 
 	flds := strings.Split(fields, " ")
 
+	// leave records deleted by daily updates out of rebuilt postings
+	var deleted []int32
+	if !isLink {
+		deleted = ReadDeletedSet(db)
+		// rchive -fold clears only identifiers the rebuilt postings leave out
+		err = NoteDeletedApplied(db, deleted)
+		if err != nil {
+			DisplayError("Unable to record applied deleted set: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// a restarted promotion skips merged files already done for the same fields,
+	// the checkpoint is kept with the postings, so it goes away if they are removed
+	cp := openCheckpoint(filepath.Join(postingsBase, PromoteCheckpoint), "promote")
+
+	pm := newProgressMonitor("promote", "files", len(files))
+
 	// xmlPromoter saves records in a single set of term/posting files
 	xmlPromoter := func(wg *sync.WaitGroup, fileName string, out chan<- string) {
 
 		defer wg.Done()
 
+		numTerms := 0
+
 		f, err := os.Open(fileName)
 		if err != nil {
 			DisplayError("Unable to open input file '%s'", fileName)
@@ -185,6 +209,9 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 						fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 						return
 					}
+					if _, found := slices.BinarySearch(deleted, int32(value)); found {
+						return
+					}
 					data = append(data, int32(value))
 
 					if strings.HasPrefix(attr, "pos=\"") {
@@ -290,8 +317,16 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 				return
 			}
 
-			// overwrites and truncates existing file
-			fl, err := os.Create(fpath)
+			// staged files wait for -advance to publish the whole generation
+			if stage {
+				fpath += StagedSuffix
+			}
+
+			// write to temporary file, then rename over any existing file,
+			// so a running server never opens a partially-written posting
+			tmpath := fpath + ".tmp"
+
+			fl, err := os.Create(tmpath)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 				return
@@ -311,6 +346,12 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 			// fl.Sync()
 
 			fl.Close()
+
+			err = os.Rename(tmpath, fpath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				os.Remove(tmpath)
+			}
 		}
 
 		writeFiveFiles := func(field, key string) {
@@ -433,6 +474,7 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 
 				// collect next InvDocument record
 				arry = append(arry, str[:])
+				numTerms++
 
 				prevTag = currTag
 			})
@@ -445,12 +487,19 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 			}
 			out <- prevTag
 		}
+
+		cp.Record(promoteKey(fields, isLink, fileName))
+		pm.Add(1, numTerms)
 	}
 
 	var wg sync.WaitGroup
 
 	// launch multiple promoter goroutines
 	for _, str := range files {
+		if cp.Completed(promoteKey(fields, isLink, str)) {
+			pm.Skip(1)
+			continue
+		}
 		wg.Add(1)
 		go xmlPromoter(&wg, str, out)
 	}
@@ -458,6 +507,8 @@ func CreatePromoters(prom, db, fields string, isLink bool, files []string) <-cha
 	// launch separate anonymous goroutine to wait until all promoters are done
 	go func() {
 		wg.Wait()
+		pm.Finish()
+		cp.Close()
 		close(out)
 	}()
 
@@ -687,7 +738,13 @@ func readTermListFuture(dpath, key, field string) <-chan []byte {
 	return out
 }
 
-func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]uint16) {
+// getPostingIDs returns nil if the query context is cancelled or its memory budget
+// would be exceeded, leaving the caller to report the reason with checkQuery
+func getPostingIDs(ctx context.Context, prom, term, field string, simple, isLink bool) ([]int32, [][]uint16) {
+
+	if checkQuery(ctx) != nil {
+		return nil, nil
+	}
 
 	dpath, key := PostingPath(prom, field, term, isLink)
 	if dpath == "" {
@@ -773,6 +830,10 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 			}
 			size := indx[R].PostOffset - offset
 
+			if chargeBudget(ctx, int64(size)) != nil {
+				return nil, nil
+			}
+
 			// read relevant postings list section
 			data := readPostingData(dpath, key, field, offset, size)
 			if data == nil || len(data) < 1 {
@@ -783,9 +844,27 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 
 				merged := make(map[int32]bool)
 
+				// map entries are charged as they are added, since a wildcard
+				// over common terms can collect far more UIDs than any one posting
+				added := 0
+
 				// combine all postings in term range
-				for _, val := range data {
-					merged[val] = true
+				for i, val := range data {
+					if i%65536 == 0 {
+						if chargeBudget(ctx, int64(added*mergedEntrySize)) != nil {
+							return nil, nil
+						}
+						added = 0
+					}
+					if !merged[val] {
+						merged[val] = true
+						added++
+					}
+				}
+
+				// remaining map entries and the fused array
+				if chargeBudget(ctx, int64(added*mergedEntrySize+len(merged)*4)) != nil {
+					return nil, nil
 				}
 
 				fused := make([]int32, len(merged))
@@ -802,6 +881,10 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 				return fused, nil
 			}
 
+			if chargeBudget(ctx, int64(size+4)) != nil {
+				return nil, nil
+			}
+
 			// read relevant word position section, includes phantom offset at end
 			uqis := readPositionIndex(dpath, key, field, offset, size+4)
 			if uqis == nil {
@@ -815,6 +898,10 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 			from := uqis[0]
 			to := uqis[ulen-1]
 
+			if chargeBudget(ctx, int64(to-from)) != nil {
+				return nil, nil
+			}
+
 			// read offset section
 			ofst := readOffsetData(dpath, key, field, from, to-from)
 			if ofst == nil {
@@ -823,18 +910,29 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 
 			combo := make(map[int32][]uint16)
 
+			// map entries and copied positions are charged as they are added
+			added := 0
+
 			addPositions := func(uid int32, pos uint16) {
 
 				arrs, ok := combo[uid]
 				if !ok {
 					arrs = make([]uint16, 0, 1)
+					added += comboEntrySize
 				}
+				added += 2
 				arrs = append(arrs, pos)
 				combo[uid] = arrs
 			}
 
 			// populate array of positions per UID
 			for i, j, k := 0, 1, int32(0); i < ulen-1; i++ {
+				if i%65536 == 0 {
+					if chargeBudget(ctx, int64(added)) != nil {
+						return nil, nil
+					}
+					added = 0
+				}
 				uid := data[i]
 				num := (uqis[j] - uqis[i]) / 2
 				j++
@@ -844,6 +942,11 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 				k += num
 			}
 
+			// remaining map entries, and the fused and position arrays
+			if chargeBudget(ctx, int64(added+len(combo)*(4+24))) != nil {
+				return nil, nil
+			}
+
 			fused := make([]int32, len(combo))
 
 			// convert map to slice
@@ -883,6 +986,10 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 		offset := indx[R].PostOffset
 		size := indx[R+1].PostOffset - offset
 
+		if chargeBudget(ctx, int64(size)) != nil {
+			return nil, nil
+		}
+
 		// read relevant postings list section
 		data := readPostingData(dpath, key, field, offset, size)
 		if data == nil || len(data) < 1 {
@@ -893,6 +1000,10 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 			return data, nil
 		}
 
+		if chargeBudget(ctx, int64(size+4)) != nil {
+			return nil, nil
+		}
+
 		// read relevant word position section, includes phantom offset at end
 		uqis := readPositionIndex(dpath, key, field, offset, size+4)
 		if uqis == nil {
@@ -906,6 +1017,10 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 		from := uqis[0]
 		to := uqis[ulen-1]
 
+		if chargeBudget(ctx, int64(to-from)) != nil {
+			return nil, nil
+		}
+
 		// read offset section
 		ofst := readOffsetData(dpath, key, field, from, to-from)
 		if ofst == nil {
@@ -932,7 +1047,7 @@ func getPostingIDs(prom, term, field string, simple, isLink bool) ([]int32, [][]
 	return nil, nil
 }
 
-func postingIDsFuture(base, term, field string, dist int, isLink bool) <-chan Arrays {
+func postingIDsFuture(ctx context.Context, base, term, field string, dist int, isLink bool) <-chan Arrays {
 
 	out := make(chan Arrays, chanDepth)
 	if out == nil {
@@ -943,7 +1058,7 @@ func postingIDsFuture(base, term, field string, dist int, isLink bool) <-chan Ar
 	// postingFuture asynchronously gets posting IDs and sends results through channel
 	postingFuture := func(base, term, field string, dist int, out chan<- Arrays) {
 
-		data, ofst := getPostingIDs(base, term, field, false, isLink)
+		data, ofst := getPostingIDs(ctx, base, term, field, false, isLink)
 
 		out <- Arrays{Data: data, Ofst: ofst, Dist: dist}
 
@@ -1255,6 +1370,11 @@ func excludeIDs(N, M []int32) []int32 {
 		}
 	}
 
+	// keep items after last excluded value
+	if i < n {
+		k += copy(res[k:], N[i:])
+	}
+
 	// truncate output array to actual size of result
 	res = res[:k]
 
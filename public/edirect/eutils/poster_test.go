@@ -0,0 +1,133 @@
+package eutils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExcludeIDs(t *testing.T) {
+
+	tests := []struct {
+		N, M, want []int32
+	}{
+		{[]int32{1, 2, 3}, []int32{2}, []int32{1, 3}},
+		{[]int32{5, 6}, []int32{1}, []int32{5, 6}},
+		{[]int32{1, 5, 9}, []int32{1, 9}, []int32{5}},
+		{[]int32{4, 7}, nil, []int32{4, 7}},
+		{nil, []int32{4, 7}, nil},
+		// regression: items after the last excluded value were dropped
+		{[]int32{10, 20, 30, 40, 50}, []int32{20}, []int32{10, 30, 40, 50}},
+		{[]int32{10, 20, 30}, []int32{5, 10}, []int32{20, 30}},
+		{[]int32{10, 20, 30}, []int32{30}, []int32{10, 20}},
+	}
+	for _, test := range tests {
+		got := excludeIDs(test.N, test.M)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("excludeIDs(%v, %v) = %v, want %v", test.N, test.M, got, test.want)
+		}
+	}
+}
+
+func TestRetractionLinks(t *testing.T) {
+
+	SetTunings(0, 0, 0, 0, 0, 0, 0, false)
+
+	master := t.TempDir()
+	t.Setenv("EDIRECT_PUBMED_MASTER", master)
+	t.Setenv("EDIRECT_PUBMED_WORKING", master)
+
+	links := filepath.Join(master, "Merged", "Links")
+	for _, dir := range []string{filepath.Join(master, "Postings"), links} {
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// article 200 is retracted by notice 300, which also shares a title word with it
+	inv := `<InvDocumentSet>
+<InvDocument>
+<InvKey>00000200</InvKey>
+<InvIDs>
+<RETR>300</RETR>
+</InvIDs>
+</InvDocument>
+<InvDocument>
+<InvKey>00000300</InvKey>
+<InvIDs>
+<RETR>200</RETR>
+</InvIDs>
+</InvDocument>
+<InvDocument>
+<InvKey>surgery</InvKey>
+<InvIDs>
+<TIAB pos="1">200</TIAB>
+<TIAB pos="2">300</TIAB>
+</InvIDs>
+</InvDocument>
+</InvDocumentSet>
+`
+	infile := filepath.Join(master, "pubmed00.inv")
+	err := os.WriteFile(infile, []byte(inv), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rchive -mergeonly RETR -mergelink, as in archive-pubmed
+	SetMergeFields("RETR")
+	defer SetMergeFields("")
+
+	files := []string{infile}
+	sptr := CreateSplitter(links, "pubmed", false, true, files, CreateXMLUnshuffler(CreateMergers(CreateManifold(CreatePresenters(files)))))
+
+	var merged []string
+	for tag := range sptr {
+		merged = append(merged, filepath.Join(links, tag+".mrg"))
+	}
+	if len(merged) != 1 {
+		t.Fatalf("link merge wrote %v, expected one identifier prefix file", merged)
+	}
+
+	// rchive -promotelink
+	for range CreatePromoters(filepath.Join(master, "Postings"), "pubmed", "RETR", true, false, merged) {
+	}
+
+	// phrase-search -link RETR
+	search := func(pmid string) string {
+
+		inp, err := os.CreateTemp(t.TempDir(), "in")
+		if err != nil {
+			t.Fatal(err)
+		}
+		inp.WriteString(pmid + "\n")
+		inp.Seek(0, io.SeekStart)
+		defer inp.Close()
+
+		res, err := os.CreateTemp(t.TempDir(), "out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Close()
+
+		stdin, stdout := os.Stdin, os.Stdout
+		os.Stdin, os.Stdout = inp, res
+		ProcessLinks("pubmed", "RETR")
+		os.Stdin, os.Stdout = stdin, stdout
+
+		data, err := os.ReadFile(res.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := search("200"); got != "300\n" {
+		t.Errorf("retraction of 200 = %q, want 300", got)
+	}
+	if got := search("300"); got != "200\n" {
+		t.Errorf("article retracted by 300 = %q, want 200", got)
+	}
+}
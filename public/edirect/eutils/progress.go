@@ -0,0 +1,369 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  progress.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PROGRESS EVENTS AND RESTART CHECKPOINTS
+
+// Building the local index runs for hours. With progress events enabled, the index,
+// invert, merge, and promote stages print one JSON object per line to stderr in place
+// of progress dots, giving units completed, records per second, and estimated seconds
+// remaining. Each stage also keeps enough state on disk that a restarted run can skip
+// work already done. Index and invert files are written under a temporary name and
+// renamed when complete, so an existing file is a finished trie folder. The merge and
+// promote stages append completed prefixes or input files to a checkpoint file that is
+// discarded when the set of inputs changes.
+
+// progressEvents is set by EDIRECT_LOCAL_PROGRESS or by SetProgressEvents
+var progressEvents = isTrueEnv("EDIRECT_LOCAL_PROGRESS")
+
+// progressInterval limits how often intermediate events are printed
+const progressInterval = 5 * time.Second
+
+func isTrueEnv(name string) bool {
+
+	env := os.Getenv(name)
+
+	return env == "Y" || env == "y" || env == "true"
+}
+
+// SetProgressEvents turns JSON progress events on or off
+func SetProgressEvents(on bool) {
+
+	progressEvents = on
+}
+
+// ProgressEvent is printed as a single JSON line
+type ProgressEvent struct {
+	Time    string  `json:"time"`
+	Stage   string  `json:"stage"`
+	Event   string  `json:"event"`
+	Unit    string  `json:"unit"`
+	Done    int     `json:"done"`
+	Total   int     `json:"total,omitempty"`
+	Skipped int     `json:"skipped,omitempty"`
+	Records int     `json:"records"`
+	Rate    float64 `json:"rate"`
+	Elapsed float64 `json:"elapsed"`
+	ETA     float64 `json:"eta,omitempty"`
+}
+
+// progressMonitor counts units (folders, prefixes, or files) and the records within
+// them, methods on a nil monitor do nothing, so callers need not test for it
+type progressMonitor struct {
+	lock     sync.Mutex
+	stage    string
+	unit     string
+	total    int
+	done     int
+	skipped  int
+	records  int
+	fraction func() float64
+	start    time.Time
+	last     time.Time
+}
+
+// newProgressMonitor returns nil unless progress events are enabled
+func newProgressMonitor(stage, unit string, total int) *progressMonitor {
+
+	if !progressEvents {
+		return nil
+	}
+
+	now := time.Now()
+	pm := &progressMonitor{stage: stage, unit: unit, total: total, start: now, last: now}
+
+	pm.emit("start")
+
+	return pm
+}
+
+// emit prints the current state, caller holds the lock except at creation
+func (pm *progressMonitor) emit(event string) {
+
+	elapsed := time.Since(pm.start).Seconds()
+
+	ev := ProgressEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Stage:   pm.stage,
+		Event:   event,
+		Unit:    pm.unit,
+		Done:    pm.done,
+		Total:   pm.total,
+		Skipped: pm.skipped,
+		Records: pm.records,
+		Elapsed: roundTenths(elapsed),
+	}
+
+	if elapsed > 0 {
+		ev.Rate = roundTenths(float64(pm.records) / elapsed)
+	}
+
+	if event == "progress" {
+		// prefer fraction of input consumed, otherwise extrapolate from units remaining
+		if pm.fraction != nil {
+			frac := pm.fraction()
+			if frac > 0 && frac < 1 {
+				ev.ETA = roundTenths(elapsed * (1 - frac) / frac)
+			}
+		} else if pm.total > 0 && pm.done > 0 {
+			left := pm.total - pm.done - pm.skipped
+			if left > 0 {
+				ev.ETA = roundTenths(elapsed * float64(left) / float64(pm.done))
+			}
+		}
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s\n", data)
+}
+
+func roundTenths(val float64) float64 {
+
+	return float64(int64(val*10+0.5)) / 10
+}
+
+// SetTotal records the number of units once it is known
+func (pm *progressMonitor) SetTotal(total int) {
+
+	if pm == nil {
+		return
+	}
+
+	pm.lock.Lock()
+	pm.total = total
+	pm.lock.Unlock()
+}
+
+// Skip counts units left alone because a previous run completed them
+func (pm *progressMonitor) Skip(units int) {
+
+	if pm == nil {
+		return
+	}
+
+	pm.lock.Lock()
+	pm.skipped += units
+	pm.lock.Unlock()
+}
+
+// Add counts completed units and records, printing an event at most every few seconds
+func (pm *progressMonitor) Add(units, records int) {
+
+	if pm == nil {
+		return
+	}
+
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	pm.done += units
+	pm.records += records
+
+	if time.Since(pm.last) >= progressInterval {
+		pm.last = time.Now()
+		pm.emit("progress")
+	}
+}
+
+// Finish prints the final totals
+func (pm *progressMonitor) Finish() {
+
+	if pm == nil {
+		return
+	}
+
+	pm.lock.Lock()
+	pm.emit("finish")
+	pm.lock.Unlock()
+}
+
+// byteCounter tallies bytes read, for stages that estimate remaining time from input consumed
+type byteCounter struct {
+	rdr   io.Reader
+	count *atomic.Int64
+}
+
+func (bc byteCounter) Read(p []byte) (int, error) {
+
+	n, err := bc.rdr.Read(p)
+	bc.count.Add(int64(n))
+
+	return n, err
+}
+
+// merge presenters record input size and bytes read for the splitter's monitor
+var (
+	mergeInputSize atomic.Int64
+	mergeInputRead atomic.Int64
+)
+
+// checkpoint is an append-only list of completed keys after a header line that
+// identifies the inputs, a different header means the inputs changed and it starts over
+type checkpoint struct {
+	lock  sync.Mutex
+	fl    *os.File
+	done  map[string]bool
+	added map[string]bool
+}
+
+// openCheckpoint loads completed keys if the header matches, otherwise starts over
+func openCheckpoint(fpath, header string) *checkpoint {
+
+	cp := &checkpoint{done: make(map[string]bool), added: make(map[string]bool)}
+
+	keep := false
+
+	inp, err := os.Open(fpath)
+	if err == nil {
+		scanr := bufio.NewScanner(inp)
+		if scanr.Scan() && scanr.Text() == header {
+			keep = true
+			for scanr.Scan() {
+				key := scanr.Text()
+				if key != "" {
+					cp.done[key] = true
+				}
+			}
+		}
+		inp.Close()
+	}
+
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if !keep {
+		flags |= os.O_TRUNC
+		cp.done = make(map[string]bool)
+	}
+
+	cp.fl, err = os.OpenFile(fpath, flags, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return nil
+	}
+
+	if !keep {
+		cp.fl.WriteString(header + "\n")
+	}
+
+	return cp
+}
+
+// Completed reports whether a previous run finished the key, keys recorded
+// by the current run are not included
+func (cp *checkpoint) Completed(key string) bool {
+
+	if cp == nil {
+		return false
+	}
+
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	return cp.done[key]
+}
+
+// Record appends a completed key and syncs it to disk
+func (cp *checkpoint) Record(key string) {
+
+	if cp == nil {
+		return
+	}
+
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.done[key] || cp.added[key] {
+		return
+	}
+	cp.added[key] = true
+
+	cp.fl.WriteString(key + "\n")
+	cp.fl.Sync()
+}
+
+// Close releases the checkpoint file
+func (cp *checkpoint) Close() {
+
+	if cp == nil {
+		return
+	}
+
+	cp.fl.Close()
+}
+
+// fileSignature identifies input files by name, size, and modification time
+func fileSignature(files []string) string {
+
+	hsh := fnv.New64a()
+
+	for _, file := range files {
+		fmt.Fprintf(hsh, "%s", filepath.Base(file))
+		inf, err := os.Stat(file)
+		if err == nil {
+			fmt.Fprintf(hsh, "\t%d\t%d", inf.Size(), inf.ModTime().UnixNano())
+		}
+		fmt.Fprintf(hsh, "\n")
+	}
+
+	return fmt.Sprintf("%016x", hsh.Sum64())
+}
+
+// MergeCheckpoint and PromoteCheckpoint are kept in the Merged folder
+const (
+	MergeCheckpoint   = "merge.ckpt"
+	PromoteCheckpoint = "promote.ckpt"
+)
+
+// promoteKey identifies a merged file promoted for a set of fields
+func promoteKey(fields string, isLink bool, file string) string {
+
+	kind := "promote"
+	if isLink {
+		kind = "promotelink"
+	}
+
+	return kind + "\t" + strings.TrimSpace(fields) + "\t" + filepath.Base(file) + "\t" + fileSignature([]string{file})
+}
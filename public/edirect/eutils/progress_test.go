@@ -0,0 +1,47 @@
+package eutils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+
+	fpath := filepath.Join(t.TempDir(), MergeCheckpoint)
+
+	cp := openCheckpoint(fpath, "merge\tinputs-1")
+	cp.Record("aa")
+	cp.Record("ab")
+	cp.Record("aa")
+	if cp.Completed("aa") {
+		t.Errorf("key recorded by current run reported as completed")
+	}
+	cp.Close()
+
+	// restart with same inputs resumes
+	cp = openCheckpoint(fpath, "merge\tinputs-1")
+	if !cp.Completed("aa") || !cp.Completed("ab") || cp.Completed("ac") {
+		t.Errorf("resumed checkpoint = %v", cp.done)
+	}
+	cp.Record("ac")
+	cp.Close()
+
+	cp = openCheckpoint(fpath, "merge\tinputs-1")
+	if len(cp.done) != 3 {
+		t.Errorf("checkpoint after resume = %v, want 3 keys", cp.done)
+	}
+	cp.Close()
+
+	// different inputs start over
+	cp = openCheckpoint(fpath, "merge\tinputs-2")
+	if len(cp.done) != 0 {
+		t.Errorf("checkpoint with new inputs = %v, want empty", cp.done)
+	}
+	cp.Close()
+
+	cp = openCheckpoint(fpath, "merge\tinputs-1")
+	if len(cp.done) != 0 {
+		t.Errorf("checkpoint was not reset, got %v", cp.done)
+	}
+	cp.Close()
+}
@@ -0,0 +1,353 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  qcache.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// QUERY RESULT CACHE
+
+// QueryCache is a least-recently-used cache of UID lists, keyed by the canonical
+// query string from NormalizeQuery. It is limited by number of entries, total
+// number of UIDs held, and entry age. All entries belong to one postings
+// generation, and Invalidate discards them when the generation changes.
+type QueryCache struct {
+	lock       sync.Mutex
+	order      *list.List
+	entries    map[string]*list.Element
+	maxEntries int
+	maxUIDs    int
+	numUIDs    int
+	ttl        time.Duration
+	generation int
+}
+
+type queryCacheEntry struct {
+	key  string
+	uids []int32
+	when time.Time
+}
+
+// NewQueryCache creates a cache holding up to maxEntries queries and maxUIDs total UIDs,
+// with entries expiring after ttl (0 for no expiration)
+func NewQueryCache(maxEntries, maxUIDs int, ttl time.Duration, generation int) *QueryCache {
+
+	// 0 defaults to 1000 queries and 50 million UIDs (200 MB)
+	if maxEntries < 1 {
+		maxEntries = 1000
+	}
+	if maxUIDs < 1 {
+		maxUIDs = 50000000
+	}
+
+	return &QueryCache{
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		maxUIDs:    maxUIDs,
+		ttl:        ttl,
+		generation: generation,
+	}
+}
+
+// removeElement should be called within a lock on the cache mutex
+func (qc *QueryCache) removeElement(elem *list.Element) {
+
+	ent := elem.Value.(*queryCacheEntry)
+	qc.order.Remove(elem)
+	delete(qc.entries, ent.key)
+	qc.numUIDs -= len(ent.uids)
+}
+
+// Get returns the cached UIDs for a normalized query, which must not be modified by the caller
+func (qc *QueryCache) Get(key string) ([]int32, bool) {
+
+	if qc == nil || key == "" {
+		return nil, false
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	elem, ok := qc.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	ent := elem.Value.(*queryCacheEntry)
+	if qc.ttl > 0 && time.Since(ent.when) > qc.ttl {
+		qc.removeElement(elem)
+		return nil, false
+	}
+
+	qc.order.MoveToFront(elem)
+
+	return ent.uids, true
+}
+
+// Put records the UIDs for a normalized query, evicting least recently used entries to stay within limits
+func (qc *QueryCache) Put(key string, uids []int32) {
+
+	qc.add(key, uids, time.Now())
+}
+
+func (qc *QueryCache) add(key string, uids []int32, when time.Time) {
+
+	if qc == nil || key == "" {
+		return
+	}
+
+	// do not let one enormous result flush the entire cache
+	if len(uids) > qc.maxUIDs/2 {
+		return
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	elem, ok := qc.entries[key]
+	if ok {
+		qc.removeElement(elem)
+	}
+
+	qc.entries[key] = qc.order.PushFront(&queryCacheEntry{key: key, uids: uids, when: when})
+	qc.numUIDs += len(uids)
+
+	for qc.order.Len() > qc.maxEntries || qc.numUIDs > qc.maxUIDs {
+		qc.removeElement(qc.order.Back())
+	}
+}
+
+// Invalidate discards all entries if the postings generation has changed
+func (qc *QueryCache) Invalidate(generation int) {
+
+	if qc == nil {
+		return
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	if generation == qc.generation {
+		return
+	}
+
+	qc.order.Init()
+	clear(qc.entries)
+	qc.numUIDs = 0
+	qc.generation = generation
+}
+
+// Len returns the number of cached queries
+func (qc *QueryCache) Len() int {
+
+	if qc == nil {
+		return 0
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	return qc.order.Len()
+}
+
+// QUERY CACHE PERSISTENCE
+
+// The cache file starts with "EDQC", then the generation number and entry count.
+// Each entry, from least to most recently used, has the key length, key bytes,
+// arrival time in Unix seconds, number of UIDs, and the UIDs. Integers are
+// little endian, as in postings files.
+
+var queryCacheMagic = [4]byte{'E', 'D', 'Q', 'C'}
+
+// SaveQueryCache writes unexpired cache entries to a file
+func SaveQueryCache(fileName string, qc *QueryCache) error {
+
+	if fileName == "" || qc == nil {
+		return nil
+	}
+
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	tmp := fileName + ".tmp"
+	fl, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	wrtr := bufio.NewWriter(fl)
+
+	var entries []*queryCacheEntry
+	for elem := qc.order.Back(); elem != nil; elem = elem.Prev() {
+		ent := elem.Value.(*queryCacheEntry)
+		if qc.ttl > 0 && time.Since(ent.when) > qc.ttl {
+			continue
+		}
+		entries = append(entries, ent)
+	}
+
+	write := func(data any) {
+		if err == nil {
+			err = binary.Write(wrtr, binary.LittleEndian, data)
+		}
+	}
+
+	write(queryCacheMagic)
+	write(int32(qc.generation))
+	write(int32(len(entries)))
+
+	for _, ent := range entries {
+		write(int32(len(ent.key)))
+		write([]byte(ent.key))
+		write(ent.when.Unix())
+		write(int32(len(ent.uids)))
+		write(ent.uids)
+	}
+
+	if err == nil {
+		err = wrtr.Flush()
+	}
+	fl.Close()
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, fileName)
+}
+
+// LoadQueryCache reads saved entries into the cache, ignoring the file if it was
+// written for a different postings generation
+func LoadQueryCache(fileName string, qc *QueryCache) error {
+
+	if fileName == "" || qc == nil {
+		return nil
+	}
+
+	fl, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	defer fl.Close()
+
+	rdr := bufio.NewReader(fl)
+
+	read := func(data any) {
+		if err == nil {
+			err = binary.Read(rdr, binary.LittleEndian, data)
+		}
+	}
+
+	var (
+		magic [4]byte
+		gen   int32
+		count int32
+	)
+
+	read(&magic)
+	if err == nil && magic != queryCacheMagic {
+		return fmt.Errorf("'%s' is not a query cache file", fileName)
+	}
+	read(&gen)
+	read(&count)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return fmt.Errorf("query cache file '%s' is truncated", fileName)
+	}
+	if err != nil {
+		return err
+	}
+
+	qc.lock.Lock()
+	current := qc.generation
+	qc.lock.Unlock()
+
+	if int(gen) != current {
+		return nil
+	}
+
+	for range count {
+
+		var (
+			klen int32
+			when int64
+			ulen int32
+		)
+
+		read(&klen)
+		if err == nil && (klen < 0 || klen > 65536) {
+			err = fmt.Errorf("query cache file '%s' is damaged", fileName)
+		}
+		if err != nil {
+			break
+		}
+		key := make([]byte, klen)
+		read(key)
+		read(&when)
+		read(&ulen)
+		if err == nil && (ulen < 0 || int(ulen) > qc.maxUIDs) {
+			err = fmt.Errorf("query cache file '%s' is damaged", fileName)
+		}
+		if err != nil {
+			break
+		}
+		uids := make([]int32, ulen)
+		read(uids)
+		if err != nil {
+			break
+		}
+
+		// UIDs should already be sorted, but do not trust a damaged file
+		if !slices.IsSorted(uids) {
+			continue
+		}
+
+		qc.add(string(key), uids, time.Unix(when, 0))
+	}
+
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return fmt.Errorf("query cache file '%s' is truncated", fileName)
+	}
+
+	return err
+}
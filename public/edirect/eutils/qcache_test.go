@@ -0,0 +1,270 @@
+package eutils
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheKeys lists cached queries from most to least recently used
+func cacheKeys(qc *QueryCache) []string {
+
+	var keys []string
+	for elem := qc.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*queryCacheEntry).key)
+	}
+
+	return keys
+}
+
+func TestQueryCacheEviction(t *testing.T) {
+
+	tests := []struct {
+		name string
+		ops  string
+		want []string
+	}{
+		{"fill", "+a +b +c", []string{"c", "b", "a"}},
+		{"oldest evicted", "+a +b +c +d", []string{"d", "c", "b"}},
+		{"get refreshes", "+a +b +c ?a +d", []string{"d", "a", "c"}},
+		{"put refreshes", "+a +b +c +a +d", []string{"d", "a", "c"}},
+		{"miss changes nothing", "+a +b ?z +c +d", []string{"d", "c", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qc := NewQueryCache(3, 0, 0, 1)
+			for _, op := range strings.Fields(tt.ops) {
+				if op[0] == '+' {
+					qc.Put(op[1:], []int32{1, 2})
+				} else {
+					qc.Get(op[1:])
+				}
+			}
+			got := cacheKeys(qc)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("cache holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryCacheMaxUIDs(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		sizes []int
+		want  []string
+		uids  int
+	}{
+		{"within limit", []int{4, 4}, []string{"b", "a"}, 8},
+		{"oldest evicted", []int{4, 4, 4}, []string{"c", "b"}, 8},
+		{"several evicted", []int{2, 2, 5, 5}, []string{"d", "c"}, 10},
+		{"half the limit accepted", []int{5}, []string{"a"}, 5},
+		{"over half the limit rejected", []int{3, 6}, []string{"a"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qc := NewQueryCache(100, 10, 0, 1)
+			for i, size := range tt.sizes {
+				qc.Put(string(rune('a'+i)), make([]int32, size))
+			}
+			got := cacheKeys(qc)
+			if !slices.Equal(got, tt.want) || qc.numUIDs != tt.uids {
+				t.Errorf("cache holds %v with %d UIDs, want %v with %d", got, qc.numUIDs, tt.want, tt.uids)
+			}
+		})
+	}
+}
+
+func TestQueryCacheExpiration(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		age  time.Duration
+		hit  bool
+	}{
+		{"fresh", time.Minute, time.Second, true},
+		{"expired", time.Minute, 2 * time.Minute, false},
+		{"no expiration", 0, 24 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qc := NewQueryCache(10, 0, tt.ttl, 1)
+			qc.add("a", []int32{7}, now.Add(-tt.age))
+			uids, ok := qc.Get("a")
+			if ok != tt.hit || (ok && !slices.Equal(uids, []int32{7})) {
+				t.Errorf("Get = %v, %v, want hit %v", uids, ok, tt.hit)
+			}
+			// expired entries are dropped when found
+			if want := map[bool]int{true: 1, false: 0}[tt.hit]; qc.Len() != want {
+				t.Errorf("Len = %d, want %d", qc.Len(), want)
+			}
+		})
+	}
+}
+
+func TestQueryCacheInvalidate(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		generation int
+		want       int
+	}{
+		{"same generation", 3, 2},
+		{"new generation", 4, 0},
+		{"older generation", 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qc := NewQueryCache(10, 0, 0, 3)
+			qc.Put("a", []int32{1})
+			qc.Put("b", []int32{2, 3})
+			qc.Invalidate(tt.generation)
+			if qc.Len() != tt.want {
+				t.Errorf("Len = %d, want %d", qc.Len(), tt.want)
+			}
+			if tt.want == 0 && (qc.numUIDs != 0 || qc.generation != tt.generation) {
+				t.Errorf("after Invalidate numUIDs = %d, generation = %d", qc.numUIDs, qc.generation)
+			}
+			// entries stored after invalidation belong to the new generation
+			qc.Put("c", []int32{4})
+			if _, ok := qc.Get("c"); !ok {
+				t.Errorf("Get after Invalidate missed")
+			}
+		})
+	}
+}
+
+func TestQueryCacheRoundTrip(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "query.cache")
+
+	qc := NewQueryCache(10, 0, time.Hour, 5)
+	qc.Put("cancer [TIAB]", []int32{1, 5, 9})
+	qc.Put("0000000218250097 [ORCD]", []int32{37011990})
+	qc.add("stale [TIAB]", []int32{2}, time.Now().Add(-2*time.Hour))
+	qc.Put("empty [TIAB]", nil)
+	qc.Get("cancer [TIAB]")
+
+	err := SaveQueryCache(fileName, qc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		generation int
+		want       []string
+	}{
+		{"same generation", 5, []string{"cancer [TIAB]", "empty [TIAB]", "0000000218250097 [ORCD]"}},
+		{"other generation", 6, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := NewQueryCache(10, 0, time.Hour, tt.generation)
+			err := LoadQueryCache(fileName, re)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// recency order survives, expired entries do not
+			got := cacheKeys(re)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("loaded %v, want %v", got, tt.want)
+			}
+			for _, key := range got {
+				old, _ := qc.Get(key)
+				uids, _ := re.Get(key)
+				if !slices.Equal(uids, old) {
+					t.Errorf("Get(%s) = %v, want %v", key, uids, old)
+				}
+			}
+		})
+	}
+
+	// a missing file is not an error
+	err = LoadQueryCache(fileName+".missing", NewQueryCache(10, 0, 0, 5))
+	if err != nil {
+		t.Errorf("LoadQueryCache(missing) = %v", err)
+	}
+}
+
+func TestQueryCacheDamaged(t *testing.T) {
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "query.cache")
+
+	qc := NewQueryCache(10, 100, 0, 1)
+	qc.Put("a", []int32{1, 2})
+	qc.Put("b", []int32{3, 4, 5})
+
+	err := SaveQueryCache(fileName, qc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header is 12 bytes, entry "a" is 4 + 1 + 8 + 4 + 8 = 25 bytes
+	const first = 12 + 25
+
+	patch := func(pos int, val int32) []byte {
+		data := slices.Clone(good)
+		binary.LittleEndian.PutUint32(data[pos:], uint32(val))
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+		want []string
+	}{
+		{"intact", good, "", []string{"b", "a"}},
+		{"empty", nil, "truncated", nil},
+		{"truncated header", good[:6], "truncated", nil},
+		{"truncated key", good[:first+6], "truncated", []string{"a"}},
+		{"truncated UIDs", good[:len(good)-2], "truncated", []string{"a"}},
+		{"missing entries", patch(8, 5), "truncated", []string{"b", "a"}},
+		{"bad magic", append([]byte("XXXX"), good[4:]...), "not a query cache", nil},
+		{"negative key length", patch(first, -1), "damaged", []string{"a"}},
+		{"huge key length", patch(first, 1<<20), "damaged", []string{"a"}},
+		{"huge UID count", patch(first+4+1+8, 1000), "damaged", []string{"a"}},
+		{"unsorted UIDs", patch(12+4+1+8+4, 9), "", []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fpath := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_"))
+			err := os.WriteFile(fpath, tt.data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			re := NewQueryCache(10, 100, 0, 1)
+			err = LoadQueryCache(fpath, re)
+			if tt.err == "" && err != nil {
+				t.Errorf("LoadQueryCache = %v", err)
+			} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("LoadQueryCache = %v, want error containing %q", err, tt.err)
+			}
+			// entries read before the damage are kept
+			got := cacheKeys(re)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("loaded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
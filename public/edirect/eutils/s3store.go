@@ -0,0 +1,450 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  s3store.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 OBJECT STORE
+
+// s3Store keeps records as objects in an S3-compatible bucket (AWS, MinIO, Ceph), using
+// the ArchiveTrie key under an optional prefix, e.g., "Archive/02/53/93/2539356.xml.gz".
+// Each record is sent in a single PUT (records are far below the multipart threshold),
+// and the stashers already issue these concurrently. Failed requests are retried with
+// exponential backoff.
+//
+// Fetched records are kept in a read-through disk cache with the directory trie layout.
+// The writing process updates the cache as it stores and deletes records. Other readers
+// refetch cached records once they are older than the optional time-to-live.
+//
+// The STORAGE file line has the form:
+//
+//	s3 endpoint=http://localhost:9000 bucket=pubmed prefix=Archive region=us-east-1 cache=/path ttl=86400
+//
+// The endpoint and bucket are required. The cache defaults to a Cache folder inside the
+// archive directory, "cache=none" disables it, and a ttl of 0 (the default) never expires
+// cached records. Credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (plus
+// AWS_SESSION_TOKEN if set); without them requests are sent unsigned.
+type s3Store struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	region   string
+	access   string
+	secret   string
+	token    string
+	client   *http.Client
+	cache    *trieStore
+	ttl      time.Duration
+	retries  int
+	backoff  time.Duration
+}
+
+func openS3Store(stsh string, args []string) (*s3Store, error) {
+
+	ss := &s3Store{
+		region:  "us-east-1",
+		access:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:  os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:   os.Getenv("AWS_SESSION_TOKEN"),
+		retries: 5,
+		backoff: 200 * time.Millisecond,
+	}
+
+	if env := os.Getenv("AWS_REGION"); env != "" {
+		ss.region = env
+	}
+
+	cache := filepath.Join(stsh, "Cache")
+
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("s3 storage argument '%s' is not name=value", arg)
+		}
+		switch name {
+		case "endpoint":
+			ss.endpoint = strings.TrimSuffix(value, "/")
+		case "bucket":
+			ss.bucket = value
+		case "prefix":
+			ss.prefix = strings.Trim(value, "/")
+			if ss.prefix != "" {
+				ss.prefix += "/"
+			}
+		case "region":
+			ss.region = value
+		case "cache":
+			cache = value
+		case "ttl":
+			secs, err := strconv.Atoi(value)
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("s3 storage ttl '%s' is not a number of seconds", value)
+			}
+			ss.ttl = time.Duration(secs) * time.Second
+		default:
+			return nil, fmt.Errorf("unrecognized s3 storage argument '%s'", name)
+		}
+	}
+
+	if ss.endpoint == "" || ss.bucket == "" {
+		return nil, errors.New("s3 storage requires endpoint and bucket")
+	}
+
+	if cache != "" && cache != "none" {
+		ss.cache = &trieStore{base: cache}
+	}
+
+	// keep idle connections for concurrent stasher and fetcher goroutines
+	conns := 4 * max(numServe, 4)
+
+	ss.client = &http.Client{
+		Timeout: 2 * time.Minute,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        conns,
+			MaxIdleConnsPerHost: conns,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	return ss, nil
+}
+
+// objectURL returns the path-style URL for a key, or for the bucket if key is empty
+func (ss *s3Store) objectURL(key string, query url.Values) string {
+
+	pth := "/" + ss.bucket + "/"
+	if key != "" {
+		pth += ss.prefix + key
+	}
+
+	str := ss.endpoint + (&url.URL{Path: pth}).EscapedPath()
+	if len(query) > 0 {
+		str += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+
+	return str
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// sign adds AWS Signature Version 4 headers to a request
+func (ss *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", payload)
+	if ss.token != "" {
+		req.Header.Set("x-amz-security-token", ss.token)
+	}
+
+	if ss.access == "" || ss.secret == "" {
+		return
+	}
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if ss.token != "" {
+		names = append(names, "x-amz-security-token")
+	}
+
+	var canon strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canon.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	// query parameters are already sorted and encoded by objectURL
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canon.String(),
+		signed,
+		payload,
+	}, "\n")
+
+	rsum := sha256.Sum256([]byte(request))
+	scope := day + "/" + ss.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(rsum[:])
+
+	key := hmacSHA256([]byte("AWS4"+ss.secret), day)
+	key = hmacSHA256(key, ss.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+ss.access+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// do sends a signed request, retrying network errors, throttling, and server errors,
+// and returns the status and response body
+func (ss *s3Store) do(method, key string, query url.Values, body []byte) (int, []byte, error) {
+
+	target := ss.objectURL(key, query)
+	delay := ss.backoff
+
+	var lastErr error
+
+	for attempt := 0; attempt < ss.retries; attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		req, err := http.NewRequest(method, target, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		req.ContentLength = int64(len(body))
+		ss.sign(req, body, time.Now())
+
+		resp, err := ss.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("%s %s: %s", method, target, resp.Status)
+			continue
+		}
+
+		return resp.StatusCode, data, nil
+	}
+
+	return 0, nil, lastErr
+}
+
+// s3Failure converts an unexpected response into an error
+func s3Failure(method, key string, status int, data []byte) error {
+
+	msg := http.StatusText(status)
+
+	var res struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &res) == nil && res.Code != "" {
+		msg = res.Code + ": " + res.Message
+	}
+
+	return fmt.Errorf("s3 %s %s failed with %d %s", method, key, status, msg)
+}
+
+// cached returns a record from the disk cache unless absent or expired
+func (ss *s3Store) cached(key string) ([]byte, bool) {
+
+	if ss.cache == nil {
+		return nil, false
+	}
+
+	fpath := filepath.Join(ss.cache.base, key)
+
+	if ss.ttl > 0 {
+		fi, err := os.Stat(fpath)
+		if err != nil || time.Since(fi.ModTime()) > ss.ttl {
+			return nil, false
+		}
+	}
+
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (ss *s3Store) Get(key string) ([]byte, error) {
+
+	data, ok := ss.cached(key)
+	if ok {
+		return data, nil
+	}
+
+	status, data, err := ss.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusNotFound {
+		if ss.cache != nil {
+			ss.cache.Delete(key)
+		}
+		return nil, &fs.PathError{Op: "get", Path: key, Err: fs.ErrNotExist}
+	}
+	if status != http.StatusOK {
+		return nil, s3Failure("GET", key, status, data)
+	}
+
+	if ss.cache != nil {
+		// a failure to cache does not prevent returning the record
+		ss.cache.replace(key, data)
+	}
+
+	return data, nil
+}
+
+func (ss *s3Store) Has(key string) bool {
+
+	_, ok := ss.cached(key)
+	if ok {
+		return true
+	}
+
+	status, _, err := ss.do(http.MethodHead, key, nil, nil)
+
+	return err == nil && status == http.StatusOK
+}
+
+func (ss *s3Store) Put(key string, data []byte) error {
+
+	status, body, err := ss.do(http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return s3Failure("PUT", key, status, body)
+	}
+
+	if ss.cache != nil {
+		ss.cache.replace(key, data)
+	}
+
+	return nil
+}
+
+func (ss *s3Store) Delete(key string) error {
+
+	if ss.cache != nil {
+		ss.cache.Delete(key)
+	}
+
+	status, body, err := ss.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusOK && status != http.StatusNotFound {
+		return s3Failure("DELETE", key, status, body)
+	}
+
+	return nil
+}
+
+func (ss *s3Store) Walk(fn func(key string)) error {
+
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if ss.prefix != "" {
+			query.Set("prefix", ss.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		status, data, err := ss.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return s3Failure("LIST", ss.prefix, status, data)
+		}
+
+		var res struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+
+		err = xml.Unmarshal(data, &res)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range res.Contents {
+			key := strings.TrimPrefix(obj.Key, ss.prefix)
+			// skip anything outside the archive trie
+			if strings.Contains(key, "/") {
+				fn(key)
+			}
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return nil
+		}
+
+		token = res.NextContinuationToken
+	}
+}
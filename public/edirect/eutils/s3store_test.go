@@ -0,0 +1,167 @@
+package eutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newObjectServer starts a minimal MinIO-style stand-in supporting path-style PUT, GET,
+// HEAD, DELETE, and ListObjectsV2, which fails the first request for each object with 503
+func newObjectServer(t *testing.T, bucket string) (*httptest.Server, map[string][]byte) {
+
+	var lock sync.Mutex
+	objects := make(map[string][]byte)
+	failed := make(map[string]bool)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key, ok := strings.CutPrefix(r.URL.Path, "/"+bucket+"/")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if key != "" && !failed[key] {
+			failed[key] = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = data
+		case http.MethodGet:
+			if key == "" {
+				var keys []string
+				for k := range objects {
+					if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+						keys = append(keys, k)
+					}
+				}
+				slices.Sort(keys)
+				fmt.Fprintf(w, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+				for _, k := range keys {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", k)
+				}
+				fmt.Fprintf(w, "</ListBucketResult>")
+				return
+			}
+			data, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code></Error>")
+				return
+			}
+			w.Write(data)
+		case http.MethodHead:
+			if _, ok := objects[key]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, objects
+}
+
+func TestS3Store(t *testing.T) {
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+
+	srv, objects := newObjectServer(t, "pubmed")
+
+	stsh := t.TempDir()
+	err := InitArchiveStore(stsh, "s3 endpoint="+srv.URL+" bucket=pubmed prefix=Archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := OpenArchiveStore(stsh)
+	ss, ok := store.(*s3Store)
+	if !ok {
+		t.Fatalf("OpenArchiveStore returned %T, expected *s3Store", store)
+	}
+	ss.backoff = time.Millisecond
+
+	key := ArchiveKey("2539356", "", ".xml.gz")
+
+	// put succeeds after a retry
+	err = store.Put(key, []byte("record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(objects["Archive/02/53/93/2539356.xml.gz"]) != "record" {
+		t.Errorf("object not stored under ArchiveTrie key")
+	}
+
+	// writer cache holds the record
+	cached, err := os.ReadFile(filepath.Join(stsh, "Cache", key))
+	if err != nil || string(cached) != "record" {
+		t.Errorf("record not written through to cache")
+	}
+
+	// cache entries are renamed into place, leaving no temporary files behind
+	ents, err := os.ReadDir(filepath.Join(stsh, "Cache", "02/53/93"))
+	if err != nil || len(ents) != 1 || ents[0].Name() != "2539356.xml.gz" {
+		t.Errorf("cache folder holds %v, %v", ents, err)
+	}
+
+	// reads go to the bucket once the cache is cleared
+	os.RemoveAll(filepath.Join(stsh, "Cache"))
+	data, err := store.Get(key)
+	if err != nil || string(data) != "record" {
+		t.Errorf("Get = %q, %v, expected record", data, err)
+	}
+
+	// and are then served from the read-through cache
+	delete(objects, "Archive/"+key)
+	data, err = store.Get(key)
+	if err != nil || string(data) != "record" {
+		t.Errorf("cached Get = %q, %v, expected record", data, err)
+	}
+
+	store.Put(ArchiveKey("12", "", ".xml.gz"), []byte("second"))
+
+	var keys []string
+	err = store.Walk(func(key string) { keys = append(keys, key) })
+	if err != nil || !slices.Equal(keys, []string{"00/00/00/12.xml.gz"}) {
+		t.Errorf("Walk = %v, %v", keys, err)
+	}
+
+	err = store.Delete(ArchiveKey("12", "", ".xml.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(ArchiveKey("12", "", ".xml.gz"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get after Delete = %v, expected not found", err)
+	}
+	if store.Has(ArchiveKey("12", "", ".xml.gz")) {
+		t.Errorf("Has after Delete = true")
+	}
+}
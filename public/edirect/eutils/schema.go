@@ -0,0 +1,358 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  schema.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/goccy/go-yaml"
+	"github.com/komkom/toml"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// INDEX SCHEMA
+
+// An index schema declares how records of a custom database are indexed, replacing
+// the -idxargs file of raw xtract arguments. It names the record pattern, the path
+// to the record identifier, and the source paths and analyzer for each field:
+//
+//   pattern: TaxonInfo
+//   uid: TaxonInfo/TaxID
+//   default: SCIN
+//   fields:
+//     - name: SCIN
+//       paths: [Scientific]
+//       analyzer: text
+//     - name: RANK
+//       paths: [Rank]
+//     - name: GC
+//       paths: [Nuclear]
+//       analyzer: number
+//
+// CompileIndexSchema turns it into -e2index arguments. A copy saved in the Postings
+// folder by -promote lets query processing treat each field the way it was indexed.
+
+// IndexSchemaName is the name of the saved schema in the Postings directory
+const IndexSchemaName = "schema.json"
+
+// SchemaField describes the source paths, analyzer, and query behavior of one field
+type SchemaField struct {
+	Name     string   `json:"name"`
+	Paths    []string `json:"paths"`
+	Analyzer string   `json:"analyzer,omitempty"`
+	Truncate *bool    `json:"truncate,omitempty"`
+
+	// follow from analyzer, may be given only if they agree with it
+	Positional bool `json:"positional"`
+	Stemmed    bool `json:"stemmed"`
+	Numeric    bool `json:"numeric"`
+}
+
+// IndexSchema describes the record pattern, identifier, and fields of a database
+type IndexSchema struct {
+	Pattern string        `json:"pattern"`
+	UID     string        `json:"uid"`
+	Default string        `json:"default,omitempty"`
+	Fields  []SchemaField `json:"fields"`
+}
+
+// schemaAnalyzers maps analyzer names to xtract extraction commands
+var schemaAnalyzers = map[string]string{
+	"exact":  "-element",
+	"prose":  "-prose",
+	"text":   "-indexer",
+	"stem":   "-stemmer",
+	"number": "-element",
+	"year":   "-year",
+}
+
+// Field returns the named field, or nil if the schema does not declare it
+func (s *IndexSchema) Field(name string) *SchemaField {
+
+	if s == nil {
+		return nil
+	}
+
+	name = strings.ToUpper(name)
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i]
+		}
+	}
+
+	return nil
+}
+
+// Truncatable reports whether queries may use trailing wildcards in the field
+func (f *SchemaField) Truncatable() bool {
+
+	if f.Truncate != nil {
+		return *f.Truncate
+	}
+
+	// wildcards make no sense for numeric fields unless explicitly allowed
+	return !f.Numeric
+}
+
+// isFieldName accepts two to eight uppercase letters or digits, starting with a letter
+func isFieldName(str string) bool {
+
+	if len(str) < 2 || len(str) > 8 {
+		return false
+	}
+
+	for i, ch := range str {
+		if ch >= 'A' && ch <= 'Z' {
+			continue
+		}
+		if i > 0 && ch >= '0' && ch <= '9' {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+// validate checks schema consistency and fills in analyzer-derived field properties
+func (s *IndexSchema) validate() error {
+
+	if s.Pattern == "" {
+		return fmt.Errorf("schema pattern is missing")
+	}
+	if s.UID == "" {
+		return fmt.Errorf("schema uid path is missing")
+	}
+	if len(s.Fields) < 1 {
+		return fmt.Errorf("schema has no fields")
+	}
+
+	seen := make(map[string]bool)
+
+	for i := range s.Fields {
+		fld := &s.Fields[i]
+
+		fld.Name = strings.ToUpper(fld.Name)
+		if !isFieldName(fld.Name) {
+			return fmt.Errorf("schema field name '%s' must be 2 to 8 letters or digits", fld.Name)
+		}
+		if fld.Name == "UID" {
+			return fmt.Errorf("schema field UID is generated from the uid path")
+		}
+		if seen[fld.Name] {
+			return fmt.Errorf("schema field %s is declared more than once", fld.Name)
+		}
+		seen[fld.Name] = true
+
+		if len(fld.Paths) < 1 {
+			return fmt.Errorf("schema field %s has no paths", fld.Name)
+		}
+		for _, pth := range fld.Paths {
+			if strings.TrimSpace(pth) == "" || strings.HasPrefix(pth, "-") {
+				return fmt.Errorf("schema field %s has invalid path '%s'", fld.Name, pth)
+			}
+		}
+
+		if fld.Analyzer == "" {
+			fld.Analyzer = "exact"
+		}
+		fld.Analyzer = strings.ToLower(fld.Analyzer)
+		if _, ok := schemaAnalyzers[fld.Analyzer]; !ok {
+			return fmt.Errorf("schema field %s has unrecognized analyzer '%s'", fld.Name, fld.Analyzer)
+		}
+
+		positional := (fld.Analyzer == "text" || fld.Analyzer == "stem")
+		stemmed := (fld.Analyzer == "stem")
+		numeric := (fld.Analyzer == "number" || fld.Analyzer == "year")
+
+		// explicit properties must agree with the analyzer
+		if fld.Positional && !positional {
+			return fmt.Errorf("schema field %s must use text or stem analyzer to be positional", fld.Name)
+		}
+		if fld.Stemmed && !stemmed {
+			return fmt.Errorf("schema field %s must use stem analyzer to be stemmed", fld.Name)
+		}
+		if fld.Numeric && !numeric {
+			return fmt.Errorf("schema field %s must use number or year analyzer to be numeric", fld.Name)
+		}
+
+		fld.Positional = positional
+		fld.Stemmed = stemmed
+		fld.Numeric = numeric
+	}
+
+	if s.Default != "" {
+		s.Default = strings.ToUpper(s.Default)
+		if !seen[s.Default] {
+			return fmt.Errorf("schema default field %s is not declared", s.Default)
+		}
+	}
+
+	return nil
+}
+
+// ReadIndexSchema reads and validates a YAML, TOML, or JSON index schema file
+func ReadIndexSchema(fpath string) (*IndexSchema, error) {
+
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	// convert to JSON, so all formats share one set of field names
+	switch strings.ToLower(filepath.Ext(fpath)) {
+	case ".yaml", ".yml":
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fpath, err.Error())
+		}
+	case ".toml":
+		var buffer bytes.Buffer
+		_, err = buffer.ReadFrom(toml.New(bytes.NewReader(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fpath, err.Error())
+		}
+		data = buffer.Bytes()
+	case ".json":
+	default:
+		return nil, fmt.Errorf("%s: schema file must end in .yaml, .yml, .toml, or .json", fpath)
+	}
+
+	var schema IndexSchema
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fpath, err.Error())
+	}
+
+	err = schema.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fpath, err.Error())
+	}
+
+	return &schema, nil
+}
+
+// CompileIndexSchema generates the extraction arguments that MakeE2Commands would
+// otherwise read from an -idxargs file
+func CompileIndexSchema(schema *IndexSchema) []string {
+
+	if schema == nil {
+		return nil
+	}
+
+	acc := []string{
+		"-set", "IdxDocumentSet", "-rec", "IdxDocument",
+		"-pattern", schema.Pattern, "-UID", schema.UID,
+		"-wrp", "IdxUid", "-element", "&UID", "-clr", "-rst", "-tab", "",
+		"-group", schema.Pattern, "-pkg", "IdxSearchFields",
+		"-block", schema.Pattern, "-wrp", "UID", "-pad", "&UID",
+	}
+
+	for _, fld := range schema.Fields {
+		acc = append(acc, "-block", schema.Pattern, "-wrp", fld.Name, schemaAnalyzers[fld.Analyzer])
+		if fld.Positional {
+			// positions continue across all paths of an indexer field
+			acc = append(acc, strings.Join(fld.Paths, ","))
+		} else {
+			acc = append(acc, fld.Paths...)
+		}
+	}
+
+	return acc
+}
+
+// SaveIndexSchema records the compiled schema alongside the postings it describes
+func SaveIndexSchema(schema *IndexSchema, postings string) error {
+
+	if schema == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(postings, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fpath := filepath.Join(postings, IndexSchemaName)
+
+	// write to temporary file and rename, so queries never see a partial schema
+	tmp := fpath + ".tmp"
+	err = os.WriteFile(tmp, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fpath)
+}
+
+var (
+	schemaLock sync.Mutex
+	schemaMap  = make(map[string]*IndexSchema)
+)
+
+// postingsSchema returns the schema saved with a database's postings, or nil for
+// databases indexed with -idxargs
+func postingsSchema(db string) *IndexSchema {
+
+	schemaLock.Lock()
+	defer schemaLock.Unlock()
+
+	schema, ok := schemaMap[db]
+	if ok {
+		return schema
+	}
+
+	base, _ := GetLocalArchivePaths(db)
+	if base != "" {
+		fpath := filepath.Join(base+"Postings", IndexSchemaName)
+		if _, err := os.Stat(fpath); err == nil {
+			schema, err = ReadIndexSchema(fpath)
+			if err != nil {
+				DisplayWarning("Ignoring index schema: %s", err.Error())
+			}
+		}
+	}
+
+	// remember missing schema too, avoiding a stat on every query
+	schemaMap[db] = schema
+
+	return schema
+}
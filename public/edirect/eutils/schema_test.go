@@ -0,0 +1,120 @@
+package eutils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const widgetYAML = `pattern: Widget
+uid: Widget/WID
+default: name
+fields:
+  - name: NAME
+    paths: [Name, Alias]
+    analyzer: text
+  - name: CLR
+    paths: [Color, Shade]
+    truncate: false
+  - name: WGT
+    paths: [Weight]
+    analyzer: number
+`
+
+const widgetTOML = `pattern = "Widget"
+uid = "Widget/WID"
+default = "NAME"
+
+[[fields]]
+name = "NAME"
+paths = ["Name", "Alias"]
+analyzer = "text"
+
+[[fields]]
+name = "CLR"
+paths = ["Color", "Shade"]
+truncate = false
+
+[[fields]]
+name = "WGT"
+paths = ["Weight"]
+analyzer = "number"
+`
+
+func TestReadIndexSchema(t *testing.T) {
+
+	dir := t.TempDir()
+
+	var compiled [][]string
+
+	for name, text := range map[string]string{"widget.yaml": widgetYAML, "widget.toml": widgetTOML} {
+		fpath := filepath.Join(dir, name)
+		err := os.WriteFile(fpath, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		schema, err := ReadIndexSchema(fpath)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if schema.Default != "NAME" {
+			t.Errorf("%s: default = %q", name, schema.Default)
+		}
+		if fs := schema.Field("name"); fs == nil || !fs.Positional || fs.Stemmed || !fs.Truncatable() {
+			t.Errorf("%s: NAME field = %+v", name, fs)
+		}
+		if fs := schema.Field("CLR"); fs == nil || fs.Positional || fs.Truncatable() {
+			t.Errorf("%s: CLR field = %+v", name, fs)
+		}
+		if fs := schema.Field("WGT"); fs == nil || !fs.Numeric || fs.Truncatable() {
+			t.Errorf("%s: WGT field = %+v", name, fs)
+		}
+
+		compiled = append(compiled, CompileIndexSchema(schema))
+	}
+
+	if !slices.Equal(compiled[0], compiled[1]) {
+		t.Errorf("YAML and TOML schemas compile differently:\n%v\n%v", compiled[0], compiled[1])
+	}
+
+	args := strings.Join(compiled[0], " ")
+	for _, want := range []string{
+		"-pattern Widget -UID Widget/WID",
+		"-wrp NAME -indexer Name,Alias",
+		"-wrp CLR -element Color Shade",
+		"-wrp WGT -element Weight",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("compiled arguments missing %q: %s", want, args)
+		}
+	}
+}
+
+func TestIndexSchemaErrors(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"unknown analyzer": "pattern: W\nuid: W/ID\nfields:\n  - name: AB\n    paths: [A]\n    analyzer: fuzzy\n",
+		"missing paths":    "pattern: W\nuid: W/ID\nfields:\n  - name: AB\n",
+		"reserved name":    "pattern: W\nuid: W/ID\nfields:\n  - name: UID\n    paths: [A]\n",
+		"bad default":      "pattern: W\nuid: W/ID\ndefault: XY\nfields:\n  - name: AB\n    paths: [A]\n",
+		"conflict":         "pattern: W\nuid: W/ID\nfields:\n  - name: AB\n    paths: [A]\n    stemmed: true\n",
+		"unknown key":      "pattern: W\nuid: W/ID\nfields:\n  - name: AB\n    path: [A]\n",
+	}
+
+	for label, text := range tests {
+		fpath := filepath.Join(dir, "bad.yaml")
+		err := os.WriteFile(fpath, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadIndexSchema(fpath)
+		if err == nil {
+			t.Errorf("%s: expected error", label)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  scrub.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ARCHIVE CHECKSUM MANIFEST AND INTEGRITY SCRUB
+
+// ChecksumManifest is the sidecar file in each archive trie folder that lists the CRC32
+// checksum and size of every record CreateStashers has written there, one tab-delimited
+// line per record file, e.g., "2539356.xml.gz	3897312157	1042"
+const ChecksumManifest = "checksums.tsv"
+
+type manifestEntry struct {
+	crc  uint32
+	size int
+}
+
+// readManifest returns the checksum entries for a folder, or nil if it has no manifest
+func readManifest(store ArchiveStore, dir string) map[string]manifestEntry {
+
+	data, err := store.Get(dir + ChecksumManifest)
+	if err != nil {
+		return nil
+	}
+
+	res := make(map[string]manifestEntry)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		cols := strings.Split(line, "\t")
+		if len(cols) != 3 {
+			continue
+		}
+		crc, err := strconv.ParseUint(cols[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.Atoi(cols[2])
+		if err != nil {
+			continue
+		}
+		res[cols[0]] = manifestEntry{crc: uint32(crc), size: size}
+	}
+
+	return res
+}
+
+func formatManifest(entries map[string]manifestEntry) []byte {
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, CompareAlphaOrNumericKeys)
+
+	var buf bytes.Buffer
+
+	for _, name := range names {
+		ent := entries[name]
+		fmt.Fprintf(&buf, "%s\t%d\t%d\n", name, ent.crc, ent.size)
+	}
+
+	return buf.Bytes()
+}
+
+// manifestBatch collects the checksum changes of one stash, delete, or import run,
+// and rewrites each folder manifest once with all of them, instead of reading and
+// rewriting it for every record. That matters most for packed and s3 storage, where
+// every rewrite stores a whole new manifest. Pending changes are also written after
+// maxManifestPending records, which bounds memory when loading a full baseline.
+type manifestBatch struct {
+	lock    sync.Mutex
+	store   ArchiveStore
+	stsh    string
+	pending map[string]map[string]*manifestEntry
+	count   int
+}
+
+const maxManifestPending = 100000
+
+// manifestLockName is the advisory lock held while merging changes into manifests,
+// so runs in separate processes do not overwrite each other's entries
+const manifestLockName = "CHECKSUMS.lock"
+
+// manifest writes from different batches in one process are also serialized here,
+// for platforms without advisory file locks
+var manifestWrite sync.Mutex
+
+func newManifestBatch(store ArchiveStore, stsh string) *manifestBatch {
+
+	return &manifestBatch{
+		store:   store,
+		stsh:    stsh,
+		pending: make(map[string]map[string]*manifestEntry),
+	}
+}
+
+// record notes the checksum of newly stored record data, or removal of its entry if data is nil
+func (mb *manifestBatch) record(key string, data []byte) error {
+
+	dir, name := path.Split(key)
+
+	var ent *manifestEntry
+	if data != nil {
+		ent = &manifestEntry{crc: crc32.ChecksumIEEE(data), size: len(data)}
+	}
+
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	changes, ok := mb.pending[dir]
+	if !ok {
+		changes = make(map[string]*manifestEntry)
+		mb.pending[dir] = changes
+	}
+	changes[name] = ent
+	mb.count++
+
+	if mb.count < maxManifestPending {
+		return nil
+	}
+
+	return mb.write()
+}
+
+// flush writes all pending changes, and must be called when the run is finished
+func (mb *manifestBatch) flush() error {
+
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	return mb.write()
+}
+
+// write merges pending changes into folder manifests, should be called within the batch lock
+func (mb *manifestBatch) write() error {
+
+	if len(mb.pending) < 1 {
+		return nil
+	}
+
+	manifestWrite.Lock()
+	defer manifestWrite.Unlock()
+
+	unlock, err := flockFile(context.Background(), filepath.Join(mb.stsh, manifestLockName), true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dirs := make([]string, 0, len(mb.pending))
+	for dir := range mb.pending {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+
+	var firstErr error
+
+	for _, dir := range dirs {
+
+		entries := readManifest(mb.store, dir)
+		if entries == nil {
+			entries = make(map[string]manifestEntry)
+		}
+
+		changed := false
+		for name, ent := range mb.pending[dir] {
+			if ent != nil {
+				entries[name] = *ent
+				changed = true
+			} else if _, ok := entries[name]; ok {
+				delete(entries, name)
+				changed = true
+			}
+		}
+
+		if changed {
+			err = mb.store.Put(dir+ChecksumManifest, formatManifest(entries))
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	clear(mb.pending)
+	mb.count = 0
+
+	return firstErr
+}
+
+// updateManifest records the checksum of one record immediately, or removes the
+// entry if data is nil
+func updateManifest(store ArchiveStore, stsh, key string, data []byte) error {
+
+	mb := newManifestBatch(store, stsh)
+
+	err := mb.record(key, data)
+	if err != nil {
+		return err
+	}
+
+	return mb.flush()
+}
+
+// RefreshChecksum adds the current contents of a record to its folder manifest,
+// used to accept records that were archived before manifests existed
+func RefreshChecksum(stsh, key string) error {
+
+	store := OpenArchiveStore(stsh)
+
+	data, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return updateManifest(store, stsh, key, data)
+}
+
+// ScrubIssue describes one problem found by CreateScrubbers
+type ScrubIssue struct {
+	Key     string `json:"key"`
+	ID      string `json:"id,omitempty"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// scrub problem types
+const (
+	// compressed data ends early or fails its internal checksum
+	ScrubTruncated = "truncated"
+	// record differs from its manifest checksum or size
+	ScrubMismatch = "mismatch"
+	// record is absent from its folder manifest
+	ScrubUnlisted = "unlisted"
+	// manifest lists a record that is not in the folder
+	ScrubMissing = "missing"
+	// file is not a record, revision, history, or manifest, or is in the wrong folder
+	ScrubOrphan = "orphan"
+	// record could not be read
+	ScrubUnreadable = "unreadable"
+)
+
+// isRecordFile reports whether a file name has a stashed record suffix
+func isRecordFile(name string) bool {
+
+	for _, sfx := range []string{".xml", ".asn", ".e2x", ".xml.gz", ".asn.gz", ".e2x.gz", ".xml.zst", ".asn.zst", ".e2x.zst"} {
+		if strings.HasSuffix(name, sfx) {
+			base := strings.TrimSuffix(name, sfx)
+			return base != "" && !strings.Contains(base, ".")
+		}
+	}
+
+	return false
+}
+
+// isSidecarFile reports whether a file name is a manifest, history list, or prior revision
+func isSidecarFile(name string) bool {
+
+	if name == ChecksumManifest {
+		return true
+	}
+
+	if strings.HasSuffix(name, ".hst") {
+		return isRecordFile(strings.TrimSuffix(name, ".hst"))
+	}
+
+	pos := strings.LastIndex(name, ".v")
+	if pos > 0 && IsAllDigits(name[pos+2:]) {
+		return isRecordFile(name[:pos])
+	}
+
+	return false
+}
+
+// recordIdentifier returns the identifier part of a record file name
+func recordIdentifier(name string) string {
+
+	id, _, _ := strings.Cut(name, ".")
+
+	return id
+}
+
+// inTrieFolder checks that a record was stored where ArchiveTrie would put it,
+// allowing for a file prefix such as "PMC" that is not part of the trie path
+func inTrieFolder(dir, id string) bool {
+
+	trimmed := strings.TrimLeftFunc(id, func(ch rune) bool {
+		return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z')
+	})
+
+	for _, str := range []string{id, trimmed} {
+		if str == "" {
+			continue
+		}
+		trie, _ := ArchiveTrie(str)
+		if trie == dir {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRecord reads and verifies one record file
+func checkRecord(stsh string, store ArchiveStore, key string, ent manifestEntry, listed bool) *ScrubIssue {
+
+	_, name := path.Split(key)
+	id := recordIdentifier(name)
+
+	data, err := store.Get(key)
+	if err != nil {
+		return &ScrubIssue{Key: key, ID: id, Problem: ScrubUnreadable, Detail: err.Error()}
+	}
+
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".zst") {
+		// decompress every gzip member or zstd frame, stashed pubmed records have a separate header
+		_, err = DecompressRecord(stsh, data)
+		if err != nil {
+			return &ScrubIssue{Key: key, ID: id, Problem: ScrubTruncated, Detail: err.Error()}
+		}
+	}
+
+	if !listed {
+		return &ScrubIssue{Key: key, ID: id, Problem: ScrubUnlisted}
+	}
+
+	crc := crc32.ChecksumIEEE(data)
+	if crc != ent.crc || len(data) != ent.size {
+		detail := fmt.Sprintf("expected crc %d size %d, found crc %d size %d", ent.crc, ent.size, crc, len(data))
+		return &ScrubIssue{Key: key, ID: id, Problem: ScrubMismatch, Detail: detail}
+	}
+
+	return nil
+}
+
+// VerifyRecord checks one stored record against its folder manifest, returning nil if
+// it can be read, decompresses cleanly, and matches its listed checksum
+func VerifyRecord(stsh, key string) *ScrubIssue {
+
+	store := OpenArchiveStore(stsh)
+
+	dir, name := path.Split(key)
+	ent, listed := readManifest(store, dir)[name]
+
+	return checkRecord(stsh, store, key, ent, listed)
+}
+
+// scrubFolder checks all files in one archive folder against its manifest
+func scrubFolder(stsh string, store ArchiveStore, dir string, names []string, out chan<- ScrubIssue) int {
+
+	entries := readManifest(store, dir)
+
+	present := make(map[string]bool)
+	count := 0
+
+	for _, name := range names {
+
+		key := dir + name
+
+		if isSidecarFile(name) {
+			continue
+		}
+
+		if !isRecordFile(name) {
+			out <- ScrubIssue{Key: key, Problem: ScrubOrphan, Detail: "unrecognized file"}
+			continue
+		}
+
+		id := recordIdentifier(name)
+		if !inTrieFolder(dir, id) {
+			out <- ScrubIssue{Key: key, ID: id, Problem: ScrubOrphan, Detail: "record in wrong folder"}
+			continue
+		}
+
+		present[name] = true
+		count++
+
+		ent, listed := entries[name]
+		if issue := checkRecord(stsh, store, key, ent, listed); issue != nil {
+			out <- *issue
+		}
+	}
+
+	for name := range entries {
+		if !present[name] {
+			out <- ScrubIssue{Key: dir + name, ID: recordIdentifier(name), Problem: ScrubMissing}
+		}
+	}
+
+	return count
+}
+
+// visitStoreFolders sends each archive folder path, with a trailing slash, followed by
+// the names of the files in it, streaming the directory trie and grouping other backends
+func visitStoreFolders(store ArchiveStore, out chan<- []string) {
+
+	defer close(out)
+
+	ts, ok := store.(*trieStore)
+	if !ok {
+		folders := make(map[string][]string)
+		store.Walk(func(key string) {
+			dir, name := path.Split(key)
+			folders[dir] = append(folders[dir], name)
+		})
+		for dir, names := range folders {
+			out <- append([]string{dir}, names...)
+		}
+		return
+	}
+
+	// recursive definition
+	var visitSubFolders func(rel string)
+
+	visitSubFolders = func(rel string) {
+
+		contents, err := os.ReadDir(filepath.Join(ts.base, rel))
+		if err != nil {
+			return
+		}
+
+		var names []string
+
+		for _, item := range contents {
+			name := item.Name()
+			if item.IsDir() {
+				visitSubFolders(rel + name + "/")
+			} else {
+				names = append(names, name)
+			}
+		}
+
+		if len(names) > 0 {
+			out <- append([]string{rel}, names...)
+		}
+	}
+
+	contents, err := os.ReadDir(ts.base)
+	if err != nil {
+		return
+	}
+
+	for _, item := range contents {
+		name := item.Name()
+		// top-level files and Sentinels folder are not part of the trie
+		if item.IsDir() && name != "Sentinels" {
+			visitSubFolders(name + "/")
+		}
+	}
+}
+
+// CreateScrubbers walks the archive, checking folders in parallel, and reports truncated
+// or mismatched records, records absent from the manifest, manifest entries without a
+// record, and orphaned files, and counts the records examined
+func CreateScrubbers(stsh string) (<-chan ScrubIssue, *atomic.Int64) {
+
+	if stsh == "" {
+		return nil, nil
+	}
+
+	store := OpenArchiveStore(stsh)
+
+	fldq := make(chan []string, chanDepth)
+	out := make(chan ScrubIssue, chanDepth)
+	if fldq == nil || out == nil {
+		DisplayError("Unable to create scrubber channel")
+		os.Exit(1)
+	}
+
+	var count atomic.Int64
+
+	// launch single folder visitor goroutine
+	go visitStoreFolders(store, fldq)
+
+	scrubber := func(wg *sync.WaitGroup, inp <-chan []string, out chan<- ScrubIssue) {
+
+		defer wg.Done()
+
+		for fldr := range inp {
+			count.Add(int64(scrubFolder(stsh, store, fldr[0], fldr[1:], out)))
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	// launch multiple scrubber goroutines
+	for range max(numServe, 1) {
+		wg.Add(1)
+		go scrubber(&wg, fldq, out)
+	}
+
+	// launch separate anonymous goroutine to wait until all scrubbers are done
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, &count
+}
@@ -0,0 +1,756 @@
+// ===========================================================================
+//
+//                            PUBLIC DOMAIN NOTICE
+//            National Center for Biotechnology Information (NCBI)
+//
+//  This software/database is a "United States Government Work" under the
+//  terms of the United States Copyright Act. It was written as part of
+//  the author's official duties as a United States Government employee and
+//  thus cannot be copyrighted. This software/database is freely available
+//  to the public for use. The National Library of Medicine and the U.S.
+//  Government do not place any restriction on its use or reproduction.
+//  We would, however, appreciate having the NCBI and the author cited in
+//  any work or product based on this material.
+//
+//  Although all reasonable efforts have been taken to ensure the accuracy
+//  and reliability of the software and data, the NLM and the U.S.
+//  Government do not and cannot warrant the performance or results that
+//  may be obtained by using this software or data. The NLM and the U.S.
+//  Government disclaim all warranties, express or implied, including
+//  warranties of performance, merchantability or fitness for any particular
+//  purpose.
+//
+// ===========================================================================
+//
+// File Name:  storage.go
+//
+// ==========================================================================
+
+package eutils
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ARCHIVE STORAGE BACKENDS
+
+// An ArchiveStore holds archived records, as they are written by CreateStashers
+// (usually gzip-compressed), under keys derived from ArchiveTrie, e.g.,
+// "02/53/93/2539356.xml.gz". The original implementation is a trie of directories
+// with one file per record. Alternative backends keep the same keys, so CreateStashers,
+// CreateFetchers, CreateCacheStreamers, CreateDeleter, IncrementalIndex, rchive, and
+// edict work unchanged on any of them.
+//
+// The backend for an archive directory is named in the first line of a STORAGE file
+// at its top level, written once by "rchive -storage", and defaults to "trie" if the
+// file is absent. Stores are opened once per process and shared.
+type ArchiveStore interface {
+	// Get returns the stored bytes for a key, or an error satisfying
+	// errors.Is(err, fs.ErrNotExist) if the record is absent
+	Get(key string) ([]byte, error)
+	// Has reports whether a key is present without reading its contents
+	Has(key string) bool
+	// Put saves data under a key, replacing any previous contents
+	Put(key string, data []byte) error
+	// Delete removes a key, which need not exist
+	Delete(key string) error
+	// Walk calls fn for each stored key, in no particular order
+	Walk(fn func(key string)) error
+}
+
+// StorageMarker is the name of the file that selects an archive's storage backend
+const StorageMarker = "STORAGE"
+
+// ArchiveKey returns the storage key for a record identifier, with optional file prefix and suffix
+func ArchiveKey(id, pfx, sfx string) string {
+
+	dir, file := ArchiveTrie(id)
+	if dir == "" || file == "" {
+		return ""
+	}
+
+	// "02/53/93/" + "2539356" + ".xml.gz"
+	return dir + pfx + file + sfx
+}
+
+var (
+	storeLock sync.Mutex
+	storeMap  = make(map[string]ArchiveStore)
+)
+
+// OpenArchiveStore returns the shared storage backend for an archive directory
+func OpenArchiveStore(stsh string) ArchiveStore {
+
+	stsh = filepath.Clean(stsh)
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	store, ok := storeMap[stsh]
+	if ok {
+		return store
+	}
+
+	kind, args := readStorageMarker(stsh)
+
+	var err error
+
+	switch kind {
+	case "", "trie":
+		store = &trieStore{base: stsh}
+	case "packed":
+		store, err = openPackedStore(stsh)
+	case "s3":
+		store, err = openS3Store(stsh, args)
+	default:
+		err = fmt.Errorf("unrecognized storage type '%s' in %s", kind, filepath.Join(stsh, StorageMarker))
+	}
+
+	if err != nil {
+		DisplayError("Unable to open archive storage: %s", err.Error())
+		os.Exit(1)
+	}
+
+	storeMap[stsh] = store
+
+	return store
+}
+
+// readStorageMarker returns the backend name and any remaining words from the STORAGE file
+func readStorageMarker(stsh string) (string, []string) {
+
+	data, err := os.ReadFile(filepath.Join(stsh, StorageMarker))
+	if err != nil {
+		return "", nil
+	}
+
+	line, _, _ := strings.Cut(string(data), "\n")
+	words := strings.Fields(line)
+	if len(words) < 1 {
+		return "", nil
+	}
+
+	return strings.ToLower(words[0]), words[1:]
+}
+
+// InitArchiveStore records the storage backend for a new archive directory, failing if
+// a different backend has already been selected
+func InitArchiveStore(stsh, spec string) error {
+
+	words := strings.Fields(spec)
+	if len(words) < 1 {
+		return errors.New("storage type is missing")
+	}
+	kind := strings.ToLower(words[0])
+
+	switch kind {
+	case "trie", "packed":
+	case "s3":
+		// check arguments before recording them
+		_, err := openS3Store(stsh, words[1:])
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unrecognized storage type '%s'", kind)
+	}
+
+	prev, _ := readStorageMarker(stsh)
+	if prev == "" {
+		prev = "trie"
+		_, err := os.Stat(filepath.Join(stsh, StorageMarker))
+		if err != nil && os.IsNotExist(err) {
+			err = os.MkdirAll(stsh, os.ModePerm)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(stsh, StorageMarker), []byte(strings.Join(words, " ")+"\n"), 0644)
+		}
+	}
+
+	if prev != kind {
+		return fmt.Errorf("archive %s already uses %s storage", stsh, prev)
+	}
+
+	return nil
+}
+
+// DIRECTORY TRIE STORE
+
+// trieStore keeps one file per record in nested two-character subdirectories
+type trieStore struct {
+	base string
+}
+
+func (ts *trieStore) Get(key string) ([]byte, error) {
+
+	return os.ReadFile(filepath.Join(ts.base, key))
+}
+
+func (ts *trieStore) Has(key string) bool {
+
+	_, err := os.Stat(filepath.Join(ts.base, key))
+
+	return err == nil
+}
+
+func (ts *trieStore) Put(key string, data []byte) error {
+
+	fpath := filepath.Join(ts.base, key)
+
+	err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// overwrites and truncates existing file
+	fl, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+
+	_, err = fl.Write(data)
+	if err != nil {
+		fl.Close()
+		return err
+	}
+
+	// fl.Sync()
+
+	return fl.Close()
+}
+
+// replace writes a record to a temporary file and renames it into place, so concurrent
+// readers see either the old or the new contents, never a partial file
+func (ts *trieStore) replace(key string, data []byte) error {
+
+	fpath := filepath.Join(ts.base, key)
+	dir, name := filepath.Split(fpath)
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fl, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+
+	// temporary files are created private, cached records are readable like stashed ones
+	err = fl.Chmod(0644)
+	if err == nil {
+		_, err = fl.Write(data)
+	}
+	if err == nil {
+		err = fl.Close()
+	} else {
+		fl.Close()
+	}
+	if err == nil {
+		err = os.Rename(fl.Name(), fpath)
+	}
+	if err != nil {
+		os.Remove(fl.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (ts *trieStore) Delete(key string) error {
+
+	err := os.Remove(filepath.Join(ts.base, key))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (ts *trieStore) Walk(fn func(key string)) error {
+
+	return filepath.WalkDir(ts.base, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(ts.base, fpath)
+		if err != nil {
+			return err
+		}
+		// skip top-level files, such as STORAGE, and the Sentinels folder
+		if !strings.Contains(rel, string(filepath.Separator)) || strings.HasPrefix(rel, "Sentinels") {
+			return nil
+		}
+		fn(filepath.ToSlash(rel))
+		return nil
+	})
+}
+
+// PACKED SEGMENT STORE
+
+// packedStore appends records to a series of large segment files, Packed/seg000001.dat
+// and onward, and appends the location of each record (or a deletion marker) to
+// Packed/index.log. Replaying the log at open rebuilds the key to location map. The
+// last entry for a key wins. Space held by replaced or deleted records is not
+// reclaimed in place.
+//
+// Each index entry is little endian: key length (uint16), key bytes, segment number
+// (uint32), offset (int64), and data length (int32, -1 for deletion).
+//
+// Several processes (rchive stashing or importing, edict filling missing records)
+// may write a packed store at once. Each Put or Delete holds an exclusive lock on
+// Packed/index.lock, catches up with entries appended by other writers, and takes
+// its offset from the current segment size, so records never overlap. Readers pick
+// up additions and deletions by rereading the tail of the index log whenever it has
+// grown.
+type packedStore struct {
+	lock     sync.RWMutex
+	dir      string
+	entries  map[string]packedLoc
+	logSize  int64
+	segments map[uint32]*os.File
+	current  uint32
+	maxSize  int64
+	logFile  *os.File
+	segFile  *os.File
+	segNum   uint32
+}
+
+type packedLoc struct {
+	seg    uint32
+	offset int64
+	length int32
+}
+
+// segments roll over at 1 GB
+const packedSegmentSize = 1 << 30
+
+const packedIndexName = "index.log"
+
+const packedLockName = "index.lock"
+
+func segmentName(seg uint32) string {
+
+	str := strconv.FormatUint(uint64(seg), 10)
+	if len(str) < 6 {
+		str = "000000"[len(str):] + str
+	}
+
+	return "seg" + str + ".dat"
+}
+
+func openPackedStore(stsh string) (*packedStore, error) {
+
+	dir := filepath.Join(stsh, "Packed")
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &packedStore{
+		dir:      dir,
+		entries:  make(map[string]packedLoc),
+		segments: make(map[uint32]*os.File),
+		maxSize:  packedSegmentSize,
+	}
+
+	err = ps.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// refresh reads index entries appended since the last call, should be called within a write lock
+func (ps *packedStore) refresh() error {
+
+	fl, err := os.Open(filepath.Join(ps.dir, packedIndexName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	defer fl.Close()
+
+	_, err = fl.Seek(ps.logSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	rdr := bufio.NewReader(fl)
+
+	for {
+		var klen uint16
+		var loc packedLoc
+
+		err = binary.Read(rdr, binary.LittleEndian, &klen)
+		if err != nil {
+			break
+		}
+		key := make([]byte, klen)
+		_, err = io.ReadFull(rdr, key)
+		if err == nil {
+			err = binary.Read(rdr, binary.LittleEndian, &loc.seg)
+		}
+		if err == nil {
+			err = binary.Read(rdr, binary.LittleEndian, &loc.offset)
+		}
+		if err == nil {
+			err = binary.Read(rdr, binary.LittleEndian, &loc.length)
+		}
+		if err != nil {
+			// entry being written by another process, pick it up next time
+			break
+		}
+
+		ps.logSize += int64(2 + int(klen) + 4 + 8 + 4)
+
+		if loc.length < 0 {
+			delete(ps.entries, string(key))
+		} else {
+			ps.entries[string(key)] = loc
+		}
+
+		if loc.seg > ps.current {
+			ps.current = loc.seg
+		}
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+
+	return err
+}
+
+// catchUp rereads the index log if another process has appended to it
+func (ps *packedStore) catchUp() error {
+
+	fi, err := os.Stat(filepath.Join(ps.dir, packedIndexName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	ps.lock.RLock()
+	stale := fi.Size() > ps.logSize
+	ps.lock.RUnlock()
+
+	if !stale {
+		return nil
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return ps.refresh()
+}
+
+// segment returns an open segment file for reading, should be called within a lock
+func (ps *packedStore) segment(seg uint32) (*os.File, error) {
+
+	fl, ok := ps.segments[seg]
+	if ok {
+		return fl, nil
+	}
+
+	fl, err := os.Open(filepath.Join(ps.dir, segmentName(seg)))
+	if err != nil {
+		return nil, err
+	}
+
+	ps.segments[seg] = fl
+
+	return fl, nil
+}
+
+func (ps *packedStore) Get(key string) ([]byte, error) {
+
+	err := ps.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	ps.lock.RLock()
+	loc, ok := ps.entries[key]
+	ps.lock.RUnlock()
+
+	if !ok {
+		return nil, &fs.PathError{Op: "get", Path: key, Err: fs.ErrNotExist}
+	}
+
+	ps.lock.Lock()
+	fl, err := ps.segment(loc.seg)
+	ps.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadAt is safe for concurrent use
+	data := make([]byte, loc.length)
+	_, err = fl.ReadAt(data, loc.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ps *packedStore) Has(key string) bool {
+
+	ps.catchUp()
+
+	ps.lock.RLock()
+	_, ok := ps.entries[key]
+	ps.lock.RUnlock()
+
+	return ok
+}
+
+// appendEntry writes one index log entry, should be called within a write lock
+func (ps *packedStore) appendEntry(key string, loc packedLoc) error {
+
+	if len(key) > 65535 {
+		return fmt.Errorf("key '%s' is too long", key)
+	}
+
+	if ps.logFile == nil {
+		fl, err := os.OpenFile(filepath.Join(ps.dir, packedIndexName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		ps.logFile = fl
+	}
+
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(key)))
+	buf = append(buf, key...)
+	buf = binary.LittleEndian.AppendUint32(buf, loc.seg)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(loc.offset))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(loc.length))
+
+	_, err := ps.logFile.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	ps.logSize += int64(len(buf))
+
+	return nil
+}
+
+// lockWriters takes the lock that serializes writers in all processes, and brings
+// the index up to date with their entries, should be called within a write lock
+func (ps *packedStore) lockWriters() (func(), error) {
+
+	unlock, err := flockFile(context.Background(), filepath.Join(ps.dir, packedLockName), true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.refresh()
+	if err == nil {
+		err = ps.trimLog()
+	}
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return unlock, nil
+}
+
+// trimLog removes a partial entry left at the end of the index log by a writer that
+// crashed, so the next entry is not appended after it, should be called while holding
+// the writer lock, when no other process can be partway through an entry
+func (ps *packedStore) trimLog() error {
+
+	fpath := filepath.Join(ps.dir, packedIndexName)
+
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if fi.Size() <= ps.logSize {
+		return nil
+	}
+
+	return os.Truncate(fpath, ps.logSize)
+}
+
+func (ps *packedStore) Put(key string, data []byte) error {
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	unlock, err := ps.lockWriters()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if ps.current == 0 {
+		ps.current = 1
+	}
+
+	// another writer may have started a new segment
+	if ps.segFile != nil && ps.segNum != ps.current {
+		ps.segFile.Close()
+		ps.segFile = nil
+	}
+
+	// append to current segment, starting a new one when full, and take the
+	// offset from its actual size, which no other writer can change while locked
+	var offset int64
+
+	for {
+		if ps.segFile == nil {
+			fl, err := os.OpenFile(filepath.Join(ps.dir, segmentName(ps.current)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			ps.segFile = fl
+			ps.segNum = ps.current
+		}
+		fi, err := ps.segFile.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() > 0 && fi.Size()+int64(len(data)) > ps.maxSize {
+			ps.segFile.Close()
+			ps.segFile = nil
+			ps.current++
+			continue
+		}
+		offset = fi.Size()
+		break
+	}
+
+	loc := packedLoc{seg: ps.current, offset: offset, length: int32(len(data))}
+
+	// data is written before its index entry, so a crash never leaves a dangling entry
+	_, err = ps.segFile.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = ps.appendEntry(key, loc)
+	if err != nil {
+		return err
+	}
+
+	ps.entries[key] = loc
+
+	return nil
+}
+
+func (ps *packedStore) Delete(key string) error {
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	unlock, err := ps.lockWriters()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, ok := ps.entries[key]
+	if !ok {
+		return nil
+	}
+
+	err = ps.appendEntry(key, packedLoc{length: -1})
+	if err != nil {
+		return err
+	}
+
+	delete(ps.entries, key)
+
+	return nil
+}
+
+func (ps *packedStore) Walk(fn func(key string)) error {
+
+	err := ps.catchUp()
+
+	ps.lock.RLock()
+	keys := make([]string, 0, len(ps.entries))
+	for key := range ps.entries {
+		keys = append(keys, key)
+	}
+	ps.lock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		fn(key)
+	}
+
+	return nil
+}
+
+// archiveFolders groups store keys with any of the given suffixes by trie folder, sending
+// each folder path followed by its sorted record names, as visitArchiveFolders does for
+// the directory trie
+func archiveFolders(store ArchiveStore, sfxs ...string) [][]string {
+
+	folders := make(map[string][]string)
+
+	store.Walk(func(key string) {
+		for _, sfx := range sfxs {
+			if strings.HasSuffix(key, sfx) {
+				dir, file := path.Split(key)
+				folders[dir] = append(folders[dir], strings.TrimSuffix(file, sfx))
+				return
+			}
+		}
+	})
+
+	dirs := make([]string, 0, len(folders))
+	for dir := range folders {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+
+	var res [][]string
+
+	for _, dir := range dirs {
+		files := folders[dir]
+		slices.SortFunc(files, CompareAlphaOrNumericKeys)
+		// records being migrated between codecs may be present twice
+		files = slices.Compact(files)
+		res = append(res, append([]string{strings.TrimSuffix(dir, "/")}, files...))
+	}
+
+	return res
+}
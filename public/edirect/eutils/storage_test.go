@@ -0,0 +1,223 @@
+package eutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestPackedStoreRoundTrip(t *testing.T) {
+
+	stsh := t.TempDir()
+
+	ps, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]string{
+		"02/53/93/2539356.xml.gz":  "first record",
+		"00/00/01/1.xml.gz":        "second record",
+		"37/01/19/37011990.xml.gz": "third record",
+	}
+	for key, val := range records {
+		err = ps.Put(key, []byte(val))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// replacing a record keeps only the latest contents
+	err = ps.Put("00/00/01/1.xml.gz", []byte("second record, revised"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records["00/00/01/1.xml.gz"] = "second record, revised"
+
+	check := func(ps *packedStore, label string) {
+		for key, val := range records {
+			data, err := ps.Get(key)
+			if err != nil || string(data) != val {
+				t.Errorf("%s Get(%s) = %q, %v, want %q", label, key, data, err, val)
+			}
+			if !ps.Has(key) {
+				t.Errorf("%s Has(%s) = false", label, key)
+			}
+		}
+		var keys []string
+		ps.Walk(func(key string) {
+			keys = append(keys, key)
+		})
+		if len(keys) != len(records) {
+			t.Errorf("%s Walk found %d keys, want %d", label, len(keys), len(records))
+		}
+	}
+
+	check(ps, "open")
+
+	// reopening replays the index log
+	re, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(re, "reopen")
+
+	// deletion is seen by this store, by a reader that catches up, and after reopening
+	err = ps.Delete("02/53/93/2539356.xml.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(records, "02/53/93/2539356.xml.gz")
+
+	for label, store := range map[string]*packedStore{"writer": ps, "reader": re} {
+		_, err = store.Get("02/53/93/2539356.xml.gz")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s Get after Delete = %v, want ErrNotExist", label, err)
+		}
+	}
+
+	re, err = openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(re, "reopen after delete")
+
+	// deleting a missing key is not an error
+	err = ps.Delete("99/99/99/999999.xml.gz")
+	if err != nil {
+		t.Errorf("Delete(missing) = %v", err)
+	}
+}
+
+func TestPackedStoreRollover(t *testing.T) {
+
+	stsh := t.TempDir()
+
+	ps, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.maxSize = 64
+
+	for i := 0; i < 10; i++ {
+		err = ps.Put(fmt.Sprintf("00/00/%02d/%d.xml", i, i), []byte(fmt.Sprintf("%020d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ps.current < 4 {
+		t.Errorf("wrote %d segments, expected at least 4", ps.current)
+	}
+
+	re, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		data, err := re.Get(fmt.Sprintf("00/00/%02d/%d.xml", i, i))
+		if err != nil || string(data) != fmt.Sprintf("%020d", i) {
+			t.Errorf("Get(%d) = %q, %v", i, data, err)
+		}
+	}
+}
+
+func TestPackedStoreWriters(t *testing.T) {
+
+	stsh := t.TempDir()
+
+	// separate stores stand in for separate processes writing one archive
+	var stores []*packedStore
+	for i := 0; i < 4; i++ {
+		ps, err := openPackedStore(stsh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, ps)
+	}
+
+	var wg sync.WaitGroup
+	for w, ps := range stores {
+		wg.Add(1)
+		go func(w int, ps *packedStore) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("%02d/%02d/00/%d%02d.xml", w, i, w, i)
+				err := ps.Put(key, []byte(key+" contents"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w, ps)
+	}
+	wg.Wait()
+
+	re, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	re.Walk(func(key string) {
+		keys = append(keys, key)
+	})
+	if len(keys) != 200 {
+		t.Fatalf("found %d keys, want 200", len(keys))
+	}
+
+	slices.Sort(keys)
+	for _, key := range keys {
+		data, err := re.Get(key)
+		if err != nil || string(data) != key+" contents" {
+			t.Errorf("Get(%s) = %q, %v", key, data, err)
+		}
+	}
+}
+
+func TestPackedStoreTornEntry(t *testing.T) {
+
+	stsh := t.TempDir()
+
+	ps, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ps.Put("00/00/01/1.xml", []byte("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// crashed writer left the start of an entry, key length and part of its key
+	fl, err := os.OpenFile(filepath.Join(stsh, "Packed", packedIndexName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.Write([]byte{14, 0, '0', '0', '/'})
+	fl.Close()
+
+	re, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = re.Put("00/00/02/2.xml", []byte("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := openPackedStore(stsh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"00/00/01/1.xml": "one", "00/00/02/2.xml": "two"} {
+		data, err := last.Get(key)
+		if err != nil || string(data) != want {
+			t.Errorf("Get(%s) = %q, %v", key, data, err)
+		}
+	}
+}
@@ -106,6 +106,7 @@ const (
 	AUTHOR
 	PROSE
 	JOURNAL
+	ORCID
 	YEAR
 	MONTH
 	DATE
@@ -122,6 +123,7 @@ const (
 	WORDS
 	PAIRS
 	PAIRX
+	PHRASES
 	SPLIT
 	ORDER
 	REVERSE
@@ -129,6 +131,10 @@ const (
 	PENTAMERS
 	CLAUSES
 	INDEXER
+	STEMMER
+	AFFILIATION
+	VERNACULAR
+	SECTIONS
 	MESHCODE
 	MATRIX
 	CLASSIFY
@@ -396,6 +402,7 @@ var argTypeIs = map[string]ArgumentType{
 	"-prose":        EXTRACTION,
 	"-jour":         EXTRACTION,
 	"-journal":      EXTRACTION,
+	"-orcid":        EXTRACTION,
 	"-year":         EXTRACTION,
 	"-month":        EXTRACTION,
 	"-date":         EXTRACTION,
@@ -412,6 +419,7 @@ var argTypeIs = map[string]ArgumentType{
 	"-words":        EXTRACTION,
 	"-pairs":        EXTRACTION,
 	"-pairx":        EXTRACTION,
+	"-phrases":      EXTRACTION,
 	"-split":        EXTRACTION,
 	"-order":        EXTRACTION,
 	"-reverse":      EXTRACTION,
@@ -419,6 +427,10 @@ var argTypeIs = map[string]ArgumentType{
 	"-pentamers":    EXTRACTION,
 	"-clauses":      EXTRACTION,
 	"-indexer":      EXTRACTION,
+	"-stemmer":      EXTRACTION,
+	"-affiliation":  EXTRACTION,
+	"-vernacular":   EXTRACTION,
+	"-sections":     EXTRACTION,
 	"-meshcode":     EXTRACTION,
 	"-matrix":       EXTRACTION,
 	"-classify":     EXTRACTION,
@@ -553,6 +565,7 @@ var opTypeIs = map[string]OpType{
 	"-prose":        PROSE,
 	"-jour":         JOURNAL,
 	"-journal":      JOURNAL,
+	"-orcid":        ORCID,
 	"-year":         YEAR,
 	"-month":        MONTH,
 	"-date":         DATE,
@@ -569,6 +582,7 @@ var opTypeIs = map[string]OpType{
 	"-words":        WORDS,
 	"-pairs":        PAIRS,
 	"-pairx":        PAIRX,
+	"-phrases":      PHRASES,
 	"-split":        SPLIT,
 	"-order":        ORDER,
 	"-reverse":      REVERSE,
@@ -576,6 +590,10 @@ var opTypeIs = map[string]OpType{
 	"-pentamers":    PENTAMERS,
 	"-clauses":      CLAUSES,
 	"-indexer":      INDEXER,
+	"-stemmer":      STEMMER,
+	"-affiliation":  AFFILIATION,
+	"-vernacular":   VERNACULAR,
+	"-sections":     SECTIONS,
 	"-meshcode":     MESHCODE,
 	"-matrix":       MATRIX,
 	"-classify":     CLASSIFY,
@@ -875,6 +893,47 @@ func DebugBlock(blk *Block, depth int) {
 // ParseArguments parses nested exploration instruction from command-line arguments
 func ParseArguments(cmdargs []string, pttrn string) *Block {
 
+	return parseArguments(cmdargs, pttrn, func(format string, params ...any) {
+		DisplayError(format, params...)
+		os.Exit(1)
+	})
+}
+
+// argumentError carries a ParseArguments error message out of a CheckArguments panic
+type argumentError struct {
+	msg string
+}
+
+func (e argumentError) Error() string {
+	return e.msg
+}
+
+// CheckArguments parses exploration instructions like ParseArguments, but returns
+// an error instead of exiting, so a server can reject bad xtract arguments
+func CheckArguments(cmdargs []string, pttrn string) (blk *Block, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			blk = nil
+			ae, ok := r.(argumentError)
+			if ok {
+				err = ae
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	blk = parseArguments(cmdargs, pttrn, func(format string, params ...any) {
+		panic(argumentError{msg: fmt.Sprintf(format, params...)})
+	})
+
+	return blk, nil
+}
+
+// parseArguments calls fail, which must not return, on the first error
+func parseArguments(cmdargs []string, pttrn string, fail func(string, ...any)) *Block {
+
 	// different names of exploration control arguments allow multiple levels of nested "for" loops in a linear command line
 	// (capitalized versions for backward-compatibility with original Perl implementation handling of recursive definitions)
 	var (
@@ -923,8 +982,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 		ch, ok := HasLeadingUnicodeDash(str)
 		if ok {
-			DisplayError("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, str)
-			os.Exit(1)
+			fail("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, str)
 		}
 
 		op, ok := opTypeIs[str]
@@ -1115,15 +1173,13 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 		// check if last character is right square bracket
 		if !strings.HasSuffix(rnge, "]") {
-			DisplayError("Unrecognized range %s", rnge)
-			os.Exit(1)
+			fail("Unrecognized range %s", rnge)
 		}
 
 		rnge = strings.TrimSuffix(rnge, "]")
 
 		if rnge == "" {
-			DisplayError("Empty range %s[]", item)
-			os.Exit(1)
+			fail("Empty range %s[]", item)
 		}
 
 		// check for caret [after^before] variant that allows vertical bar in contents
@@ -1133,8 +1189,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			// spacing matters, so do not call TrimSpace
 
 			if strL == "" && strR == "" {
-				DisplayError("Empty range %s[^]", item)
-				os.Exit(1)
+				fail("Empty range %s[^]", item)
 			}
 
 			typL = STRINGRANGE
@@ -1151,8 +1206,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			// spacing matters, so do not call TrimSpace
 
 			if strL == "" && strR == "" {
-				DisplayError("Empty range %s[|]", item)
-				os.Exit(1)
+				fail("Empty range %s[|]", item)
 			}
 
 			typL = STRINGRANGE
@@ -1164,8 +1218,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 		// otherwise must have colon and integers [from:to] within brackets
 		if !strings.Contains(rnge, ":") {
-			DisplayError("Colon missing in range %s[%s]", item, rnge)
-			os.Exit(1)
+			fail("Colon missing in range %s[%s]", item, rnge)
 		}
 
 		// split at colon
@@ -1175,16 +1228,14 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 		rgt = strings.TrimSpace(rgt)
 
 		if lft == "" && rgt == "" {
-			DisplayError("Empty range %s[:]", item)
-			os.Exit(1)
+			fail("Empty range %s[:]", item)
 		}
 
 		// for variable, parse optional +/- offset suffix
 		parseOffset := func(str string) (string, int) {
 
 			if str == "" || str[0] == ' ' {
-				DisplayError("Unrecognized variable '&%s'", str)
-				os.Exit(1)
+				fail("Unrecognized variable '&%s'", str)
 			}
 
 			pls := ""
@@ -1199,15 +1250,13 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			if pls != "" {
 				val, err := strconv.Atoi(pls)
 				if err != nil {
-					DisplayError("Unrecognized range adjustment &%s+%s", str, pls)
-					os.Exit(1)
+					fail("Unrecognized range adjustment &%s+%s", str, pls)
 				}
 				ofs = val
 			} else if mns != "" {
 				val, err := strconv.Atoi(mns)
 				if err != nil {
-					DisplayError("Unrecognized range adjustment &%s-%s", str, mns)
-					os.Exit(1)
+					fail("Unrecognized range adjustment &%s-%s", str, mns)
 				}
 				ofs = -val
 			}
@@ -1223,18 +1272,15 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 			val, err := strconv.Atoi(str)
 			if err != nil {
-				DisplayError("Unrecognized range component %s[%s:]", item, str)
-				os.Exit(1)
+				fail("Unrecognized range component %s[%s:]", item, str)
 			}
 			if mustBePositive {
 				if val < 1 {
-					DisplayError("Range component %s[%s:] must be positive", item, str)
-					os.Exit(1)
+					fail("Range component %s[%s:] must be positive", item, str)
 				}
 			} else {
 				if val == 0 {
-					DisplayError("Range component %s[%s:] must not be zero", item, str)
-					os.Exit(1)
+					fail("Range component %s[%s:] must not be zero", item, str)
 				}
 			}
 
@@ -1279,23 +1325,19 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 		ch, ok := HasLeadingUnicodeDash(txt)
 		if ok {
-			DisplayError("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, txt)
-			os.Exit(1)
+			fail("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, txt)
 		}
 
 		if txt != "-if" && txt != "-unless" && txt != "-select" && txt != "-match" && txt != "-avoid" && txt != "-position" {
-			DisplayError("Missing -if command before '%s'", txt)
-			os.Exit(1)
+			fail("Missing -if command before '%s'", txt)
 		}
 		if txt == "-position" && max > 2 {
-			DisplayError("Cannot combine -position with -if or -unless commands")
-			os.Exit(1)
+			fail("Cannot combine -position with -if or -unless commands")
 		}
 		// check for missing argument after last condition
 		txt = arguments[max-1]
 		if len(txt) > 0 && txt[0] == '-' {
-			DisplayError("Item missing after %s command", txt)
-			os.Exit(1)
+			fail("Item missing after %s command", txt)
 		}
 
 		cond := make([]*Operation, 0, max)
@@ -1319,8 +1361,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 			if str == "" && rnge != "" {
 				// rnge should already end with right square bracket
-				DisplayError("Variable missing in range specification [%s", rnge)
-				os.Exit(1)
+				fail("Variable missing in range specification [%s", rnge)
 			}
 
 			typL, strL, intL, typR, strR, intR := parseRange(str, rnge)
@@ -1333,11 +1374,9 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 						status = VARIABLE
 						str = str[1:]
 					} else if strings.Contains(str, ":") {
-						DisplayError("Unsupported construct '%s', use -if &VARIABLE -equals VALUE instead", str)
-						os.Exit(1)
+						fail("Unsupported construct '%s', use -if &VARIABLE -equals VALUE instead", str)
 					} else {
-						DisplayError("Unrecognized variable '%s'", str)
-						os.Exit(1)
+						fail("Unrecognized variable '%s'", str)
 					}
 				case '#':
 					status = COUNT
@@ -1421,21 +1460,18 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 				ch, ok := HasLeadingUnicodeDash(str)
 				if ok {
-					DisplayError("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, str)
-					os.Exit(1)
+					fail("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, str)
 				}
 
 				if len(str) < 1 || str[0] != '-' {
-					DisplayError("Unexpected '%s' argument after '%s'", str, last)
-					os.Exit(1)
+					fail("Unexpected '%s' argument after '%s'", str, last)
 				}
 				expectDash = false
 
 			} else {
 
 				if len(str) > 0 && str[0] == '-' {
-					DisplayError("Unexpected '%s' command after '%s'", str, last)
-					os.Exit(1)
+					fail("Unexpected '%s' command after '%s'", str, last)
 				}
 				expectDash = true
 			}
@@ -1446,8 +1482,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 				status, _ = parseFlag(str, arguments[idx:])
 			case POSITION:
 				if cmds.Position != "" {
-					DisplayError("-position '%s' conflicts with existing '%s'", str, cmds.Position)
-					os.Exit(1)
+					fail("-position '%s' conflicts with existing '%s'", str, cmds.Position)
 				}
 				cmds.Position = str
 				status = UNSET
@@ -1457,8 +1492,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			case IF:
 				numIf++
 				if numIf > 1 || numUnless > 1 || numIf > 0 && numUnless > 0 {
-					DisplayError("Unexpected '-if %s' after '%s'", str, lastCond)
-					os.Exit(1)
+					fail("Unexpected '-if %s' after '%s'", str, lastCond)
 				}
 				lastCond = "-if " + str
 				op = &Operation{Type: status, Value: str}
@@ -1468,8 +1502,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			case UNLESS:
 				numUnless++
 				if numIf > 1 || numUnless > 1 || numIf > 0 && numUnless > 0 {
-					DisplayError("Unexpected '-unless %s' after '%s'", str, lastCond)
-					os.Exit(1)
+					fail("Unexpected '-unless %s' after '%s'", str, lastCond)
 				}
 				lastCond = "-unless " + str
 				op = &Operation{Type: status, Value: str}
@@ -1491,8 +1524,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 					op.Stages = append(op.Stages, tsk)
 					op = nil
 				} else {
-					DisplayError("Unexpected adjacent string match constraints")
-					os.Exit(1)
+					fail("Unexpected adjacent string match constraints")
 				}
 				status = UNSET
 			case MATCHES:
@@ -1506,8 +1538,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 					op.Stages = append(op.Stages, tsk)
 					op = nil
 				} else {
-					DisplayError("Unexpected adjacent string match constraints")
-					os.Exit(1)
+					fail("Unexpected adjacent string match constraints")
 				}
 				status = UNSET
 			case RESEMBLES:
@@ -1521,15 +1552,13 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 					op.Stages = append(op.Stages, tsk)
 					op = nil
 				} else {
-					DisplayError("Unexpected adjacent string match constraints")
-					os.Exit(1)
+					fail("Unexpected adjacent string match constraints")
 				}
 				status = UNSET
 			case ISEQUALTO, DIFFERSFROM:
 				if op != nil {
 					if len(str) < 1 {
-						DisplayError("Empty conditional argument")
-						os.Exit(1)
+						fail("Empty conditional argument")
 					}
 					ch := str[0]
 					// uses element as second argument
@@ -1538,8 +1567,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 						// check for pound, percent, or caret character at beginning of element (undocumented)
 						str = str[1:]
 						if len(str) < 1 {
-							DisplayError("Unexpected conditional constraints")
-							os.Exit(1)
+							fail("Unexpected conditional constraints")
 						}
 						ch = str[0]
 					}
@@ -1553,8 +1581,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 						tsk := &Step{Type: status, Value: orig, Parent: prnt, Match: match, Attrib: attrib, Wild: wildcard}
 						op.Stages = append(op.Stages, tsk)
 					} else {
-						DisplayError("Unexpected conditional constraints")
-						os.Exit(1)
+						fail("Unexpected conditional constraints")
 					}
 					op = nil
 				}
@@ -1566,8 +1593,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 						str = str[1:]
 					}
 					if len(str) < 1 {
-						DisplayError("Empty numeric match constraints")
-						os.Exit(1)
+						fail("Empty numeric match constraints")
 					}
 					ch := str[0]
 					if (ch >= '0' && ch <= '9') || ch == '-' || ch == '+' {
@@ -1581,8 +1607,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 							// check for pound, percent, or caret character at beginning of element (undocumented)
 							str = str[1:]
 							if len(str) < 1 {
-								DisplayError("Unexpected numeric match constraints")
-								os.Exit(1)
+								fail("Unexpected numeric match constraints")
 							}
 							ch = str[0]
 						}
@@ -1596,22 +1621,18 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 							tsk := &Step{Type: status, Value: orig, Parent: prnt, Match: match, Attrib: attrib, Wild: wildcard}
 							op.Stages = append(op.Stages, tsk)
 						} else {
-							DisplayError("Unexpected numeric match constraints")
-							os.Exit(1)
+							fail("Unexpected numeric match constraints")
 						}
 					}
 					op = nil
 				} else {
-					DisplayError("Unexpected adjacent numeric match constraints")
-					os.Exit(1)
+					fail("Unexpected adjacent numeric match constraints")
 				}
 				status = UNSET
 			case UNRECOGNIZED:
-				DisplayError("Unrecognized argument '%s'", str)
-				os.Exit(1)
+				fail("Unrecognized argument '%s'", str)
 			default:
-				DisplayError("Unexpected argument '%s'", str)
-				os.Exit(1)
+				fail("Unexpected argument '%s'", str)
 			}
 		}
 
@@ -1630,20 +1651,17 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 		ch, ok := HasLeadingUnicodeDash(txt)
 		if ok {
-			DisplayError("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, txt)
-			os.Exit(1)
+			fail("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, txt)
 		}
 
 		if len(txt) < 1 || txt[0] != '-' {
-			DisplayError("Missing -element command before '%s'", txt)
-			os.Exit(1)
+			fail("Missing -element command before '%s'", txt)
 		}
 		// check for missing argument after last -element (or -first, etc.) command
 		txt = arguments[max-1]
 		if len(txt) > 0 && txt[0] == '-' {
 			if txt == "-rst" {
-				DisplayError("Unexpected position for %s command", txt)
-				os.Exit(1)
+				fail("Unexpected position for %s command", txt)
 			} else if txt == "-clr" {
 				// main loop runs out after trailing -clr, add another one so this one will be executed
 				arguments = append(arguments, "-clr")
@@ -1651,11 +1669,9 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			} else if txt == "-cls" || txt == "-slf" {
 				// okay at end
 			} else if max < 2 || arguments[max-2] != "-lbl" {
-				DisplayError("Item missing after %s command", txt)
-				os.Exit(1)
+				fail("Item missing after %s command", txt)
 			} else if max < 3 || (arguments[max-3] != "-att" && arguments[max-3] != "-atr") {
-				DisplayError("Item missing after %s command", txt)
-				os.Exit(1)
+				fail("Item missing after %s command", txt)
 			}
 		}
 
@@ -1669,8 +1685,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 			ch, ok := HasLeadingUnicodeDash(str)
 			if ok {
-				DisplayError("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, str)
-				os.Exit(1)
+				fail("Unicode dash %d replaced expected ASCII hyphen in '%s'", ch, str)
 			}
 
 			return str
@@ -1712,16 +1727,13 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 				status = UNSET
 			case FWD, AWD, PKG:
 			case UNSET:
-				DisplayError("No -element before '%s'", str)
-				os.Exit(1)
+				fail("No -element before '%s'", str)
 			case UNRECOGNIZED:
-				DisplayError("Unrecognized argument '%s'", str)
-				os.Exit(1)
+				fail("Unrecognized argument '%s'", str)
 			default:
 				if !isExtraction {
 					// not ELEMENT through HGVS
-					DisplayError("Misplaced %s command", str)
-					os.Exit(1)
+					fail("Misplaced %s command", str)
 				}
 			}
 
@@ -1753,8 +1765,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 				if item == "" && rnge != "" {
 					// rnge should already end with right square bracket
-					DisplayError("Variable missing in range specification [%s", rnge)
-					os.Exit(1)
+					fail("Variable missing in range specification [%s", rnge)
 				}
 
 				typL, strL, intL, typR, strR, intR := parseRange(item, rnge)
@@ -1767,8 +1778,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 							status = VARIABLE
 							item = item[1:]
 						} else {
-							DisplayError("Unrecognized variable '%s'", item)
-							os.Exit(1)
+							fail("Unrecognized variable '%s'", item)
 						}
 					case '#':
 						status = COUNT
@@ -1836,8 +1846,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 					seqtype, ok := sequenceTypeIs[seq]
 					slock.RUnlock()
 					if !ok {
-						DisplayError("Element '%s' is not suitable for sequence coordinate conversion", item)
-						os.Exit(1)
+						fail("Element '%s' is not suitable for sequence coordinate conversion", item)
 					}
 					switch status {
 					case ZEROBASED:
@@ -1873,7 +1882,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 					}
 				}
 
-				unescape := (status != INDEXER && status != RAW)
+				unescape := (status != INDEXER && status != STEMMER && status != AFFILIATION && status != VERNACULAR && status != SECTIONS && status != RAW)
 
 				tsk := &Step{Type: status, Value: item, Parent: prnt, Match: match, Attrib: attrib,
 					TypL: typL, StrL: strL, IntL: intL, TypR: typR, StrR: strR, IntR: intR,
@@ -1894,8 +1903,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 			idx++
 
 			if argTypeIs[str] == CONDITIONAL {
-				DisplayError("Misplaced %s command", str)
-				os.Exit(1)
+				fail("Misplaced %s command", str)
 			}
 
 			switch status {
@@ -1985,8 +1993,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 				parseSteps(op, pttrn)
 				status = UNSET
 			case UNRECOGNIZED:
-				DisplayError("Unrecognized argument '%s'", str)
-				os.Exit(1)
+				fail("Unrecognized argument '%s'", str)
 			default:
 				if isExtraction {
 					// ELEMENT through HGVS
@@ -2075,16 +2082,13 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 		// reality checks on placement of -else command
 		if foundElse {
 			if len(conditionals) < 1 {
-				DisplayError("Misplaced -else command")
-				os.Exit(1)
+				fail("Misplaced -else command")
 			}
 			if len(alternative) < 1 {
-				DisplayError("Misplaced -else command")
-				os.Exit(1)
+				fail("Misplaced -else command")
 			}
 			if len(parent.Subtasks) > 0 {
-				DisplayError("Misplaced -else command")
-				os.Exit(1)
+				fail("Misplaced -else command")
 			}
 		}
 
@@ -2109,8 +2113,7 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 
 			// extra exploration arguments ending in X are for internal use only
 			if strings.HasSuffix(txt, "X") {
-				DisplayError("Unrecognized argument '%s'", txt)
-				os.Exit(1)
+				fail("Unrecognized argument '%s'", txt)
 			}
 
 			expargs = append(expargs, txt)
@@ -2190,18 +2193,15 @@ func ParseArguments(cmdargs []string, pttrn string) *Block {
 	}
 
 	if numPatterns < 1 {
-		DisplayError("No -pattern in command-line arguments")
-		os.Exit(1)
+		fail("No -pattern in command-line arguments")
 	}
 
 	if numPatterns > 1 {
-		DisplayError("Only one -pattern command is permitted")
-		os.Exit(1)
+		fail("Only one -pattern command is permitted")
 	}
 
 	if noElement && noClose {
-		DisplayError("No -element statement in argument list")
-		os.Exit(1)
+		fail("No -element statement in argument list")
 	}
 
 	return head
@@ -74,6 +74,191 @@ type Clause struct {
 	Wrp  bool
 }
 
+// indexWords normalizes the text of one element for a positional index, sending each
+// indexed term and its word position to proc, and returns the last word position and
+// the padded position from which counting continues in the next element, with stop
+// words and stemming taken from the language analyzer
+func indexWords(str string, cumulative int, anlz *Analyzer, stem bool, proc func(string, int)) (int, int) {
+
+	if str == "" || str == "[Not Available]." {
+		return cumulative, cumulative
+	}
+
+	// remove parentheses to keep bracketed subscripts
+	/*
+		var (
+			buffer []rune
+			prev   rune
+			inside bool
+		)
+		for _, ch := range str {
+			if ch == '(' && prev != ' ' {
+				inside = true
+			} else if ch == ')' && inside {
+				inside = false
+			} else {
+				buffer = append(buffer, ch)
+			}
+			prev = ch
+		}
+		str = string(buffer)
+	*/
+
+	if IsNotASCII(str) {
+		str = FixMisusedLetters(str, true, false, true)
+		str = TransformAccents(str, true, true)
+		if HasUnicodeMarkup(str) {
+			str = RepairUnicodeMarkup(str, SPACE)
+		}
+	}
+
+	str = strings.ToLower(str)
+
+	if HasBadSpace(str) {
+		str = CleanupBadSpaces(str)
+	}
+	if HasAngleOrAmpersandEncoding(str) {
+		str = RepairEncodedMarkup(str)
+		str = RepairTableMarkup(str, SPACE)
+		str = RepairScriptMarkup(str, SPACE)
+		str = RepairMathMLMarkup(str, SPACE)
+		// RemoveEmbeddedMarkup must be called before UnescapeString, which was suppressed in ExploreElements
+		str = RemoveEmbeddedMarkup(str)
+	}
+
+	if HasAmpOrNotASCII(str) {
+		str = html.UnescapeString(str)
+		str = strings.ToLower(str)
+	}
+
+	if HasAdjacentSpaces(str) {
+		str = CompressRunsOfSpaces(str)
+	}
+
+	str = strings.Replace(str, "(", " ", -1)
+	str = strings.Replace(str, ")", " ", -1)
+
+	str = strings.Replace(str, "_", " ", -1)
+
+	if HasHyphenOrApostrophe(str) {
+		str = FixSpecialCases(str)
+	}
+
+	str = strings.Replace(str, "-", " ", -1)
+
+	// remove trailing punctuation from each word
+	var arry []string
+
+	terms := strings.Fields(str)
+	for _, item := range terms {
+		max := len(item)
+		for max > 1 {
+			ch := item[max-1]
+			if ch != '.' && ch != ',' && ch != ':' && ch != ';' {
+				break
+			}
+			// trim trailing period, comma, colon, and semicolon
+			item = item[:max-1]
+			// continue checking for runs of punctuation at end
+			max--
+		}
+		if item == "" {
+			continue
+		}
+		arry = append(arry, item)
+	}
+
+	// rejoin into string
+	cleaned := strings.Join(arry, " ")
+
+	// break clauses at punctuation other than space or underscore, and at non-ASCII characters
+	clauses := strings.FieldsFunc(cleaned, func(c rune) bool {
+		return (!unicode.IsLetter(c) && !unicode.IsDigit(c)) && c != ' ' && c != '_' || c > 127
+	})
+
+	// space replaces plus sign to separate runs of unpunctuated words
+	phrases := strings.Join(clauses, " ")
+
+	// break phrases into individual words
+	words := strings.Fields(phrases)
+
+	for _, item := range words {
+
+		cumulative++
+
+		// skip at site of punctuation break
+		if item == "+" {
+			continue
+		}
+
+		// skip if just a period, but allow terms that are all digits or period
+		if item == "." {
+			continue
+		}
+
+		// optional stop word removal
+		if deStop && anlz.IsStopWord(item) {
+			continue
+		}
+
+		if stem {
+			// optionally apply stemming algorithm
+			item = anlz.Stem(item)
+		}
+
+		// index single normalized term with positions
+		proc(item, cumulative)
+	}
+
+	last := cumulative
+
+	// pad to avoid false positive proximity match of words in adjacent paragraphs
+	rounded := ((cumulative + 99) / 100) * 100
+	if rounded-cumulative < 20 {
+		rounded += 100
+	}
+	cumulative = rounded
+
+	return last, cumulative
+}
+
+// writeIndices prints sorted terms of a positional index field, with positions in an attribute
+func writeIndices(buffer *strings.Builder, label string, indices map[string][]string) {
+
+	if len(indices) < 1 {
+		return
+	}
+
+	arry := slices.Sorted(maps.Keys(indices))
+
+	last := ""
+	for _, item := range arry {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if item == last {
+			// skip duplicate entry
+			continue
+		}
+		buffer.WriteString("<")
+		buffer.WriteString(label)
+		if len(indices[item]) > 0 {
+			// use attribute for position
+			buffer.WriteString(" pos=\"")
+			attr := strings.Join(indices[item], ",")
+			buffer.WriteString(attr)
+			buffer.WriteString("\"")
+		}
+		buffer.WriteString(">")
+		buffer.WriteString(item)
+		buffer.WriteString("</")
+		buffer.WriteString(label)
+		buffer.WriteString(">")
+		last = item
+	}
+}
+
 // processClause handles comma-separated -element arguments
 func processClause(
 	curr *XMLNode,
@@ -109,10 +294,10 @@ func processClause(
 		rlock.Unlock()
 	}
 
-	// field for -indexer derived from -pfx argument set by -wrp
+	// field for -indexer or -stemmer derived from -pfx argument set by -wrp
 	indexerField := ""
 
-	if status == INDEXER {
+	if status == INDEXER || status == STEMMER || status == AFFILIATION || status == VERNACULAR || status == SECTIONS {
 		if strings.HasPrefix(pfx, "<") && strings.HasSuffix(pfx, ">") && !strings.Contains(pfx, "/") {
 			// take label from -pfx argument minus the angle brackets added by -wrp
 			indexerField = strings.TrimPrefix(pfx, "<")
@@ -790,6 +975,17 @@ func processClause(
 			}
 		})
 
+	case ORCID:
+		processElement(func(str string) {
+			str = NormalizeORCID(str)
+			if str != "" {
+				ok = true
+				buffer.WriteString(between)
+				buffer.WriteString(str)
+				between = sep
+			}
+		})
+
 	case YEAR:
 		year := ""
 
@@ -1893,13 +2089,36 @@ func processClause(
 			}
 		})
 
-	case INDEXER:
-		// build positional index with a choice of TITL, TIAB, ABST, TERM, TEXT, and STEM field names
+	case INDEXER, STEMMER, AFFILIATION, VERNACULAR:
+		// build positional index with a choice of TITL, TIAB, ABST, TERM, TEXT, and STEM field names,
+		// or any field name with -stemmer
 		label := "TEXT"
+		if status == AFFILIATION {
+			label = "AFFL"
+		}
+		if status == VERNACULAR {
+			label = "VERN"
+		}
 		if indexerField != "" {
 			label = indexerField
 		}
 
+		anlz := languageAnalyzers["eng"]
+		stem := label == "STEM" || status == STEMMER
+
+		if status == VERNACULAR {
+			// stop words and stemmer (for VSTM) follow the record's Language, not English
+			var langs []string
+			ExploreElements(curr, mask, "", "Language", "", false, true, level, func(str string, lvl int) {
+				langs = append(langs, str)
+			})
+			anlz = vernacularAnalyzer(langs)
+			stem = label == "VSTM"
+		}
+
+		// affiliations repeated for each author are only indexed once, keeping positions in range
+		seen := make(map[string]bool)
+
 		indices := make(map[string][]string)
 
 		cumulative := 0
@@ -1922,189 +2141,58 @@ func processClause(
 		}
 
 		processElement(func(str string) {
-
-			if str == "" {
-				return
-			}
-
-			if str == "[Not Available]." {
-				return
-			}
-
-			// remove parentheses to keep bracketed subscripts
-			/*
-				var (
-					buffer []rune
-					prev   rune
-					inside bool
-				)
-				for _, ch := range str {
-					if ch == '(' && prev != ' ' {
-						inside = true
-					} else if ch == ')' && inside {
-						inside = false
-					} else {
-						buffer = append(buffer, ch)
-					}
-					prev = ch
-				}
-				str = string(buffer)
-			*/
-
-			if IsNotASCII(str) {
-				str = FixMisusedLetters(str, true, false, true)
-				str = TransformAccents(str, true, true)
-				if HasUnicodeMarkup(str) {
-					str = RepairUnicodeMarkup(str, SPACE)
-				}
-			}
-
-			str = strings.ToLower(str)
-
-			if HasBadSpace(str) {
-				str = CleanupBadSpaces(str)
-			}
-			if HasAngleOrAmpersandEncoding(str) {
-				str = RepairEncodedMarkup(str)
-				str = RepairTableMarkup(str, SPACE)
-				str = RepairScriptMarkup(str, SPACE)
-				str = RepairMathMLMarkup(str, SPACE)
-				// RemoveEmbeddedMarkup must be called before UnescapeString, which was suppressed in ExploreElements
-				str = RemoveEmbeddedMarkup(str)
-			}
-
-			if HasAmpOrNotASCII(str) {
-				str = html.UnescapeString(str)
-				str = strings.ToLower(str)
-			}
-
-			if HasAdjacentSpaces(str) {
-				str = CompressRunsOfSpaces(str)
-			}
-
-			str = strings.Replace(str, "(", " ", -1)
-			str = strings.Replace(str, ")", " ", -1)
-
-			str = strings.Replace(str, "_", " ", -1)
-
-			if HasHyphenOrApostrophe(str) {
-				str = FixSpecialCases(str)
-			}
-
-			str = strings.Replace(str, "-", " ", -1)
-
-			// remove trailing punctuation from each word
-			var arry []string
-
-			terms := strings.Fields(str)
-			for _, item := range terms {
-				max := len(item)
-				for max > 1 {
-					ch := item[max-1]
-					if ch != '.' && ch != ',' && ch != ':' && ch != ';' {
-						break
-					}
-					// trim trailing period, comma, colon, and semicolon
-					item = item[:max-1]
-					// continue checking for runs of punctuation at end
-					max--
-				}
-				if item == "" {
-					continue
+			if status == AFFILIATION {
+				key := strings.ToLower(CleanupAuthor(str, false))
+				if seen[key] {
+					return
 				}
-				arry = append(arry, item)
+				seen[key] = true
 			}
-
-			// rejoin into string
-			cleaned := strings.Join(arry, " ")
-
-			// break clauses at punctuation other than space or underscore, and at non-ASCII characters
-			clauses := strings.FieldsFunc(cleaned, func(c rune) bool {
-				return (!unicode.IsLetter(c) && !unicode.IsDigit(c)) && c != ' ' && c != '_' || c > 127
+			_, cumulative = indexWords(str, cumulative, anlz, stem, func(item string, pos int) {
+				addItem(item, pos)
+				ok = true
 			})
+		})
 
-			// space replaces plus sign to separate runs of unpunctuated words
-			phrases := strings.Join(clauses, " ")
-
-			// break phrases into individual words
-			words := strings.Fields(phrases)
-
-			for _, item := range words {
-
-				cumulative++
-
-				// skip at site of punctuation break
-				if item == "+" {
-					continue
-				}
-
-				// skip if just a period, but allow terms that are all digits or period
-				if item == "." {
-					continue
-				}
-
-				// optional stop word removal
-				if deStop && IsStopWord(item) {
-					continue
-				}
+		if ok {
+			writeIndices(&buffer, label, indices)
+		}
 
-				if label == "STEM" {
-					// optionally apply stemming algorithm
-					item = porter2.Stem(item)
-					item = strings.TrimSpace(item)
-				}
+	case SECTIONS:
+		// positional indices of structured abstract sections, counting words in every element
+		// so positions agree with TIAB built by -indexer on the same elements
+		indices := make(map[string]map[string][]string)
 
-				// index single normalized term with positions
-				addItem(item, cumulative)
-				ok = true
-			}
+		cumulative := 0
 
-			// pad to avoid false positive proximity match of words in adjacent paragraphs
-			rounded := ((cumulative + 99) / 100) * 100
-			if rounded-cumulative < 20 {
-				rounded += 100
-			}
-			cumulative = rounded
-		})
+		for _, stage := range stages {
+			ExploreNodes(curr, stage.Parent, stage.Match, 0, level, func(node *XMLNode, idx, lvl int) {
 
-		prepareIndices := func() {
+				label := sectionField(node)
 
-			if len(indices) < 1 {
-				return
-			}
+				ExploreElements(node, mask, "", node.Name, "", stage.Wild, stage.Unesc, lvl, func(str string, lv int) {
 
-			arry := slices.Sorted(maps.Keys(indices))
+					// escape as -wrp does for -indexer
+					str = html.EscapeString(str)
 
-			last := ""
-			for _, item := range arry {
-				item = strings.TrimSpace(item)
-				if item == "" {
-					continue
-				}
-				if item == last {
-					// skip duplicate entry
-					continue
-				}
-				buffer.WriteString("<")
-				buffer.WriteString(label)
-				if len(indices[item]) > 0 {
-					// use attribute for position
-					buffer.WriteString(" pos=\"")
-					attr := strings.Join(indices[item], ",")
-					buffer.WriteString(attr)
-					buffer.WriteString("\"")
-				}
-				buffer.WriteString(">")
-				buffer.WriteString(item)
-				buffer.WriteString("</")
-				buffer.WriteString(label)
-				buffer.WriteString(">")
-				last = item
-			}
+					_, cumulative = indexWords(str, cumulative, languageAnalyzers["eng"], false, func(item string, pos int) {
+						if label == "" {
+							return
+						}
+						terms, found := indices[label]
+						if !found {
+							terms = make(map[string][]string)
+							indices[label] = terms
+						}
+						terms[item] = append(terms[item], strconv.Itoa(pos))
+						ok = true
+					})
+				})
+			})
 		}
 
-		if ok {
-			prepareIndices()
+		for _, label := range slices.Sorted(maps.Keys(indices)) {
+			writeIndices(&buffer, label, indices[label])
 		}
 
 	case TERMS:
@@ -2244,6 +2332,26 @@ func processClause(
 			}
 		})
 
+	case PHRASES:
+		// -with can change the maximum number of words in a phrase
+		maxWords, err := strconv.Atoi(cls.Wth)
+		if err != nil || maxWords < 2 {
+			maxWords = PhraseWords
+		}
+		processElement(func(str string) {
+			if str != "" {
+
+				str = PrepareForIndexing(str, true, false, true, true, true)
+
+				for _, item := range NounPhrases(str, maxWords) {
+					ok = true
+					buffer.WriteString(between)
+					buffer.WriteString(item)
+					between = sep
+				}
+			}
+		})
+
 	case SPLIT:
 		processElement(func(str string) {
 			if str != "" && cls.Wth != "" {
@@ -19,3 +19,17 @@ func TestXMLtoData(t *testing.T) {
 		"BLUE\t93\tArizona\n",
 	)
 }
+
+func TestCheckArguments(t *testing.T) {
+
+	blk, err := CheckArguments([]string{"-pattern", "Rec", "-element", "ID"}, "Rec")
+	if err != nil || blk == nil {
+		t.Errorf("CheckArguments(valid) = %v, %v", blk, err)
+	}
+
+	// errors that make ParseArguments exit are returned instead
+	blk, err = CheckArguments([]string{"-pattern", "Rec", "-element", "ID[:]"}, "Rec")
+	if err == nil || blk != nil {
+		t.Errorf("CheckArguments(empty range) = %v, %v, expected error", blk, err)
+	}
+}
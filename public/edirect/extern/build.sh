@@ -60,6 +60,12 @@ done
 # build all executables for current platform
 for exc in *.go
 do
+  case "$exc" in
+    *_test.go )
+      # run with "go test prep-generif.go prep-generif_test.go"
+      continue
+      ;;
+  esac
   base=${exc%.go}
   go build -o "$base.$platform" "$base.go"
 done
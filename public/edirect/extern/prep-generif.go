@@ -8,6 +8,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"unicode"
@@ -74,7 +75,7 @@ func splitInTwoLeft(str, chr string) (string, string) {
 	return str, ""
 }
 
-func createGeneRIF(tf, sn string) {
+func createGeneRIF(tf, sn string, in io.Reader, out io.Writer) {
 
 	transform := make(map[string]string)
 
@@ -113,23 +114,30 @@ func createGeneRIF(tf, sn string) {
 	count := 0
 	okay := false
 
-	wrtr := bufio.NewWriter(os.Stdout)
+	wrtr := bufio.NewWriter(out)
 
-	scanr := bufio.NewScanner(os.Stdin)
+	scanr := bufio.NewScanner(in)
 
 	currpmid := ""
 
+	// generifs_basic.gz has 5 columns, gene2pubmed.gz has only taxon, gene, and PMID
+	ncols := 0
+	isRIF := false
+
 	// skip first line with column heading names
 	for scanr.Scan() {
 
 		line := scanr.Text()
 		cols := strings.Split(line, "\t")
-		if len(cols) != 5 {
-			displayError("Unexpected number of columns (%d) in generifs_basic.gz", len(cols))
+		ncols = len(cols)
+		if ncols != 5 && ncols != 3 {
+			displayError("Unexpected number of columns (%d) in generifs_basic.gz or gene2pubmed.gz", ncols)
 			os.Exit(1)
 		}
-		if len(cols) != 5 || cols[0] != "#Tax ID" {
-			displayError("Unrecognized contents in generifs_basic.gz")
+		if ncols == 5 && cols[0] == "#Tax ID" {
+			isRIF = true
+		} else if ncols != 3 || cols[0] != "#tax_id" {
+			displayError("Unrecognized contents in generifs_basic.gz or gene2pubmed.gz")
 			os.Exit(1)
 		}
 		break
@@ -141,7 +149,7 @@ func createGeneRIF(tf, sn string) {
 		line := scanr.Text()
 
 		cols := strings.Split(line, "\t")
-		if len(cols) != 5 {
+		if len(cols) != ncols {
 			continue
 		}
 
@@ -185,7 +193,10 @@ func createGeneRIF(tf, sn string) {
 			addItemtoIndex("GENE", gene)
 			gn, ok := transform[gene]
 			if ok && gn != "" {
-				addItemtoIndex("GRIF", gn)
+				if isRIF {
+					addItemtoIndex("GRIF", gn)
+				}
+				// official symbol, also searched as [GSYM]
 				addItemtoIndex("PREF", gn)
 				addItemtoIndex("GENE", gn)
 			}
@@ -234,5 +245,5 @@ func main() {
 		sn = args[1]
 	}
 
-	createGeneRIF(tf, sn)
+	createGeneRIF(tf, sn, os.Stdin, os.Stdout)
 }
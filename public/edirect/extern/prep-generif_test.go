@@ -0,0 +1,75 @@
+// prep-generif_test.go
+
+// Public domain notice for all NCBI EDirect scripts is located at:
+// https://www.ncbi.nlm.nih.gov/books/NBK179288/#chapter6.Public_Domain_Notice
+
+// go test prep-generif.go prep-generif_test.go
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateGeneRIF(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tf := filepath.Join(dir, "genename.txt")
+	sn := filepath.Join(dir, "genesyns.txt")
+	err := os.WriteFile(tf, []byte("672\tBRCA1\n7157\tTP53\n"), 0644)
+	if err == nil {
+		err = os.WriteFile(sn, []byte("672\tRNF53|PPP1R53\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"gene2pubmed",
+			"#tax_id\tGeneID\tPubMed_ID\n" +
+				"9606\t672\t7545954\n" +
+				"9606\t7157\t7545954\n" +
+				"9606\t999999\t8000000\n" +
+				"9606\t672\n",
+			"7545954\tGENE\t672\n" +
+				"7545954\tPREF\tBRCA1\n" +
+				"7545954\tGENE\tBRCA1\n" +
+				"7545954\tGSYN\tRNF53\n" +
+				"7545954\tGENE\tRNF53\n" +
+				"7545954\tGSYN\tPPP1R53\n" +
+				"7545954\tGENE\tPPP1R53\n" +
+				"7545954\tGENE\t7157\n" +
+				"7545954\tPREF\tTP53\n" +
+				"7545954\tGENE\tTP53\n" +
+				"8000000\tGENE\t999999\n"},
+		{"generifs_basic",
+			"#Tax ID\tGene ID\tPubMed ID (PMID) list\tlast update timestamp\tGeneRIF text\n" +
+				"9606\t7157\t9000001,9000002\t2010-01-01 00:00\tp53 text\n",
+			"9000001\tGENE\t7157\n" +
+				"9000001\tGRIF\tTP53\n" +
+				"9000001\tPREF\tTP53\n" +
+				"9000001\tGENE\tTP53\n" +
+				"9000002\tGENE\t7157\n" +
+				"9000002\tGRIF\tTP53\n" +
+				"9000002\tPREF\tTP53\n" +
+				"9000002\tGENE\tTP53\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			createGeneRIF(tf, sn, strings.NewReader(tt.input), &out)
+			if out.String() != tt.want {
+				t.Errorf("createGeneRIF wrote:\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}